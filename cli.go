@@ -1,10 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
+	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
 var (
@@ -14,6 +19,89 @@ var (
 
 func init() {
 	parser.LongDescription = "srclib-go performs Go package, dependency, and source analysis."
+
+	if _, err := parser.AddGroup("Global options", "", &globalOpt); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// GlobalOpt contains the command-line options that apply to all
+// commands.
+type GlobalOpt struct {
+	Verbose   bool   `short:"v" long:"verbose" description:"show debug log messages"`
+	LogFormat string `long:"log-format" description:"log message format: text or json (one JSON object per line)" default:"text" value-name:"FORMAT"`
+
+	Timeout time.Duration `long:"timeout" description:"abort the scan, or the graphing or dependency resolution of a source unit, after this long (e.g., 10m), emitting partial results where possible" value-name:"DURATION"`
+
+	// RepoURI and RootDir are for analyzing a repository whose URI
+	// can't be determined from its VCS remotes (such as a local-only
+	// repository or a CI checkout).
+	RepoURI string `long:"repo-uri" description:"URI of the repository (such as github.com/alice/foo), overriding the repository of the source units that the commands read and setting it on the ones that scan emits" value-name:"URI"`
+	RootDir string `long:"root-dir" description:"root directory of the repository (default: the current directory)" value-name:"DIR"`
+
+	// CacheDir is where cached output is stored, for pipelines that
+	// keep analysis output outside of the repository tree.
+	CacheDir string `long:"cache-dir" description:"directory to store cached output in, created if missing (default: .srclib-cache in the repository root)" value-name:"DIR"`
+}
+
+var globalOpt GlobalOpt
+
+// applyRepoOpt validates the --repo-uri, changes to the --root-dir, and
+// prepares the --cache-dir, if they are set.
+func applyRepoOpt() error {
+	if globalOpt.RepoURI != "" {
+		if err := validateRepoURI(globalOpt.RepoURI); err != nil {
+			return err
+		}
+	}
+	if globalOpt.RootDir != "" {
+		dir, err := filepath.Abs(globalOpt.RootDir)
+		if err != nil {
+			return err
+		}
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+		cwd = getCWD()
+	}
+	if globalOpt.CacheDir != "" {
+		if err := prepareCacheDir(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRepoURI returns an error if uri doesn't look like a
+// repository URI: a host name followed by one or more path components,
+// without a scheme (such as github.com/alice/foo).
+func validateRepoURI(uri string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid --repo-uri %q: %s (it should look like github.com/alice/foo)", uri, reason)
+	}
+	if strings.Contains(uri, "://") {
+		return invalid("it has a URL scheme")
+	}
+	parts := strings.Split(uri, "/")
+	if len(parts) < 2 {
+		return invalid("it has no path after the host")
+	}
+	if !strings.Contains(parts[0], ".") && parts[0] != "localhost" {
+		return invalid("it doesn't start with a host name")
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, " \t\\:?#") {
+			return invalid(fmt.Sprintf("it has an invalid path component %q", part))
+		}
+	}
+	return nil
+}
+
+// overrideRepo sets the repository of u to the --repo-uri, if any.
+func overrideRepo(u *unit.SourceUnit) {
+	if globalOpt.RepoURI != "" {
+		u.Repo = globalOpt.RepoURI
+	}
 }
 
 func getCWD() string {
@@ -26,6 +114,13 @@ func getCWD() string {
 
 func main() {
 	log.SetFlags(0)
+	dir, err := configFileDir(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := applyConfigFile(dir); err != nil {
+		log.Fatal(err)
+	}
 	if _, err := parser.Parse(); err != nil {
 		os.Exit(1)
 	}
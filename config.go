@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"golang.org/x/tools/go/loader"
@@ -23,6 +24,10 @@ var (
 
 	config *srcfileConfig
 
+	// buildOpt holds the build context options specified on the
+	// command line. They take precedence over those in the Srcfile.
+	buildOpt BuildOpt
+
 	// virtualCWD is the vfs cwd that corresponds to the non-vfs cwd, when using
 	// vfs. It is used to determine whether a vfs path is effectively underneath
 	// the cwd.
@@ -38,6 +43,43 @@ func init() {
 	if buildContext.GOPATH == "" {
 		log.Fatal("GOPATH must be set.")
 	}
+
+	_, err := parser.AddGroup("Build options", "", &buildOpt)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// BuildOpt contains the command-line options that determine which
+// files in a Go package are considered (by build constraints) by the
+// scanner and grapher.
+type BuildOpt struct {
+	GOOS   string `long:"goos" description:"target operating system (defaults to the host's GOOS)" value-name:"GOOS"`
+	GOARCH string `long:"goarch" description:"target architecture (defaults to the host's GOARCH)" value-name:"GOARCH"`
+	Tags   string `long:"tags" description:"comma-separated list of build tags to consider satisfied" value-name:"TAGS"`
+
+	// GOROOT overrides the Srcfile's GOROOT, which in turn overrides
+	// the GOROOT environment variable.
+	GOROOT string `long:"goroot" description:"Go root dir containing the standard library and toolchain (overrides the Srcfile GOROOT and $GOROOT)" value-name:"DIR"`
+}
+
+// unitConfig returns the build options that were specified on the
+// command line, in the form of source unit config properties.
+func (o *BuildOpt) unitConfig() map[string]interface{} {
+	cfg := map[string]interface{}{}
+	if o.GOOS != "" {
+		cfg["GOOS"] = o.GOOS
+	}
+	if o.GOARCH != "" {
+		cfg["GOARCH"] = o.GOARCH
+	}
+	if o.Tags != "" {
+		cfg["BuildTags"] = strings.Split(o.Tags, ",")
+	}
+	if o.GOROOT != "" {
+		cfg["GOROOT"] = o.GOROOT
+	}
+	return cfg
 }
 
 type srcfileConfig struct {
@@ -46,7 +88,7 @@ type srcfileConfig struct {
 	// and is set as the GOROOT environment variable.
 	GOROOT string
 
-	// GOPATH's colon-separated dirs, if specified, are made absolute
+	// GOPATH's dirs (separated by os.PathListSeparator), if specified, are made absolute
 	// (prefixed with the directory that the repository being built is
 	// checked out to) and the resulting value is appended to the
 	// GOPATH environment variable during the build.
@@ -55,6 +97,27 @@ type srcfileConfig struct {
 	PkgPatterns []string // pattern passed to `go list` (defaults to {"./..."})
 
 	SourceImports bool
+
+	// GOOS and GOARCH, if specified, are the target operating system
+	// and architecture used to evaluate build constraints. They
+	// default to the host's.
+	GOOS   string
+	GOARCH string
+
+	// BuildTags is the list of build tags to consider satisfied when
+	// evaluating build constraints.
+	BuildTags []string
+
+	// IncludeVendor is whether packages underneath vendor/ dirs (Go
+	// 1.6+ vendoring) are emitted as source units of this repository.
+	// If false, imports of vendored packages are resolved as imports
+	// of the package's original (unvendored) import path.
+	IncludeVendor bool
+
+	// SymlinkAliases maps the import paths of packages through
+	// symlinks to the names of the packages' source units (see
+	// goPackageData.Aliases).
+	SymlinkAliases map[string]string
 }
 
 // unmarshalTypedConfig parses config from the Config field of the source unit.
@@ -81,6 +144,9 @@ func unmarshalTypedConfig(cfg map[string]interface{}) error {
 
 // apply applies the configuration.
 func (c *srcfileConfig) apply() error {
+	if buildOpt.GOROOT != "" {
+		c.GOROOT = buildOpt.GOROOT
+	}
 	if config.GOROOT != "" {
 		// clean/absolutize all paths
 		config.GOROOT = filepath.Clean(config.GOROOT)
@@ -94,7 +160,7 @@ func (c *srcfileConfig) apply() error {
 
 	if config.GOPATH != "" {
 		// clean/absolutize all paths
-		dirs := uniq(strings.Split(config.GOPATH, ":"))
+		dirs := uniq(filepath.SplitList(config.GOPATH))
 		for i, dir := range dirs {
 			dir = filepath.Clean(dir)
 			if !filepath.IsAbs(dir) {
@@ -102,12 +168,35 @@ func (c *srcfileConfig) apply() error {
 			}
 			dirs[i] = dir
 		}
-		config.GOPATH = strings.Join(dirs, ":")
+		config.GOPATH = joinPathList(dirs)
 
-		buildContext.GOPATH += ":" + config.GOPATH
+		// apply may be called more than once, so don't add the dirs
+		// again if they're already in the GOPATH.
+		buildContext.GOPATH = joinPathList(uniq(append(filepath.SplitList(buildContext.GOPATH), dirs...)))
 		loaderConfig.Build = &buildContext
 	}
 
+	// command-line build options override the Srcfile
+	if buildOpt.GOOS != "" {
+		c.GOOS = buildOpt.GOOS
+	}
+	if buildOpt.GOARCH != "" {
+		c.GOARCH = buildOpt.GOARCH
+	}
+	if buildOpt.Tags != "" {
+		c.BuildTags = strings.Split(buildOpt.Tags, ",")
+	}
+	if c.GOOS != "" {
+		buildContext.GOOS = c.GOOS
+	}
+	if c.GOARCH != "" {
+		buildContext.GOARCH = c.GOARCH
+	}
+	if c.BuildTags != nil {
+		buildContext.BuildTags = c.BuildTags
+	}
+	loaderConfig.Build = &buildContext
+
 	loaderConfig.SourceImports = config.SourceImports
 
 	return nil
@@ -123,6 +212,47 @@ func (c *srcfileConfig) env() []string {
 	}
 }
 
+// goTool returns the go tool to run: the one in the GOROOT, if a
+// GOROOT was specified and it has one (so that the go tool agrees with
+// go/build about where the standard library is), or else the one in
+// the PATH.
+func goTool() string {
+	if buildContext.GOROOT != build.Default.GOROOT {
+		name := "go"
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		tool := filepath.Join(buildContext.GOROOT, "bin", name)
+		if _, err := os.Stat(tool); err == nil {
+			return tool
+		}
+	}
+	return "go"
+}
+
+// joinPathList joins dirs into a list (such as GOPATH) separated by
+// os.PathListSeparator.
+func joinPathList(dirs []string) string {
+	return strings.Join(dirs, string(os.PathListSeparator))
+}
+
+// isVendored returns whether importPath refers to a package underneath
+// a vendor/ dir.
+func isVendored(importPath string) bool {
+	return importPath == "vendor" || strings.HasPrefix(importPath, "vendor/") || strings.Contains(importPath, "/vendor/")
+}
+
+// unvendoredImportPath returns the import path of the vendored package
+// importPath as it would be imported if it were not vendored. Nested
+// vendor dirs are handled relative to the innermost vendor dir, so
+// "a/vendor/b/vendor/c" becomes "c".
+func unvendoredImportPath(importPath string) string {
+	if i := strings.LastIndex(importPath, "/vendor/"); i != -1 {
+		return importPath[i+len("/vendor/"):]
+	}
+	return strings.TrimPrefix(importPath, "vendor/")
+}
+
 func pathHasPrefix(path, prefix string) bool {
 	return prefix == "." || path == prefix || strings.HasPrefix(path, prefix+"/")
 }
@@ -140,3 +270,14 @@ func uniq(s []string) []string {
 	}
 	return uniq
 }
+
+// symlinkAlias returns the name of the source unit of the package
+// whose import path through a symlink is importPath, if any. It may be
+// called on a nil config.
+func (c *srcfileConfig) symlinkAlias(importPath string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	name, present := c.SymlinkAliases[importPath]
+	return name, present
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestBuildOptions tests that files excluded by build constraints for
+// the --goos and --tags build options contribute no defs or refs.
+func TestBuildOptions(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-buildopt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tmp, "gopath")
+	dir := filepath.Join(gopath, "src", "example.com", "plat")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"plat.go":         "package plat\n\nfunc A() {}\n",
+		"plat_linux.go":   "package plat\n\nvar Linux = A\n",
+		"plat_windows.go": "package plat\n\nvar Windows = A\n",
+		"tagged.go":       "//go:build integration\n// +build integration\n\npackage plat\n\nvar Tagged = A\n",
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	origBuildContext, origBuildDefault, origConfig := buildContext, build.Default, config
+	defer func() {
+		buildContext, build.Default, config = origBuildContext, origBuildDefault, origConfig
+		loaderConfig.Build = &buildContext
+	}()
+	defer setTestRepo(t, dir, gopath, nil)()
+
+	tests := []struct {
+		goos      string
+		tags      []string
+		wantFiles []string
+		wantDefs  []string
+	}{
+		{
+			goos:      "linux",
+			wantFiles: []string{"plat.go", "plat_linux.go"},
+			wantDefs:  []string{"A", "Linux"},
+		},
+		{
+			goos:      "windows",
+			tags:      []string{"integration"},
+			wantFiles: []string{"plat.go", "plat_windows.go", "tagged.go"},
+			wantDefs:  []string{"A", "Tagged", "Windows"},
+		},
+	}
+	for _, test := range tests {
+		config = &srcfileConfig{GOOS: test.goos, BuildTags: test.tags}
+		if err := config.apply(); err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := buildContext.Import("example.com/plat", "", 0)
+		if err != nil {
+			t.Errorf("%s %q: %s", test.goos, test.tags, err)
+			continue
+		}
+		if !reflect.DeepEqual(pkg.GoFiles, test.wantFiles) {
+			t.Errorf("%s %q: got files %q, want %q", test.goos, test.tags, pkg.GoFiles, test.wantFiles)
+		}
+
+		o, err := doGraph(context.Background(), pkg, false)
+		if err != nil {
+			t.Errorf("%s %q: %s", test.goos, test.tags, err)
+			continue
+		}
+		var defs []string
+		outputFiles := map[string]struct{}{}
+		for _, def := range o.Defs {
+			if def.Name != "plat" {
+				defs = append(defs, def.Name)
+			}
+			outputFiles[filepath.Base(def.File)] = struct{}{}
+		}
+		for _, ref := range o.Refs {
+			outputFiles[filepath.Base(ref.File)] = struct{}{}
+		}
+		sort.Strings(defs)
+		if !reflect.DeepEqual(defs, test.wantDefs) {
+			t.Errorf("%s %q: got defs %q, want %q", test.goos, test.tags, defs, test.wantDefs)
+		}
+		for _, want := range test.wantFiles {
+			delete(outputFiles, want)
+		}
+		for file := range outputFiles {
+			t.Errorf("%s %q: got defs or refs in excluded file %s", test.goos, test.tags, file)
+		}
+	}
+}
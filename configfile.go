@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// configFileName is the name of the file in the repository root (the
+// --root-dir, or the current directory) that sets the default values
+// of srclib-go's command-line options, so that everyone who analyzes
+// the repository uses the same settings.
+//
+// It is a YAML file (of which only a subset is supported: scalars,
+// lists, and one level of nesting). Its top-level keys are the long
+// names of the options that apply to all commands (such as goos, tags,
+// and timeout), and its scan, graph, and depresolve keys hold the
+// options of those commands:
+//
+//	tags: integration
+//	timeout: 10m
+//	graph:
+//	  concurrency: 4
+//	  callgraph: true
+//	  pkg: [example.com/foo, example.com/foo/bar]
+//
+// An option's value is taken from (in order of precedence) the command
+// line, the environment (for options that default to an environment
+// variable, see configFileEnv), the config file, and the option's
+// built-in default. (A bool option that the config file sets to true
+// can't be turned off on the command line.)
+const configFileName = ".srclib-go.yaml"
+
+// configFileEnv maps the long names of the options whose built-in
+// defaults come from environment variables to those variables. If the
+// variable is set, it takes precedence over the config file.
+var configFileEnv = map[string]string{
+	"goos":   "GOOS",
+	"goarch": "GOARCH",
+	"goroot": "GOROOT",
+}
+
+// configFileEntry is an option set in the config file.
+type configFileEntry struct {
+	command string // "" for the options that apply to all commands
+	name    string
+	values  []string
+	line    int
+}
+
+// configFileDir returns the dir that the config file is read from: the
+// --root-dir in args (the command-line arguments), if any, or else the
+// current directory. The command line is only parsed after the config
+// file is applied, so the --root-dir is looked up in args on its own.
+func configFileDir(args []string) (string, error) {
+	var opt struct {
+		RootDir string `long:"root-dir"`
+	}
+	if _, err := flags.NewParser(&opt, flags.IgnoreUnknown).ParseArgs(args); err != nil || opt.RootDir == "" {
+		// Parsing errors are reported when the command line is
+		// parsed.
+		return cwd, nil
+	}
+	return filepath.Abs(opt.RootDir)
+}
+
+// applyConfigFile reads the config file (if any) in dir and makes its
+// values the defaults of the options that it sets. It must be called
+// after all commands and option groups are added, and before the
+// command line is parsed.
+func applyConfigFile(dir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, configFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	entries, err := parseConfigFile(string(data))
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, e := range entries {
+		where := e.name
+		if e.command != "" {
+			where = e.command + "." + e.name
+		}
+		if seen[where] {
+			return fmt.Errorf("%s:%d: option %s is set more than once", configFileName, e.line, where)
+		}
+		seen[where] = true
+
+		if e.command == "" && e.name == "root-dir" {
+			return fmt.Errorf("%s:%d: option root-dir can't be set in %s (it is the dir that %s is read from)", configFileName, e.line, configFileName, configFileName)
+		}
+		opt, err := findConfigOption(e.command, e.name)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", configFileName, e.line, err)
+		}
+		if err := checkConfigValues(opt, e.values); err != nil {
+			return fmt.Errorf("%s:%d: invalid value for option %s: %s", configFileName, e.line, where, err)
+		}
+		if env := configFileEnv[e.name]; env != "" && os.Getenv(env) != "" {
+			continue
+		}
+		opt.Default = e.values
+	}
+	return nil
+}
+
+// findConfigOption returns the option named name of command (or of all
+// commands, if command is "").
+func findConfigOption(command, name string) (*flags.Option, error) {
+	groups := parser.Groups()
+	if command != "" {
+		cmd := parser.Find(command)
+		groups = append([]*flags.Group{cmd.Group}, cmd.Groups()...)
+	}
+	for _, g := range groups {
+		for _, opt := range g.Options() {
+			if opt.LongName == name {
+				return opt, nil
+			}
+		}
+	}
+	if command == "" {
+		var cmds []string
+		for _, cmd := range parser.Commands() {
+			if opt, _ := findConfigOption(cmd.Name, name); opt != nil {
+				cmds = append(cmds, cmd.Name+":")
+			}
+		}
+		if len(cmds) > 0 {
+			return nil, fmt.Errorf("option %s is a command option (set it under %s)", name, strings.Join(cmds, " or "))
+		}
+		return nil, fmt.Errorf("unknown option %s", name)
+	}
+	return nil, fmt.Errorf("unknown option %s for command %s", name, command)
+}
+
+// checkConfigValues returns an error if values can't be assigned to opt
+// (which the command-line parser would otherwise ignore when it applies
+// them as opt's default).
+func checkConfigValues(opt *flags.Option, values []string) error {
+	t := reflect.TypeOf(opt.Value())
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	} else if len(values) != 1 {
+		return fmt.Errorf("got a list, want a single value")
+	}
+	for _, v := range values {
+		var err error
+		switch {
+		case t == reflect.TypeOf(time.Duration(0)):
+			_, err = time.ParseDuration(v)
+		case t.Kind() == reflect.Bool:
+			_, err = strconv.ParseBool(v)
+		case t.Kind() == reflect.Int:
+			_, err = strconv.Atoi(v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseConfigFile parses the subset of YAML that the config file may
+// use (see configFileName).
+func parseConfigFile(data string) ([]*configFileEntry, error) {
+	var entries []*configFileEntry
+	var command string
+	var list *configFileEntry // the entry whose list items are expected next
+	for i, line := range strings.Split(data, "\n") {
+		lineNum := i + 1
+		line = strings.TrimRight(stripConfigComment(line), " \t\r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || lineNum == 1 && content == "---" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("%s:%d: tabs can't be used for indentation", configFileName, lineNum)
+		}
+		indented := len(content) < len(line)
+
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			if list == nil {
+				return nil, fmt.Errorf("%s:%d: unexpected list item", configFileName, lineNum)
+			}
+			v, err := unquoteConfigValue(strings.TrimSpace(content[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", configFileName, lineNum, err)
+			}
+			list.values = append(list.values, v)
+			continue
+		}
+		if list != nil && len(list.values) == 0 {
+			return nil, fmt.Errorf("%s:%d: option %s has no value", configFileName, list.line, list.name)
+		}
+		list = nil
+
+		colon := strings.Index(content, ":")
+		if colon <= 0 || colon+1 < len(content) && content[colon+1] != ' ' {
+			return nil, fmt.Errorf("%s:%d: expected \"name: value\"", configFileName, lineNum)
+		}
+		key, value := content[:colon], strings.TrimSpace(content[colon+1:])
+		if !indented {
+			command = ""
+			if value == "" && parser.Find(key) != nil {
+				command = key
+				continue
+			}
+		} else if command == "" {
+			return nil, fmt.Errorf("%s:%d: unexpected indentation", configFileName, lineNum)
+		}
+
+		e := &configFileEntry{command: command, name: key, line: lineNum}
+		entries = append(entries, e)
+		switch {
+		case value == "":
+			list = e
+		case strings.HasPrefix(value, "["):
+			if !strings.HasSuffix(value, "]") {
+				return nil, fmt.Errorf("%s:%d: unterminated list", configFileName, lineNum)
+			}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				v, err := unquoteConfigValue(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %s", configFileName, lineNum, err)
+				}
+				e.values = append(e.values, v)
+			}
+		default:
+			v, err := unquoteConfigValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", configFileName, lineNum, err)
+			}
+			e.values = []string{v}
+		}
+	}
+	if list != nil && len(list.values) == 0 {
+		return nil, fmt.Errorf("%s:%d: option %s has no value", configFileName, list.line, list.name)
+	}
+	return entries, nil
+}
+
+// stripConfigComment removes the comment (starting with a "#" at the
+// beginning of line or after a space, outside of quotes), if any, from
+// line.
+func stripConfigComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquoteConfigValue returns the value of the YAML scalar s, which may
+// be double-quoted, single-quoted, or plain.
+func unquoteConfigValue(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		return strconv.Unquote(s)
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'"):
+		return "", fmt.Errorf("unterminated quoted value %s", s)
+	}
+	return s, nil
+}
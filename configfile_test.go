@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		data string
+		want []*configFileEntry
+	}{
+		{data: "", want: nil},
+		{
+			data: "---\ntags: integration\ntimeout: 10m\n",
+			want: []*configFileEntry{
+				{name: "tags", values: []string{"integration"}, line: 2},
+				{name: "timeout", values: []string{"10m"}, line: 3},
+			},
+		},
+
+		// Comments
+		{
+			data: "# comment\n\ntags: a # comment\n  # indented comment\n",
+			want: []*configFileEntry{{name: "tags", values: []string{"a"}, line: 3}},
+		},
+		{
+			data: "tags: a#b\n",
+			want: []*configFileEntry{{name: "tags", values: []string{"a#b"}, line: 1}},
+		},
+		{
+			data: `tags: "a # b" # comment` + "\n",
+			want: []*configFileEntry{{name: "tags", values: []string{"a # b"}, line: 1}},
+		},
+
+		// Quoting
+		{
+			data: `tags: "a\tb \" c"` + "\n",
+			want: []*configFileEntry{{name: "tags", values: []string{"a\tb \" c"}, line: 1}},
+		},
+		{
+			data: `tags: 'it''s # not a comment'` + "\n",
+			want: []*configFileEntry{{name: "tags", values: []string{"it's # not a comment"}, line: 1}},
+		},
+
+		// Lists and commands
+		{
+			data: "tags: [a, 'b c', \"d\"]\n",
+			want: []*configFileEntry{{name: "tags", values: []string{"a", "b c", "d"}, line: 1}},
+		},
+		{
+			data: "tags: []\n",
+			want: []*configFileEntry{{name: "tags", line: 1}},
+		},
+		{
+			data: "graph:\n  concurrency: 4\n  pkg:\n    - example.com/a\n    - 'example.com/b'\ntags: x\n",
+			want: []*configFileEntry{
+				{command: "graph", name: "concurrency", values: []string{"4"}, line: 2},
+				{command: "graph", name: "pkg", values: []string{"example.com/a", "example.com/b"}, line: 3},
+				{name: "tags", values: []string{"x"}, line: 6},
+			},
+		},
+		{
+			// List items may be at the same indentation as their key.
+			data: "tags:\n- a\n",
+			want: []*configFileEntry{{name: "tags", values: []string{"a"}, line: 1}},
+		},
+	}
+	for _, test := range tests {
+		entries, err := parseConfigFile(test.data)
+		if err != nil {
+			t.Errorf("%q: %s", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(entries, test.want) {
+			t.Errorf("%q: got entries %s, want %s", test.data, configEntriesString(entries), configEntriesString(test.want))
+		}
+	}
+}
+
+func TestParseConfigFile_errors(t *testing.T) {
+	tests := map[string]string{
+		"tags: a\n\ttimeout: 1m\n":       ".srclib-go.yaml:2: tabs can't be used for indentation",
+		"- a\n":                          ".srclib-go.yaml:1: unexpected list item",
+		"tags:\ntimeout: 1m\n":           ".srclib-go.yaml:1: option tags has no value",
+		"tags:\n":                        ".srclib-go.yaml:1: option tags has no value",
+		"tags\n":                         `.srclib-go.yaml:1: expected "name: value"`,
+		"tags:a\n":                       `.srclib-go.yaml:1: expected "name: value"`,
+		"tags: a\n  timeout: 1m\n":       ".srclib-go.yaml:2: unexpected indentation",
+		"tags: [a, b\n":                  ".srclib-go.yaml:1: unterminated list",
+		"tags: \"a\n":                    `.srclib-go.yaml:1: unterminated quoted value "a`,
+		"graph:\n  pkg: ['a, b]\n":       `.srclib-go.yaml:2: unterminated quoted value 'a`,
+		"tags:\n  - \"a\\q\"\n":          ".srclib-go.yaml:2: invalid syntax",
+		"# ok\n\ngraph:\n  pkg: [a\n":    ".srclib-go.yaml:4: unterminated list",
+		"tags: [a]\nscan:\n  - a\n":      ".srclib-go.yaml:3: unexpected list item",
+		"graph:\n  callgraph:\n  x: 1\n": ".srclib-go.yaml:2: option callgraph has no value",
+	}
+	for data, want := range tests {
+		_, err := parseConfigFile(data)
+		if err == nil {
+			t.Errorf("%q: got no error, want %q", data, want)
+		} else if err.Error() != want {
+			t.Errorf("%q: got error %q, want %q", data, err, want)
+		}
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-go-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// No config file.
+	if err := applyConfigFile(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"nosuchoption: 1\n":                     ".srclib-go.yaml:1: unknown option nosuchoption",
+		"graph:\n  nosuchoption: 1\n":           ".srclib-go.yaml:2: unknown option nosuchoption for command graph",
+		"callgraph: true\n":                     ".srclib-go.yaml:1: option callgraph is a command option (set it under graph:)",
+		"concurrency: 2\n":                      ".srclib-go.yaml:1: option concurrency is a command option (set it under depresolve: or graph:)",
+		"root-dir: ..\n":                        ".srclib-go.yaml:1: option root-dir can't be set in .srclib-go.yaml (it is the dir that .srclib-go.yaml is read from)",
+		"tags: a\ntags: b\n":                    ".srclib-go.yaml:2: option tags is set more than once",
+		"timeout: soon\n":                       `.srclib-go.yaml:1: invalid value for option timeout: time: invalid duration "soon"`,
+		"graph:\n  concurrency: [1, 2]\n":       ".srclib-go.yaml:2: invalid value for option graph.concurrency: got a list, want a single value",
+		"graph:\n  callgraph: maybe\n":          `.srclib-go.yaml:2: invalid value for option graph.callgraph: strconv.ParseBool: parsing "maybe": invalid syntax`,
+		"depresolve:\n  concurrency: many\n":    `.srclib-go.yaml:2: invalid value for option depresolve.concurrency: strconv.Atoi: parsing "many": invalid syntax`,
+		"tags: a\ngraph:\n  tags: b\n":          ".srclib-go.yaml:3: unknown option tags for command graph",
+		"depresolve:\n  concurrency: 1\n  x:\n": ".srclib-go.yaml:3: option x has no value",
+	}
+	for data, want := range tests {
+		if err := ioutil.WriteFile(filepath.Join(dir, configFileName), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		err := applyConfigFile(dir)
+		if err == nil {
+			t.Errorf("%q: got no error, want %q", data, want)
+		} else if err.Error() != want {
+			t.Errorf("%q: got error %q, want %q", data, err, want)
+		}
+	}
+
+	// Setting an option makes its value the option's default.
+	opt, err := findConfigOption("graph", "concurrency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origDefault := opt.Default
+	defer func() { opt.Default = origDefault }()
+	if err := ioutil.WriteFile(filepath.Join(dir, configFileName), []byte("graph:\n  concurrency: 3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"3"}; !reflect.DeepEqual(opt.Default, want) {
+		t.Errorf("got graph --concurrency default %q, want %q", opt.Default, want)
+	}
+}
+
+func TestConfigFileDir(t *testing.T) {
+	abs := func(dir string) string {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: cwd},
+		{args: []string{"graph", "--concurrency", "2"}, want: cwd},
+		{args: []string{"--root-dir", "/tmp/repo", "scan"}, want: "/tmp/repo"},
+		{args: []string{"--root-dir=repo", "graph"}, want: abs("repo")},
+		{args: []string{"-v", "--goos", "linux", "graph", "--root-dir", "../repo", "--callgraph"}, want: abs("../repo")},
+	}
+	for _, test := range tests {
+		dir, err := configFileDir(test.args)
+		if err != nil {
+			t.Errorf("%q: %s", test.args, err)
+			continue
+		}
+		if dir != test.want {
+			t.Errorf("%q: got dir %q, want %q", test.args, dir, test.want)
+		}
+	}
+}
+
+func configEntriesString(entries []*configFileEntry) string {
+	var s []string
+	for _, e := range entries {
+		s = append(s, fmt.Sprintf("%+v", *e))
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
@@ -4,23 +4,30 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"runtime"
 
 	"go/build"
 
+	"code.google.com/p/rog-go/parallel"
 	"github.com/golang/gddo/gosrc"
 
 	"strings"
 	"sync"
+	"time"
 
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
 	"sourcegraph.com/sourcegraph/srclib/dep"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
 func init() {
-	_, err := parser.AddCommand("depresolve",
+	_, err := parser.AddGroup("Dependency resolution options", "", &resolveOpt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = parser.AddCommand("depresolve",
 		"resolve a Go package's imports",
 		"Resolve a Go package's imports to their repository clone URL.",
 		&depResolveCmd,
@@ -30,13 +37,40 @@ func init() {
 	}
 }
 
+// ResolveOpt contains the command-line options that determine how
+// imports are resolved to repositories (by the depresolve and graph
+// commands).
+type ResolveOpt struct {
+	NoNetwork bool `long:"no-network" description:"don't access the network to resolve imports (for hermetic builds); guess repositories from import paths instead"`
+
+	// GoVersion and StdlibRepo determine where standard library
+	// imports are resolved to. GoVersion also overrides the Go language
+	// version that is recorded in scan and graph output (see
+	// goLanguageVersion).
+	GoVersion  string `long:"go-version" description:"version of Go to resolve standard library imports to and to record as the language version, such as go1.4.2 (default: the version of the Go toolchain, and the go.mod go directive's language version)" value-name:"VERSION"`
+	StdlibRepo string `long:"stdlib-repo" description:"clone URL of the repository to resolve standard library imports to" default:"https://github.com/golang/go" value-name:"URL"`
+
+	// RepoMap is a JSON file mapping import path prefixes to the clone
+	// URLs of the repositories that host them (see repoMap).
+	RepoMap string `long:"repo-map" description:"JSON file mapping import path prefixes to repository clone URLs, overriding the repositories that imports (such as of forks) resolve to" value-name:"FILE"`
+}
+
+var resolveOpt ResolveOpt
+
 type DepResolveCmd struct {
 	Config []string `long:"config" description:"config property from Srcfile" value-name:"KEY=VALUE"`
+	Strict bool     `long:"strict" description:"fail (instead of warning) if any import can't be resolved"`
+
+	Concurrency int `short:"j" long:"concurrency" description:"max number of imports to resolve concurrently (default: number of CPUs)" value-name:"N"`
 }
 
 var depResolveCmd DepResolveCmd
 
 func (c *DepResolveCmd) Execute(args []string) error {
+	if err := startCommand("depresolve"); err != nil {
+		return err
+	}
+
 	var unit *unit.SourceUnit
 	if err := json.NewDecoder(os.Stdin).Decode(&unit); err != nil {
 		return err
@@ -44,6 +78,7 @@ func (c *DepResolveCmd) Execute(args []string) error {
 	if err := os.Stdin.Close(); err != nil {
 		return err
 	}
+	overrideRepo(unit)
 
 	if err := unmarshalTypedConfig(unit.Config); err != nil {
 		return err
@@ -53,20 +88,79 @@ func (c *DepResolveCmd) Execute(args []string) error {
 	}
 
 	res := make([]*dep.Resolution, len(unit.Dependencies))
+	importPaths := make([]string, len(unit.Dependencies))
 	for i, rawDep := range unit.Dependencies {
 		importPath, ok := rawDep.(string)
 		if !ok {
 			return fmt.Errorf("Go raw dep is not a string import path: %v (%T)", rawDep, rawDep)
 		}
-
 		res[i] = &dep.Resolution{Raw: rawDep}
+		importPaths[i] = importPath
+	}
 
-		rt, err := ResolveDep(importPath, string(unit.Repo))
-		if err != nil {
-			res[i].Error = err.Error()
-			continue
+	// Resolve the imports concurrently. failures[i] is set if the i'th
+	// import can't be resolved.
+	ctx, cancel := timeoutContext("resolving the dependencies of source unit " + unit.Name)
+	defer cancel()
+	failures := make([]*unresolvedImport, len(importPaths))
+	run := parallel.NewRun(c.concurrency())
+	for i, importPath := range importPaths {
+		i, importPath := i, importPath
+		run.Do(func() error {
+			fail := func(reason, msg string) {
+				res[i].Error = msg
+				failures[i] = &unresolvedImport{ImportPath: importPath, Reason: reason, Error: msg}
+			}
+
+			// Scanning converts local imports to import paths, so this
+			// is one that couldn't be converted.
+			if isLocalImport(importPath) {
+				if _, err := absImportPath(importPath, unit.Dir); err != nil {
+					fail(unresolvedLocal, err.Error())
+					return nil
+				}
+			}
+
+			if !definfo.InternalImportAllowed(unit.Name, importPath) {
+				fail(unresolvedInternal, fmt.Sprintf("use of internal package %s not allowed in %s", importPath, unit.Name))
+				return nil
+			}
+
+			// On timeout, emit the resolutions computed so far, and
+			// timeout errors for the rest. (Resolutions that already
+			// started finish, which their network requests' timeouts
+			// bound.)
+			if isTimedOut(ctx) {
+				fail(unresolvedTimedOut, errTimedOut.Error())
+				return nil
+			}
+			rt, err := ResolveDep(importPath, string(unit.Repo))
+			if err != nil {
+				fail(unresolvedNotFound, err.Error())
+				return nil
+			}
+			if rt == nil && importPath != "C" {
+				fail(unresolvedNotFound, "no repository found")
+				return nil
+			}
+			res[i].Target = rt
+			return nil
+		})
+	}
+	run.Wait()
+
+	var unresolved []*unresolvedImport
+	for _, f := range failures {
+		if f != nil {
+			unresolved = append(unresolved, f)
 		}
-		res[i].Target = rt
+	}
+
+	for _, u := range unresolved {
+		warnf("Unresolved import %q (%s): %s.", u.ImportPath, u.Reason, u.Error)
+	}
+	if c.Strict && len(unresolved) > 0 {
+		return fmt.Errorf("%d of %d imports of %s could not be resolved (and --strict is set)", len(unresolved), len(res), unit.Name)
 	}
 
 	b, err := json.MarshalIndent(res, "", "  ")
@@ -80,25 +174,97 @@ func (c *DepResolveCmd) Execute(args []string) error {
 	return nil
 }
 
+func (c *DepResolveCmd) concurrency() int {
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	return runtime.NumCPU()
+}
+
+// unresolvedImport is an import that depresolve couldn't resolve. Its
+// Resolution has no Target, so refs to it are not linked to another
+// repository.
+type unresolvedImport struct {
+	ImportPath string
+	Reason     string // one of the unresolved* constants
+	Error      string
+}
+
+// Reasons why an import couldn't be resolved.
+const (
+	unresolvedLocal    = "invalid local import"
+	unresolvedInternal = "internal package not allowed"
+	unresolvedTimedOut = "timed out"
+	unresolvedNotFound = "not found"
+)
+
+// resolveCacheEntry is the result of resolving an import path, which is
+// set before done is closed.
+type resolveCacheEntry struct {
+	done chan struct{}
+	rt   *dep.ResolvedTarget
+	err  error
+}
+
 var (
-	resolveCache   map[string]*dep.ResolvedTarget
+	resolveCache   map[string]*resolveCacheEntry
 	resolveCacheMu sync.Mutex
 )
 
+// ResolveDep resolves importPath (imported by a package in the
+// repository whose import path is repoImportPath) to the source unit
+// that provides it. Results, including failures (such as network
+// errors), are cached for the rest of the run, and concurrent calls to
+// resolve the same import path wait for a single resolution.
 func ResolveDep(importPath string, repoImportPath string) (*dep.ResolvedTarget, error) {
-	// Look up in cache.
-	resolvedTarget := func() *dep.ResolvedTarget {
-		resolveCacheMu.Lock()
-		defer resolveCacheMu.Unlock()
-		return resolveCache[importPath]
-	}()
-	if resolvedTarget != nil {
-		return resolvedTarget, nil
+	// Vendored packages are not emitted as source units (unless
+	// IncludeVendor is set), so resolve them as if they were imported
+	// from their original location.
+	if (config == nil || !config.IncludeVendor) && isVendored(importPath) {
+		importPath = unvendoredImportPath(importPath)
+	}
+
+	// Packages imported through symlinks are emitted as source units
+	// in their real dirs (or in the dirs of other symlinks to them).
+	if unitName, present := config.symlinkAlias(importPath); present {
+		importPath = unitName
+	}
+
+	key := importPath + " " + repoImportPath
+	resolveCacheMu.Lock()
+	e, present := resolveCache[key]
+	if !present {
+		if resolveCache == nil {
+			resolveCache = make(map[string]*resolveCacheEntry)
+		}
+		e = &resolveCacheEntry{done: make(chan struct{})}
+		resolveCache[key] = e
 	}
+	resolveCacheMu.Unlock()
+	if present {
+		<-e.done
+		return e.rt, e.err
+	}
+	defer close(e.done)
+	e.rt, e.err = resolveDep(importPath, repoImportPath)
+	return e.rt, e.err
+}
+
+// resolveDep resolves importPath (see ResolveDep) without caching.
+func resolveDep(importPath string, repoImportPath string) (*dep.ResolvedTarget, error) {
 	if strings.HasSuffix(importPath, "_test") {
-		// TODO(sqs): handle xtest packages - these should not be appearing here
-		// as import paths, but they are, so suppress errors
-		return nil, fmt.Errorf("xtest package (%s) is not yet supported", importPath)
+		if _, err := buildContext.Import(importPath, "", build.FindOnly); err != nil {
+			// An external test package (see splitXTestUnits) is in
+			// the same repository and source unit namespace as the
+			// package that it tests.
+			rt, err := resolveDep(strings.TrimSuffix(importPath, "_test"), repoImportPath)
+			if err != nil || rt == nil {
+				return rt, err
+			}
+			xtestRT := *rt
+			xtestRT.ToUnit += "_test"
+			return &xtestRT, nil
+		}
 	}
 
 	// Check if this import path is in this tree.
@@ -113,7 +279,7 @@ func ResolveDep(importPath string, repoImportPath string) (*dep.ResolvedTarget,
 	}
 
 	// Check if this import path is in this repository.
-	if strings.HasPrefix(importPath, repoImportPath) {
+	if repoImportPath != "" && strings.HasPrefix(importPath, repoImportPath) {
 		return &dep.ResolvedTarget{
 			// empty ToRepoCloneURL to indicate it's from this repository
 			ToRepoCloneURL: "",
@@ -127,16 +293,65 @@ func ResolveDep(importPath string, repoImportPath string) (*dep.ResolvedTarget,
 		return nil, nil
 	}
 
-	if gosrc.IsGoRepoPath(importPath) || strings.HasPrefix(importPath, "debug/") || strings.HasPrefix(importPath, "cmd/") {
+	if gosrc.IsGoRepoPath(importPath) || strings.HasPrefix(importPath, "debug/") || strings.HasPrefix(importPath, "cmd/") || isGorootPackage(importPath) {
 		return &dep.ResolvedTarget{
-			ToRepoCloneURL:  "https://github.com/golang/go",
-			ToVersionString: runtime.Version(),
-			ToRevSpec:       "", // TODO(sqs): fill in when graphing stdlib repo
+			ToRepoCloneURL:  stdlibRepo(),
+			ToVersionString: goVersion(),
+			ToRevSpec:       goVersionRevSpec(goVersion()),
 			ToUnit:          importPath,
 			ToUnitType:      "GoPackage",
 		}, nil
 	}
 
+	repos, err := readRepoMap()
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve using the repository's go.mod file, if any.
+	if mod, err := goModule(); err != nil {
+		return nil, err
+	} else if mod != nil {
+		rt, err := mod.resolve(importPath)
+		if err != nil {
+			return nil, err
+		}
+		if rt != nil {
+			// The --repo-map overrides the repository of the package
+			// (as imported, or as provided by a replacement module).
+			if rt.ToRepoCloneURL != "" {
+				if cloneURL := repos.lookup(importPath); cloneURL != "" {
+					rt.ToRepoCloneURL = cloneURL
+				} else if cloneURL := repos.lookup(rt.ToUnit); cloneURL != "" {
+					rt.ToRepoCloneURL = cloneURL
+				}
+			}
+			return rt, nil
+		}
+	}
+
+	if cloneURL := repos.lookup(importPath); cloneURL != "" {
+		return &dep.ResolvedTarget{
+			ToRepoCloneURL: cloneURL,
+			ToUnit:         importPath,
+			ToUnitType:     "GoPackage",
+		}, nil
+	}
+	return resolveRemoteDep(importPath)
+}
+
+// isGorootPackage reports whether importPath is a standard library
+// package in the GOROOT (which may be a custom GOROOT with packages
+// that gosrc doesn't know about).
+func isGorootPackage(importPath string) bool {
+	pkg, err := buildContext.Import(importPath, "", build.FindOnly)
+	return err == nil && pkg.Goroot
+}
+
+// resolveRemoteDep resolves importPath (which must not be in this
+// repository) to the repository that contains it, based on the
+// import path alone.
+func resolveRemoteDep(importPath string) (*dep.ResolvedTarget, error) {
 	// Special-case github.com/... import paths for performance.
 	if strings.HasPrefix(importPath, "github.com/") || strings.HasPrefix(importPath, "sourcegraph.com/") {
 		parts := strings.SplitN(importPath, "/", 4)
@@ -186,9 +401,38 @@ func ResolveDep(importPath string, repoImportPath string) (*dep.ResolvedTarget,
 		}, nil
 	}
 
-	log.Printf("Resolving Go dep: %s", importPath)
+	if resolveOpt.NoNetwork {
+		return &dep.ResolvedTarget{
+			ToRepoCloneURL: guessRepoCloneURL(importPath),
+			ToUnit:         importPath,
+			ToUnitType:     "GoPackage",
+		}, nil
+	}
+
+	// Resolve vanity import paths (such as gopkg.in/yaml.v2) using
+	// go-get meta discovery.
+	mi, err := discoverMetaImport(importPath)
+	if err == nil {
+		return &dep.ResolvedTarget{
+			ToRepoCloneURL: strings.TrimSuffix(mi.RepoRoot, "/"),
+			ToUnit:         importPath,
+			ToUnitType:     "GoPackage",
+		}, nil
+	}
+	warnf("%s.", err)
+
+	if isPrivateImportPath(importPath) {
+		// Don't leak private import paths to public services.
+		return &dep.ResolvedTarget{
+			ToRepoCloneURL: guessRepoCloneURL(importPath),
+			ToUnit:         importPath,
+			ToUnitType:     "GoPackage",
+		}, nil
+	}
 
-	dir, err := gosrc.Get(http.DefaultClient, string(importPath), "")
+	start := time.Now()
+	dir, err := gosrc.Get(goGetClient, string(importPath), "")
+	std.withDuration(start).debugf("Resolved Go dep: %s", importPath)
 	if err != nil {
 		if strings.Contains(err.Error(), "Git Repository is empty.") {
 			// Not fatal, just weird.
@@ -200,19 +444,9 @@ func ResolveDep(importPath string, repoImportPath string) (*dep.ResolvedTarget,
 	// gosrc returns code.google.com URLs ending in a slash. Remove it.
 	dir.ProjectURL = strings.TrimSuffix(dir.ProjectURL, "/")
 
-	resolvedTarget = &dep.ResolvedTarget{
+	return &dep.ResolvedTarget{
 		ToRepoCloneURL: dir.ProjectURL,
 		ToUnit:         importPath,
 		ToUnitType:     "GoPackage",
-	}
-
-	// Save in cache.
-	resolveCacheMu.Lock()
-	defer resolveCacheMu.Unlock()
-	if resolveCache == nil {
-		resolveCache = make(map[string]*dep.ResolvedTarget)
-	}
-	resolveCache[importPath] = resolvedTarget
-
-	return resolvedTarget, nil
+	}, nil
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"go/build"
+	goparser "go/parser"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// scanDiagnostic is a syntax error in one of a package's files. The
+// scanner records it and continues, so that one broken file doesn't
+// hide the rest of the package (or the repository).
+type scanDiagnostic struct {
+	File string // relative to the repository root
+
+	// Line and Column are the 1-based position of the error, or 0 if
+	// the file couldn't be read.
+	Line   int `json:",omitempty"`
+	Column int `json:",omitempty"`
+
+	Message string
+}
+
+// parseDiagnostics parses each of the Go files of the source unit u
+// (whose Data is pkg and whose files must already be relative to the
+// repository root) and returns their syntax errors.
+func parseDiagnostics(u *unit.SourceUnit, pkg *build.Package, subdir string) []*scanDiagnostic {
+	included := make(map[string]bool, len(u.Files))
+	for _, f := range u.Files {
+		included[f] = true
+	}
+
+	var diags []*scanDiagnostic
+	fset := token.NewFileSet()
+	for _, list := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles, pkg.XTestGoFiles} {
+		for _, name := range list {
+			file := filepath.Join(subdir, pkg.Dir, name)
+			if !included[file] {
+				continue
+			}
+			_, err := goparser.ParseFile(fset, filepath.Join(cwd, pkg.Dir, name), nil, 0)
+			if err == nil {
+				continue
+			}
+			errs, ok := err.(scanner.ErrorList)
+			if !ok {
+				diags = append(diags, &scanDiagnostic{File: file, Message: err.Error()})
+				continue
+			}
+			for _, e := range errs {
+				diags = append(diags, &scanDiagnostic{File: file, Line: e.Pos.Line, Column: e.Pos.Column, Message: e.Msg})
+			}
+		}
+	}
+	return diags
+}
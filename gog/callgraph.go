@@ -0,0 +1,181 @@
+package gog
+
+import (
+	"go/ast"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+// Call records that the function or method Caller calls Callee.
+type Call struct {
+	// Caller is the def of the function or method whose body (or a
+	// function literal in whose body) contains the call.
+	Caller *DefKey
+
+	// Callee is the def of the called function or method.
+	Callee *DefKey
+
+	File string
+	Span [2]int // of the call expression
+
+	// Possible is true if the call is of an interface method and Callee
+	// is the method of a type that implements the interface, so the
+	// call may dispatch to Callee at run time. (Such a call also has a
+	// Call, with Possible false, whose Callee is the interface method.)
+	Possible bool `json:",omitempty"`
+}
+
+// emitCalls emits a Call for each call in pkgInfo's functions and
+// methods of a function or method that can be determined statically,
+// including the calls of defer and go statements and calls in function
+// literals. Calls in function literals in the initializers of
+// package-level vars (var f = func() { ... }) have the var as their
+// Caller. Calls of function values (such as funcs stored in variables
+// or fields) are omitted. Calls of interface methods also have possible
+// Calls to the implementing types' methods, unless SkipImplementations
+// is set.
+func (g *Grapher) emitCalls(pkgInfo *loader.PackageInfo) error {
+	for _, f := range pkgInfo.Files {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Body == nil {
+					continue
+				}
+				if err := g.emitCallsIn(pkgInfo, pkgInfo.Defs[decl.Name], decl.Body); err != nil {
+					return err
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					vs, ok := spec.(*ast.ValueSpec)
+					if !ok || len(vs.Names) != len(vs.Values) {
+						continue
+					}
+					for i, v := range vs.Values {
+						var err error
+						ast.Inspect(v, func(node ast.Node) bool {
+							if err != nil {
+								return false
+							}
+							if lit, ok := node.(*ast.FuncLit); ok {
+								err = g.emitCallsIn(pkgInfo, pkgInfo.Defs[vs.Names[i]], lit.Body)
+								return false
+							}
+							return true
+						})
+						if err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if g.canceled() {
+				return ErrCanceled
+			}
+		}
+	}
+	return nil
+}
+
+// emitCallsIn emits a Call from caller for each call in body.
+func (g *Grapher) emitCallsIn(pkgInfo *loader.PackageInfo, caller types.Object, body *ast.BlockStmt) error {
+	if caller == nil {
+		return nil
+	}
+	callerKey, err := g.defKey(caller)
+	if err != nil {
+		return err
+	}
+	ast.Inspect(body, func(node ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		if call, ok := node.(*ast.CallExpr); ok {
+			err = g.emitCall(pkgInfo, callerKey, call)
+		}
+		return true
+	})
+	return err
+}
+
+func (g *Grapher) emitCall(pkgInfo *loader.PackageInfo, callerKey *DefKey, call *ast.CallExpr) error {
+	var callee *types.Func
+	var iface *types.Interface // if an interface method is called
+	switch fun := unparen(call.Fun).(type) {
+	case *ast.Ident:
+		callee, _ = pkgInfo.Uses[fun].(*types.Func)
+	case *ast.SelectorExpr:
+		if sel, ok := pkgInfo.Selections[fun]; ok {
+			callee, _ = sel.Obj().(*types.Func)
+			if callee != nil && sel.Kind() != types.MethodExpr {
+				iface, _ = sel.Recv().Underlying().(*types.Interface)
+			}
+		} else {
+			// qualified identifier (pkg.F)
+			callee, _ = pkgInfo.Uses[fun.Sel].(*types.Func)
+		}
+	}
+	if callee == nil {
+		return nil
+	}
+
+	pos := g.program.Fset.Position(call.Pos())
+	span := makeSpan(g.program.Fset, call)
+	calleeKey, err := g.defKey(callee)
+	if err != nil {
+		return err
+	}
+	g.Calls = append(g.Calls, &Call{Caller: callerKey, Callee: calleeKey, File: pos.Filename, Span: span})
+
+	if iface == nil || g.SkipImplementations {
+		return nil
+	}
+	for _, tn := range g.concreteTypes() {
+		for _, typ := range []types.Type{tn.Type(), types.NewPointer(tn.Type())} {
+			if !types.Implements(typ, iface) {
+				continue
+			}
+			sel := types.NewMethodSet(typ).Lookup(callee.Pkg(), callee.Name())
+			if sel == nil {
+				break
+			}
+			m, ok := sel.Obj().(*types.Func)
+			if !ok {
+				break
+			}
+			implKey, err := g.defKey(m)
+			if err != nil {
+				return err
+			}
+			g.Calls = append(g.Calls, &Call{Caller: callerKey, Callee: implKey, File: pos.Filename, Span: span, Possible: true})
+			break
+		}
+	}
+	return nil
+}
+
+// concreteTypes returns the named non-interface types at package scope
+// in the program.
+func (g *Grapher) concreteTypes() []*types.TypeName {
+	if g.concreteTypeNames != nil {
+		return g.concreteTypeNames
+	}
+	g.concreteTypeNames = []*types.TypeName{}
+	for _, pi := range sortedPkgs(g.program.AllPackages) {
+		scope := pi.Pkg.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			if _, isNamed := tn.Type().(*types.Named); !isNamed {
+				continue
+			}
+			if _, isIface := tn.Type().Underlying().(*types.Interface); !isIface {
+				g.concreteTypeNames = append(g.concreteTypeNames, tn)
+			}
+		}
+	}
+	return g.concreteTypeNames
+}
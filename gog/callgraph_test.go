@@ -0,0 +1,98 @@
+package gog
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCalls(t *testing.T) {
+	src := `package foo
+
+type I interface{ M() }
+type T struct{}
+type P struct{}
+type E struct{ T }
+
+func (T) M()  {}
+func (*P) M() {}
+
+func F() {}
+
+func G(i I, t T) {
+	F()
+	(F)()
+	t.M()
+	T.M(t)
+	i.M()
+	func() { F() }()
+	f := F
+	f()
+	_ = int(1)
+	_ = len("")
+}
+
+func H(func()) func() { return F }
+
+func D(t T, i I) {
+	defer F()
+	defer t.M()
+	go i.M()
+	go func() { F() }()
+	defer H(F)()
+	go H(func() { t.M() })()
+}
+
+var V = func() {
+	defer F()
+	go func() { F() }()
+}
+
+var W = func() int { F(); return 0 }()
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	g.Callgraph = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range g.Calls {
+		s := c.Caller.String() + " -> " + c.Callee.String()
+		if c.Possible {
+			s += " (possible)"
+		}
+		got = append(got, s)
+	}
+	sort.Strings(got)
+	want := []string{
+		"foo#D -> foo#F",
+		"foo#D -> foo#F", // in the goroutine closure
+		"foo#D -> foo#H",
+		"foo#D -> foo#H",
+		"foo#D -> foo#I.M",
+		"foo#D -> foo#P.M (possible)",
+		"foo#D -> foo#T.M",
+		"foo#D -> foo#T.M", // in the closure passed to H
+		"foo#D -> foo#T.M (possible)",
+		"foo#D -> foo#T.M (possible)", // promoted to E
+		"foo#G -> foo#F",
+		"foo#G -> foo#F",
+		"foo#G -> foo#F", // in the func literal
+		"foo#G -> foo#I.M",
+		"foo#G -> foo#P.M (possible)",
+		"foo#G -> foo#T.M",
+		"foo#G -> foo#T.M",
+		"foo#G -> foo#T.M (possible)",
+		"foo#G -> foo#T.M (possible)", // promoted to E
+		"foo#V -> foo#F",
+		"foo#V -> foo#F", // in the goroutine closure
+		"foo#W -> foo#F",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got calls\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package gog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCgo(t *testing.T) {
+	src := `package foo
+
+// int add(int a, int b) { return a + b; }
+import "C"
+
+type T struct{ n C.int }
+
+var v C.long
+
+func Add(a, b int) int { return int(C.add(C.int(a), C.int(b))) }
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	wantTypes := map[string]string{"T/n": "C.int", "v": "C.long", "Add": "func(a int, b int) int"}
+	for _, d := range g.Defs {
+		path := strings.Join(d.Path, "/")
+		if want, ok := wantTypes[path]; ok {
+			if d.TypeString != want {
+				t.Errorf("%s: got TypeString %q, want %q", path, d.TypeString, want)
+			}
+			delete(wantTypes, path)
+		}
+	}
+	for path := range wantTypes {
+		t.Errorf("def %s not found", path)
+	}
+
+	for _, r := range g.Refs {
+		if r.Def.PackageImportPath == "C" {
+			t.Errorf("got ref to cgo pseudo-package at %d-%d: %q", r.Span[0], r.Span[1], src[r.Span[0]:r.Span[1]])
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package gog
+
+import (
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+// ControlFlow is a control-flow relationship within the body of a
+// function that refs don't express: a fallthrough to the next case of a
+// switch, or a send or receive in a case of a select.
+type ControlFlow struct {
+	// Kind is FallthroughFlow, SendFlow, or RecvFlow.
+	Kind string
+
+	// Func is the def of the function or method whose body (or a
+	// function literal in whose body) contains the control flow (or the
+	// package-level var whose initializer contains the function
+	// literal).
+	Func *DefKey
+
+	File string
+	Span [2]int // of the fallthrough statement or the select case's communication
+
+	// TargetSpan is the span of the header ("case x:" or "default:") of
+	// the case clause that a fallthrough transfers control to.
+	TargetSpan *[2]int `json:",omitempty"`
+
+	// Channel is the def of the channel that a select case sends on or
+	// receives from, if the channel is a var or field (such as ch or
+	// s.ch, but not chans[i] or time.After(d)).
+	Channel *DefKey `json:",omitempty"`
+}
+
+// Kinds of control flow (see ControlFlow.Kind).
+const (
+	// FallthroughFlow is a fallthrough statement in a switch case.
+	FallthroughFlow = "fallthrough"
+
+	// SendFlow is a send (case ch <- v:) in a select case.
+	SendFlow = "send"
+
+	// RecvFlow is a receive (case v := <-ch:) in a select case.
+	RecvFlow = "recv"
+)
+
+// emitControlFlows emits a ControlFlow for each fallthrough statement
+// and for the communication of each select case in the bodies of
+// pkgInfo's functions and methods, and of function literals in the
+// initializers of package-level vars.
+func (g *Grapher) emitControlFlows(pkgInfo *loader.PackageInfo) error {
+	type body struct {
+		fn   types.Object
+		node ast.Node
+	}
+	var bodies []body
+	for _, f := range pkgInfo.Files {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Body != nil {
+					bodies = append(bodies, body{pkgInfo.Defs[decl.Name], decl.Body})
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if vs, ok := spec.(*ast.ValueSpec); ok && len(vs.Names) == len(vs.Values) {
+						for i, v := range vs.Values {
+							bodies = append(bodies, body{pkgInfo.Defs[vs.Names[i]], v})
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for _, b := range bodies {
+		if b.fn == nil {
+			continue
+		}
+		fnKey, err := g.defKey(b.fn)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(b.node, func(node ast.Node) bool {
+			if err != nil {
+				return false
+			}
+			switch node := node.(type) {
+			case *ast.SwitchStmt:
+				g.emitFallthroughs(fnKey, node.Body)
+			case *ast.SelectStmt:
+				err = g.emitSelectComms(pkgInfo, fnKey, node.Body)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if g.canceled() {
+			return ErrCanceled
+		}
+	}
+	return nil
+}
+
+// emitFallthroughs emits a ControlFlow for the fallthrough statement
+// that ends each case clause of a switch statement's body (except the
+// last, which can't fall through).
+func (g *Grapher) emitFallthroughs(fnKey *DefKey, body *ast.BlockStmt) {
+	for i, stmt := range body.List {
+		clause := stmt.(*ast.CaseClause)
+		if len(clause.Body) == 0 || i+1 == len(body.List) {
+			continue
+		}
+		branch, ok := clause.Body[len(clause.Body)-1].(*ast.BranchStmt)
+		if !ok || branch.Tok != token.FALLTHROUGH {
+			continue
+		}
+		next := body.List[i+1].(*ast.CaseClause)
+		target := [2]int{
+			g.program.Fset.Position(next.Pos()).Offset,
+			g.program.Fset.Position(next.Colon).Offset + 1,
+		}
+		pos := g.program.Fset.Position(branch.Pos())
+		g.ControlFlows = append(g.ControlFlows, &ControlFlow{
+			Kind:       FallthroughFlow,
+			Func:       fnKey,
+			File:       pos.Filename,
+			Span:       makeSpan(g.program.Fset, branch),
+			TargetSpan: &target,
+		})
+	}
+}
+
+// emitSelectComms emits a ControlFlow for the send or receive of each
+// case clause of a select statement's body (except the default case).
+func (g *Grapher) emitSelectComms(pkgInfo *loader.PackageInfo, fnKey *DefKey, body *ast.BlockStmt) error {
+	for _, stmt := range body.List {
+		clause := stmt.(*ast.CommClause)
+		var kind string
+		var ch ast.Expr
+		switch comm := clause.Comm.(type) {
+		case nil:
+			continue // default case
+		case *ast.SendStmt:
+			kind, ch = SendFlow, comm.Chan
+		case *ast.ExprStmt:
+			kind, ch = RecvFlow, recvChan(comm.X)
+		case *ast.AssignStmt:
+			if len(comm.Rhs) == 1 {
+				kind, ch = RecvFlow, recvChan(comm.Rhs[0])
+			}
+		}
+		if kind == "" || ch == nil {
+			continue
+		}
+
+		pos := g.program.Fset.Position(clause.Comm.Pos())
+		flow := &ControlFlow{
+			Kind: kind,
+			Func: fnKey,
+			File: pos.Filename,
+			Span: makeSpan(g.program.Fset, clause.Comm),
+		}
+		var ident *ast.Ident
+		switch ch := unparen(ch).(type) {
+		case *ast.Ident:
+			ident = ch
+		case *ast.SelectorExpr:
+			ident = ch.Sel
+		}
+		if v, ok := pkgInfo.Uses[ident].(*types.Var); ok && ident != nil {
+			key, err := g.defKey(v)
+			if err != nil {
+				return err
+			}
+			flow.Channel = key
+		}
+		g.ControlFlows = append(g.ControlFlows, flow)
+	}
+	return nil
+}
+
+// recvChan returns the channel that the receive expression x (<-ch)
+// receives from, or nil if x isn't a receive.
+func recvChan(x ast.Expr) ast.Expr {
+	if u, ok := unparen(x).(*ast.UnaryExpr); ok && u.Op == token.ARROW {
+		return u.X
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package gog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestControlFlows(t *testing.T) {
+	src := `package foo
+
+type S struct{ ch chan int }
+
+var ch chan int
+
+func (s S) F(x int, chans []chan int) {
+	switch x {
+	case 1:
+		fallthrough
+	case 2, 3:
+		x++
+		fallthrough
+	default:
+	}
+	select {
+	case ch <- x:
+	case v := <-s.ch:
+		_ = v
+	case <-chans[0]:
+	default:
+	}
+}
+
+var G = func() {
+	select {
+	case x, ok := <-(ch):
+		_, _ = x, ok
+	}
+}
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+	g := New(prog)
+	g.SkipDocs = true
+	g.ControlFlow = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	type flow struct {
+		kind, fn, src, target, channel string
+	}
+	var flows []flow
+	for _, f := range g.ControlFlows {
+		got := flow{kind: f.Kind, fn: strings.Join(f.Func.Path, "/"), src: src[f.Span[0]:f.Span[1]]}
+		if f.TargetSpan != nil {
+			got.target = src[f.TargetSpan[0]:f.TargetSpan[1]]
+		}
+		if f.Channel != nil {
+			got.channel = strings.Join(f.Channel.Path, "/")
+		}
+		flows = append(flows, got)
+	}
+	want := []flow{
+		{FallthroughFlow, "S/F", "fallthrough", "case 2, 3:", ""},
+		{FallthroughFlow, "S/F", "fallthrough", "default:", ""},
+		{SendFlow, "S/F", "ch <- x", "", "ch"},
+		{RecvFlow, "S/F", "v := <-s.ch", "", "S/ch"},
+		{RecvFlow, "S/F", "<-chans[0]", "", ""},
+		{RecvFlow, "G", "x, ok := <-(ch)", "", "ch"},
+	}
+	if !reflect.DeepEqual(flows, want) {
+		t.Errorf("got control flows\n%+v\nwant\n%+v", flows, want)
+	}
+}
@@ -3,11 +3,15 @@ package gog
 import (
 	"fmt"
 	"go/ast"
+	"math"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
 
+	"golang.org/x/tools/go/exact"
 	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/types"
 )
@@ -30,6 +34,11 @@ type Def struct {
 	IdentSpan [2]int
 	DeclSpan  [2]int
 
+	// Test is whether the def is in a test file (*_test.go). Refs from
+	// test files to defs in the package's other files are to the
+	// non-test defs.
+	Test bool `json:",omitempty"`
+
 	definfo.DefInfo
 }
 
@@ -40,7 +49,7 @@ func (g *Grapher) NewDef(obj types.Object, declIdent *ast.Ident) (*Def, error) {
 	_, astPath, _ := g.program.PathEnclosingInterval(declIdent.Pos(), declIdent.End())
 	for _, node := range astPath {
 		switch node.(type) {
-		case *ast.FuncDecl, *ast.GenDecl, *ast.ValueSpec, *ast.TypeSpec, *ast.Field, *ast.DeclStmt, *ast.AssignStmt:
+		case *ast.FuncDecl, *ast.GenDecl, *ast.ValueSpec, *ast.TypeSpec, *ast.Field, *ast.DeclStmt, *ast.AssignStmt, *ast.LabeledStmt:
 			declNode = node
 			goto found
 		}
@@ -61,12 +70,24 @@ found:
 		PkgName:  obj.Pkg().Name(),
 		Kind:     defKind(obj),
 	}
+	si.InternalRoot, si.Internal = definfo.InternalRoot(key.PackageImportPath)
 
-	if typ := obj.Type(); typ != nil {
+	if _, isLabel := obj.(*types.Label); isLabel {
+		// labels have no type
+	} else if typ := obj.Type(); typ != nil {
 		si.TypeString = typ.String()
 		if utyp := typ.Underlying(); utyp != nil {
 			si.UnderlyingTypeString = utyp.String()
 		}
+		if typ == types.Typ[types.Invalid] {
+			// Types from the cgo pseudo-package "C" (such as C.int)
+			// are invalid because we don't run cgo, so describe them
+			// as written in the source.
+			if typeExpr := declTypeExpr(declNode); typeExpr != nil {
+				si.TypeString = types.ExprString(typeExpr)
+				si.UnderlyingTypeString = ""
+			}
+		}
 	}
 
 	switch obj := obj.(type) {
@@ -77,6 +98,26 @@ found:
 					si.FieldOfStruct = struct_.Obj().Name()
 				}
 			}
+			if obj.Anonymous() {
+				si.Embedded = true
+				mset := types.NewMethodSet(obj.Type())
+				for i := 0; i < mset.Len(); i++ {
+					si.PromotedMethods = append(si.PromotedMethods, mset.At(i).Obj().Name())
+				}
+				sort.Strings(si.PromotedMethods)
+			}
+			if field, ok := declNode.(*ast.Field); ok && field.Tag != nil {
+				// store malformed tags raw (without FieldTags)
+				if tag, err := strconv.Unquote(field.Tag.Value); err == nil {
+					si.FieldTag = tag
+					si.FieldTags, _ = parseStructTag(tag)
+				}
+			}
+		}
+	case *types.Const:
+		si.ConstValue = constValueString(obj.Val())
+		if spec, ok := declNode.(*ast.ValueSpec); ok {
+			si.ConstExpr = constExpr(astPath, spec, declIdent)
 		}
 	case *types.Func:
 		sig := obj.Type().(*types.Signature)
@@ -86,45 +127,161 @@ found:
 		}
 	}
 
+	file := g.program.Fset.Position(declIdent.Pos()).Filename
 	return &Def{
 		Name: obj.Name(),
 
 		DefKey: key,
 
-		File:      g.program.Fset.Position(declIdent.Pos()).Filename,
+		File:      file,
 		IdentSpan: makeSpan(g.program.Fset, declIdent),
 		DeclSpan:  makeSpan(g.program.Fset, declNode),
+		Test:      isTestFile(file),
 
 		DefInfo: si,
 	}, nil
 }
 
-// NewPackageDef creates a new Def that represents a Go package.
-func (g *Grapher) NewPackageDef(pkgInfo *loader.PackageInfo, pkg *types.Package) (*Def, error) {
-	var pkgDir string
-	if len(pkgInfo.Files) > 0 {
-		pkgDir = filepath.Dir(g.program.Fset.Position(pkgInfo.Files[0].Package).Filename)
+// constValueString returns the constant value v in Go syntax, or "" if
+// v is unknown (such as when the constant's declaration has errors).
+func constValueString(v exact.Value) string {
+	switch v.Kind() {
+	case exact.Unknown:
+		return ""
+	case exact.Float:
+		// exact represents floats as fractions ("3/2").
+		if f, _ := exact.Float64Val(v); !math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
 	}
+	return v.String()
+}
 
-	return &Def{
+// constExpr returns the expression of the constant declared by ident in
+// spec, or, if spec omits it, the expression of the previous spec in
+// the const block (whose ancestors are in astPath) that it implicitly
+// repeats.
+func constExpr(astPath []ast.Node, spec *ast.ValueSpec, ident *ast.Ident) string {
+	i := -1
+	for j, name := range spec.Names {
+		if name == ident {
+			i = j
+		}
+	}
+	if i == -1 {
+		return ""
+	}
+	if len(spec.Values) == 0 {
+		var block *ast.GenDecl
+		for _, node := range astPath {
+			if decl, ok := node.(*ast.GenDecl); ok {
+				block = decl
+				break
+			}
+		}
+		if block == nil {
+			return ""
+		}
+		var prev *ast.ValueSpec
+		for _, s := range block.Specs {
+			if s == spec {
+				break
+			}
+			if s := s.(*ast.ValueSpec); len(s.Values) > 0 {
+				prev = s
+			}
+		}
+		if prev == nil {
+			return ""
+		}
+		spec = prev
+	}
+	if i >= len(spec.Values) {
+		return ""
+	}
+	return types.ExprString(spec.Values[i])
+}
+
+// declTypeExpr returns the type expression in the declaration declNode,
+// or nil if it has none.
+func declTypeExpr(declNode ast.Node) ast.Expr {
+	switch n := declNode.(type) {
+	case *ast.Field:
+		return n.Type
+	case *ast.ValueSpec:
+		return n.Type
+	}
+	return nil
+}
+
+// NewPackageDef creates a new Def that represents a Go package. The def
+// is located at the package clause of the package's doc.go file, if it
+// has one, or else of the first file with a package doc comment (or of
+// the first file if none has one).
+func (g *Grapher) NewPackageDef(pkgInfo *loader.PackageInfo, pkg *types.Package) (*Def, error) {
+	def := &Def{
 		Name: pkg.Name(),
 
 		DefKey: &DefKey{PackageImportPath: pkg.Path(), Path: []string{}},
 
-		File: pkgDir,
-
 		DefInfo: definfo.DefInfo{
 			Exported: true,
 			PkgName:  pkg.Name(),
 			Kind:     definfo.Package,
 		},
-	}, nil
+	}
+
+	def.InternalRoot, def.Internal = definfo.InternalRoot(pkg.Path())
+
+	if f := g.packageClauseFile(pkgInfo); f != nil {
+		def.File = g.program.Fset.Position(f.Package).Filename
+		def.Test = isTestFile(def.File)
+		def.IdentSpan = makeSpan(g.program.Fset, f.Name)
+		def.DeclSpan = [2]int{g.program.Fset.Position(f.Package).Offset, def.IdentSpan[1]}
+	}
+
+	return def, nil
+}
+
+// packageClauseFile returns the file whose package clause is the
+// location of the package def (see NewPackageDef). Test files are only
+// considered if the package has no other files.
+func (g *Grapher) packageClauseFile(pkgInfo *loader.PackageInfo) *ast.File {
+	files := map[string]*ast.File{}
+	for _, f := range pkgInfo.Files {
+		if filename := g.program.Fset.Position(f.Package).Filename; !strings.HasSuffix(filename, "_test.go") {
+			files[filename] = f
+		}
+	}
+	if len(files) == 0 {
+		for _, f := range pkgInfo.Files {
+			files[g.program.Fset.Position(f.Package).Filename] = f
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	sorted := sortedFiles(files)
+	for _, f := range sorted {
+		if filepath.Base(g.program.Fset.Position(f.Package).Filename) == "doc.go" {
+			return f
+		}
+	}
+	for _, f := range sorted {
+		if f.Doc != nil {
+			return f
+		}
+	}
+	return sorted[0]
 }
 
 func defKind(obj types.Object) string {
 	switch obj := obj.(type) {
 	case *types.PkgName:
 		return definfo.Package
+	case *types.Label:
+		return definfo.Label
 	case *types.Const:
 		return definfo.Const
 	case *types.TypeName:
@@ -0,0 +1,181 @@
+package gog
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestConstValues(t *testing.T) {
+	src := `package foo
+
+type Weekday int
+
+const (
+	Sunday Weekday = iota
+	Monday
+	Tuesday
+)
+
+const (
+	_  = iota
+	KB = 1 << (10 * iota)
+	MB
+)
+
+const (
+	A, B = iota, iota * 10
+	C, D
+)
+
+const (
+	S = "s"
+	F = 1.5
+	G
+	H = 'h'
+	X = Undefined
+)
+`
+	prog := createPkg(t, "foo", []string{src}, []string{"f.go"})
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]*Def{}
+	for _, d := range g.Defs {
+		defs[strings.Join(d.Path, "/")] = d
+	}
+
+	tests := []struct {
+		path, value, expr, typ string
+	}{
+		{"Sunday", "0", "iota", "foo.Weekday"},
+		{"Monday", "1", "iota", "foo.Weekday"},
+		{"Tuesday", "2", "iota", "foo.Weekday"},
+		{"KB", "1024", "1 << (10 * iota)", "untyped int"},
+		{"MB", "1048576", "1 << (10 * iota)", "untyped int"},
+		{"A", "0", "iota", "untyped int"},
+		{"B", "0", "iota * 10", "untyped int"},
+		{"C", "1", "iota", "untyped int"},
+		{"D", "10", "iota * 10", "untyped int"},
+		{"S", `"s"`, `"s"`, "untyped string"},
+		{"F", "1.5", "1.5", "untyped float"},
+		{"G", "1.5", "1.5", "untyped float"},
+		{"H", "104", "'h'", "untyped rune"},
+		{"X", "", "Undefined", "invalid type"},
+	}
+	for _, test := range tests {
+		def := defs[test.path]
+		if def == nil {
+			t.Errorf("%s: no def", test.path)
+			continue
+		}
+		if def.ConstValue != test.value {
+			t.Errorf("%s: got ConstValue %q, want %q", test.path, def.ConstValue, test.value)
+		}
+		if def.ConstExpr != test.expr {
+			t.Errorf("%s: got ConstExpr %q, want %q", test.path, def.ConstExpr, test.expr)
+		}
+		if def.TypeString != test.typ {
+			t.Errorf("%s: got TypeString %q, want %q", test.path, def.TypeString, test.typ)
+		}
+	}
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	src := `package foo
+
+type R struct{}
+
+func (R) Read()   {}
+func (*R) Close() {}
+
+type I interface{ M() }
+
+type J interface {
+	I
+	N()
+}
+
+type T struct {
+	R
+	*Q
+	I
+	x int
+}
+
+type Q struct{ R }
+`
+	prog := createPkg(t, "foo", []string{src}, []string{"f.go"})
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]*Def{}
+	for _, d := range g.Defs {
+		defs[strings.Join(d.Path, "/")] = d
+	}
+	wantPromoted := map[string][]string{
+		"T/R": {"Read"},
+		"T/Q": {"Close", "Read"},
+		"T/I": {"M"},
+		"T/x": nil,
+		"Q/R": {"Read"},
+	}
+	for path, want := range wantPromoted {
+		d := defs[path]
+		if d == nil {
+			t.Errorf("%s: no def", path)
+			continue
+		}
+		if d.Embedded != (want != nil) {
+			t.Errorf("%s: got Embedded %v, want %v", path, d.Embedded, want != nil)
+		}
+		if !reflect.DeepEqual(d.PromotedMethods, want) {
+			t.Errorf("%s: got PromotedMethods %v, want %v", path, d.PromotedMethods, want)
+		}
+	}
+
+	// The explicit methods of an interface that embeds another have
+	// the embedding interface's paths, not the embedded one's.
+	if d := defs["I/M"]; d == nil || src[d.IdentSpan[0]:d.IdentSpan[1]] != "M" {
+		t.Errorf("got def I/M %+v, want the M in I", d)
+	}
+	if defs["J/N"] == nil {
+		t.Error("no def J/N")
+	}
+
+	// An embedded type name is both the field's def and a ref to the
+	// type.
+	refs := map[string][]string{}
+	for _, r := range g.Refs {
+		if r.Def.PackageImportPath != "foo" {
+			continue
+		}
+		at := fmt.Sprintf("%s@%d", src[r.Span[0]:r.Span[1]], r.Span[0])
+		refs[at] = append(refs[at], fmt.Sprintf("%s:%v", strings.Join(r.Def.Path, "/"), r.IsDef))
+	}
+	wantRefs := []struct {
+		context string // the embedded name is at the start
+		want    []string
+	}{
+		{"R\n\t*Q", []string{"R:false", "T/R:true"}},
+		{"Q\n\tI", []string{"Q:false", "T/Q:true"}},
+		{"I\n\tx", []string{"I:false", "T/I:true"}},
+		{"I\n\tN()", []string{"I:false"}},
+	}
+	for _, w := range wantRefs {
+		at := fmt.Sprintf("%s@%d", w.context[:1], strings.Index(src, w.context))
+		got := refs[at]
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, w.want) {
+			t.Errorf("embedded %q: got refs %v, want %v", w.context, got, w.want)
+		}
+	}
+}
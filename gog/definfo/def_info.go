@@ -19,13 +19,141 @@ type DefInfo struct {
 	// def is not a struct field).
 	FieldOfStruct string `json:",omitempty"`
 
+	// Embedded is whether this def is an embedded (anonymous) struct
+	// field, whose type's fields and methods are promoted to the
+	// struct.
+	Embedded bool `json:",omitempty"`
+
+	// PromotedMethods lists the names (sorted) of the methods in the
+	// method set of this embedded field's type, which are promoted to
+	// the struct (unless the struct has a field or method of the same
+	// name at a shallower depth).
+	PromotedMethods []string `json:",omitempty"`
+
+	// FieldTag is the raw tag of this def (or the empty string if this
+	// def is not a struct field with a tag).
+	FieldTag string `json:",omitempty"`
+
+	// FieldTags is FieldTag parsed (using reflect.StructTag
+	// conventions) into a map of tag keys to values. It is nil if
+	// FieldTag is empty or malformed.
+	FieldTags map[string]StructTagValue `json:",omitempty"`
+
 	// TypeString is a string describing this def's Go type.
 	TypeString string
 
 	// UnderlyingTypeString is the function or method signature, if this is a function or method.
 	UnderlyingTypeString string `json:",omitempty"`
 
+	// Deprecated is whether this def's doc comment has a paragraph
+	// beginning with "Deprecated:". It is only set when docs are
+	// emitted.
+	Deprecated bool `json:",omitempty"`
+
+	// DeprecationMessage is the text of the "Deprecated:" paragraph
+	// (without the "Deprecated:" prefix), if Deprecated is true.
+	DeprecationMessage string `json:",omitempty"`
+
+	// Internal is whether this def is in an internal package (one whose
+	// import path has an "internal" element), which may only be
+	// imported by packages in the tree rooted at InternalRoot.
+	Internal bool `json:",omitempty"`
+
+	// InternalRoot is the import path of the parent of the (last)
+	// "internal" element of this def's package import path, if Internal
+	// is true. It is empty for internal packages at the top of the
+	// standard library (such as internal/race), which only standard
+	// library packages may import.
+	InternalRoot string `json:",omitempty"`
+
+	// ConstValue is the value of this def, in Go syntax (such as 4,
+	// 1.5, or "s"), if it's a constant whose value is known.
+	ConstValue string `json:",omitempty"`
+
+	// ConstExpr is the expression that this constant's value is
+	// computed from, if this def is a constant. In a const block in
+	// which the declaration omits the expression, it is the implicitly
+	// repeated expression of the previous declaration (which is
+	// evaluated with the declaration's own value of iota).
+	ConstExpr string `json:",omitempty"`
+
+	// Embed is the //go:embed directives preceding this def, if it's a
+	// package-level var. On a package def, it is the package's go:embed
+	// directives that don't precede the declaration of a single var
+	// (which the go tool rejects).
+	Embed []Directive `json:",omitempty"`
+
+	// Generate is the //go:generate directives in the package's files,
+	// if this is a package def.
+	Generate []Directive `json:",omitempty"`
+
+	// Notes are the marked notes (such as "BUG(uid): ..." and
+	// "TODO(uid): ...", as recognized by go/doc) in the package's
+	// comments, if this is a package def. They are only set when docs
+	// are emitted.
+	Notes []Note `json:",omitempty"`
+
+	// Platforms lists the platforms (GOOS/GOARCH, such as
+	// "windows/amd64") that this def exists on, if the package was
+	// graphed on several platforms and this def doesn't exist on all of
+	// them (because it's in files with build constraints).
+	Platforms []string `json:",omitempty"`
+
+	// PlatformTypeStrings maps each platform that this def exists on to
+	// its TypeString on that platform, if the package was graphed on
+	// several platforms and this def's type differs between them.
+	// TypeString is its type on the first platform that was graphed.
+	PlatformTypeStrings map[string]string `json:",omitempty"`
+
 	// Kind is the kind of Go thing this def is: struct, interface, func,
 	// package, etc.
 	Kind string `json:",omitempty"`
 }
+
+// Note is a marked note in a package's comments, such as
+// "// BUG(uid): body".
+type Note struct {
+	// Marker is the note's marker, such as "BUG" or "TODO". Any marker
+	// of two or more uppercase letters is recognized.
+	Marker string
+
+	// UID is the note's user ID (between the parentheses after the
+	// marker), and Body is its text.
+	UID  string
+	Body string
+
+	// File is the base name of the file containing the note, and Span
+	// is the byte range of the note's comment in the file.
+	File string
+	Span [2]int
+}
+
+// Directive is a //go:embed or //go:generate comment directive.
+type Directive struct {
+	// File is the base name of the file containing the directive, and
+	// Line is the directive's line number in the file.
+	File string
+	Line int
+
+	// Args are the directive's space-separated (and possibly quoted)
+	// arguments, unquoted: the patterns of a go:embed directive, or
+	// the command and its arguments of a go:generate directive. It is
+	// nil if the directive is malformed (such as if it has no
+	// arguments or an unterminated quoted argument).
+	Args []string `json:",omitempty"`
+
+	// Raw is the text of the directive after "//go:embed" or
+	// "//go:generate", without leading and trailing space.
+	Raw string
+}
+
+// StructTagValue is the value of a key in a struct field tag. For
+// example, the tag `json:"name,omitempty"` has the key "json" with
+// Name "name" and Options ["omitempty"].
+type StructTagValue struct {
+	// Name is the part of the value before the first comma.
+	Name string
+
+	// Options are the comma-separated parts of the value after Name.
+	Options []string `json:",omitempty"`
+}
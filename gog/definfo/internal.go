@@ -0,0 +1,38 @@
+package definfo
+
+import "strings"
+
+// InternalRoot returns the import path of the tree whose packages may
+// import the package importPath, according to Go's internal package
+// rule: if importPath has an "internal" element, only packages rooted
+// at its parent may import it. If importPath isn't internal, it returns
+// false.
+func InternalRoot(importPath string) (root string, internal bool) {
+	switch {
+	case strings.HasSuffix(importPath, "/internal"):
+		return strings.TrimSuffix(importPath, "/internal"), true
+	case strings.Contains(importPath, "/internal/"):
+		return importPath[:strings.LastIndex(importPath, "/internal/")], true
+	case importPath == "internal" || strings.HasPrefix(importPath, "internal/"):
+		return "", true
+	}
+	return "", false
+}
+
+// InternalImportAllowed reports whether the package importer may import
+// the package imported, according to Go's internal package rule.
+func InternalImportAllowed(importer, imported string) bool {
+	root, internal := InternalRoot(imported)
+	if !internal {
+		return true
+	}
+	// An xtest package is in the same tree as the package it tests.
+	importer = strings.TrimSuffix(importer, "_test")
+	if root == "" {
+		// Only standard library packages (whose import paths' first
+		// elements have no dots) may import top-level internal
+		// packages.
+		return !strings.Contains(strings.SplitN(importer, "/", 2)[0], ".")
+	}
+	return importer == root || strings.HasPrefix(importer, root+"/")
+}
@@ -0,0 +1,30 @@
+package definfo
+
+import "testing"
+
+func TestInternalImportAllowed(t *testing.T) {
+	tests := []struct {
+		importer, imported string
+		want               bool
+	}{
+		{"a/b", "a/c", true},
+		{"a", "a/internal/c", true},
+		{"a/b", "a/internal/c", true},
+		{"a/internal/b", "a/internal/c", true},
+		{"ab", "a/internal/c", false},
+		{"x/b", "a/internal/c", false},
+		{"a/b", "a/b/internal", true},
+		{"a/c", "a/b/internal", false},
+		{"a/internal/x", "a/internal/b/internal/c", false},
+		{"a/internal/b/x", "a/internal/b/internal/c", true},
+		{"a/internal/b_test", "a/internal/b/internal/c", true},
+		{"net/http", "internal/race", true},
+		{"example.com/a", "internal/race", false},
+		{"internalx/a", "a/internalx/b", true},
+	}
+	for _, test := range tests {
+		if got := InternalImportAllowed(test.importer, test.imported); got != test.want {
+			t.Errorf("InternalImportAllowed(%q, %q): got %v, want %v", test.importer, test.imported, got, test.want)
+		}
+	}
+}
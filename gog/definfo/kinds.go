@@ -9,6 +9,7 @@ const (
 	Type      = "type"
 	Interface = "interface"
 	Const     = "const"
+	Label     = "label"
 )
 
 var GeneralKindMap = map[string]string{
@@ -20,4 +21,5 @@ var GeneralKindMap = map[string]string{
 	Var:       Var,
 	Const:     Const,
 	Interface: Type,
+	Label:     Label,
 }
@@ -0,0 +1,237 @@
+package gog
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+// Def path schemes (see Grapher.DefKeyScheme).
+const (
+	// DefKeySchemeV1 is the original def path scheme. Package-level
+	// defs have stable paths, but the path of a local def (such as a
+	// function's parameters and local variables) includes the index of
+	// each enclosing block in its parent and, for some defs, the def's
+	// byte offset in its file. So local def paths change when unrelated
+	// code (even whitespace) changes.
+	DefKeySchemeV1 = "v1"
+
+	// DefKeySchemeV2 is a def path scheme in which def paths only
+	// change when the def is renamed, moved to another top-level
+	// declaration, or reordered relative to other local defs of the
+	// same name in its top-level declaration. In this scheme:
+	//
+	//   - A package-level def's path is its name (Name), and the path of
+	//     a method or of a field of a package-level struct type is the
+	//     type's name followed by the method or field name (T/M, T/F).
+	//     These are the same as in DefKeySchemeV1.
+	//
+	//   - The path of the Nth (counting from 0) init func in a file
+	//     named f.go is init$f$N.
+	//
+	//   - A local def's path is the path of the top-level func, method,
+	//     var, const, or type declaration that contains it, followed by its
+	//     name and its index among the local defs of the same name in
+	//     that declaration, in source order (F/x$0, T/M/x$1). Labels are
+	//     numbered separately as L$label$0, etc. The variables that each
+	//     clause of a type switch implicitly declares are in the order
+	//     of the clauses.
+	//
+	// Because a "$" can't occur in a Go identifier, local and init def
+	// paths can't collide with package-level def paths. As in
+	// DefKeySchemeV1, the path of a def in a command (package main) is
+	// prefixed with its file name.
+	DefKeySchemeV2 = "v2"
+)
+
+// defPath returns the path of obj's def in the Grapher's DefKeyScheme
+// (without the file name prefix for package main).
+func (g *Grapher) defPath(obj types.Object) []string {
+	if g.DefKeyScheme != DefKeySchemeV2 {
+		return g.path(obj)
+	}
+	return g.pathV2(obj)
+}
+
+func (g *Grapher) pathV2(obj types.Object) []string {
+	if path, present := g.pathsV2[obj]; present {
+		return path
+	}
+
+	path := g.path(obj)
+	if !g.isLocalV2(obj) || obj.Pos() == token.NoPos {
+		return path
+	}
+
+	pkgInfo, astPath, _ := g.program.PathEnclosingInterval(obj.Pos(), obj.Pos())
+	if len(astPath) < 2 {
+		return path
+	}
+	file, ok := astPath[len(astPath)-1].(*ast.File)
+	if !ok {
+		return path
+	}
+	decl, ok := astPath[len(astPath)-2].(ast.Decl)
+	if !ok {
+		return path
+	}
+	g.assignLocalPathsV2(pkgInfo, file, decl)
+	if path, present := g.pathsV2[obj]; present {
+		return path
+	}
+	return g.path(obj)
+}
+
+// isLocalV2 reports whether obj's DefKeySchemeV2 path is assigned by
+// assignLocalPathsV2: whether it's declared in a (non-package) local
+// scope or its DefKeySchemeV1 path has local components (which is also
+// the case for fields of local struct types and for init funcs).
+func (g *Grapher) isLocalV2(obj types.Object) bool {
+	if hasLocalComponent(g.path(obj)) {
+		return true
+	}
+	s := obj.Parent()
+	return s != nil && obj.Pkg() != nil && s != obj.Pkg().Scope() && s != types.Universe
+}
+
+// hasLocalComponent reports whether the (DefKeySchemeV1) def path has a
+// component that identifies a block or a byte offset.
+func hasLocalComponent(path []string) bool {
+	for _, c := range path {
+		if strings.Contains(c, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// assignLocalPathsV2 assigns DefKeySchemeV2 paths to the local defs in
+// the top-level declaration decl in file (and to decl itself, if it's
+// an init func).
+func (g *Grapher) assignLocalPathsV2(pkgInfo *loader.PackageInfo, file *ast.File, decl ast.Decl) {
+	if g.pathsV2 == nil {
+		g.pathsV2 = make(map[types.Object][]string)
+	}
+
+	var prefix []string
+	var declObjs []types.Object // decl's own package-level objects
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		obj := pkgInfo.Defs[decl.Name]
+		if obj == nil {
+			return
+		}
+		declObjs = []types.Object{obj}
+		if decl.Recv == nil && decl.Name.Name == "init" {
+			var n int
+			for _, d := range file.Decls {
+				if d == decl {
+					break
+				}
+				if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == "init" {
+					n++
+				}
+			}
+			filename := g.program.Fset.Position(file.Pos()).Filename
+			prefix = []string{fmt.Sprintf("init$%s$%d", strippedFilename(filename), n)}
+			g.pathsV2[obj] = prefix
+		} else {
+			prefix = g.path(obj)
+		}
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.ValueSpec:
+				for _, name := range spec.Names {
+					if obj := pkgInfo.Defs[name]; obj != nil {
+						declObjs = append(declObjs, obj)
+					}
+				}
+			case *ast.TypeSpec:
+				if obj := pkgInfo.Defs[spec.Name]; obj != nil {
+					declObjs = append(declObjs, obj)
+				}
+			}
+		}
+	}
+
+	// Collect the local defs, in source order.
+	var locals []localDef
+	isDeclObj := func(obj types.Object) bool {
+		for _, o := range declObjs {
+			if o == obj {
+				return true
+			}
+		}
+		return false
+	}
+	var specPrefix []string
+	ast.Inspect(decl, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.ValueSpec:
+			if _, isGenDecl := decl.(*ast.GenDecl); isGenDecl && len(node.Names) > 0 {
+				if obj := pkgInfo.Defs[node.Names[0]]; obj != nil {
+					specPrefix = g.path(obj)
+				}
+			}
+		case *ast.TypeSpec:
+			if _, isGenDecl := decl.(*ast.GenDecl); isGenDecl {
+				if obj := pkgInfo.Defs[node.Name]; obj != nil {
+					specPrefix = g.path(obj)
+				}
+			}
+		case *ast.Ident:
+			obj := pkgInfo.Defs[node]
+			if obj == nil || obj.Pos() != node.Pos() || node.Name == "_" || isDeclObj(obj) || !g.isLocalV2(obj) {
+				return true
+			}
+			name := obj.Name()
+			if _, isLabel := obj.(*types.Label); isLabel {
+				name += "$label"
+			}
+			locals = append(locals, localDef{obj: obj, name: name, pos: node.Pos(), spec: specPrefix})
+		case *ast.CaseClause:
+			if obj := pkgInfo.Implicits[node]; obj != nil && obj.Name() != "_" {
+				locals = append(locals, localDef{obj: obj, name: obj.Name(), pos: obj.Pos(), order: node.Pos(), spec: specPrefix})
+			}
+		}
+		return true
+	})
+	sort.Sort(localDefsByPos(locals))
+
+	counts := map[string]int{}
+	for _, l := range locals {
+		p := prefix
+		if p == nil {
+			p = l.spec
+		}
+		key := strings.Join(p, "/") + "/" + l.name
+		g.pathsV2[l.obj] = append(append([]string{}, p...), fmt.Sprintf("%s$%d", l.name, counts[key]))
+		counts[key]++
+	}
+}
+
+// localDef is a local def in a top-level declaration.
+type localDef struct {
+	obj   types.Object
+	name  string
+	pos   token.Pos
+	order token.Pos // orders type switch clause vars, which share a pos
+	spec  []string  // path of the enclosing var, const, or type spec, if any
+}
+
+type localDefsByPos []localDef
+
+func (v localDefsByPos) Len() int { return len(v) }
+func (v localDefsByPos) Less(i, j int) bool {
+	if v[i].pos != v[j].pos {
+		return v[i].pos < v[j].pos
+	}
+	return v[i].order < v[j].order
+}
+func (v localDefsByPos) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
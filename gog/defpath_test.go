@@ -0,0 +1,151 @@
+package gog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefKeySchemeV2(t *testing.T) {
+	src := `package foo
+
+type T struct {
+	F func(x int)
+}
+
+type U struct {
+	F func(x int)
+}
+
+func (T) M(x int) {
+	{
+		x := x
+		_ = x
+	}
+}
+
+func (U) M(x int) {}
+
+func init() { x := 0; _ = x }
+func init() { x := 0; _ = x }
+
+func F(v interface{}) {
+	x := 1
+	{
+		x := 2
+		_ = x
+	}
+	switch x := v.(type) {
+	case int:
+		_ = x
+	case string:
+		_ = x
+	}
+L:
+	for {
+		break L
+	}
+	_ = x
+}
+
+var G = func() { x := 0; _ = x }
+`
+	// The same source after cosmetic edits: comments, whitespace,
+	// statements and blocks that don't declare anything, and another
+	// function.
+	edited := `package foo
+
+// T is a type.
+type T struct {
+	F func(x int)
+}
+
+type U struct {
+	F func(x int)
+}
+
+func Unrelated() { y := 0; _ = y }
+
+func (T) M(x int) {
+
+	{
+		x := x // shadows the param
+		_ = x
+	}
+}
+
+func (U) M(x int) {}
+
+func init() { x := 0; _ = x }
+
+func init() {
+	x := 0
+	_ = x
+}
+
+func F(v interface{}) {
+	if true { // a new block
+		println()
+	}
+	x := 1
+	{
+		x := 2
+		_ = x
+	}
+	switch x := v.(type) {
+	case int:
+		_ = x
+	case string:
+		_ = x
+	}
+L:
+	for {
+		break L
+	}
+	_ = x
+}
+
+var G = func() {
+	x := 0
+	_ = x
+}
+`
+	paths := func(src string) map[string]int {
+		prog := createPkg(t, "foo", []string{src}, []string{"f.go"})
+		g := New(prog)
+		g.SkipDocs = true
+		g.DefKeyScheme = DefKeySchemeV2
+		if err := g.Graph(prog.Created[0]); err != nil {
+			t.Fatal(err)
+		}
+		paths := map[string]int{}
+		for _, d := range g.Defs {
+			paths[d.DefKey.String()]++
+		}
+		return paths
+	}
+
+	got := paths(src)
+	for path, n := range got {
+		if n != 1 {
+			t.Errorf("path %s is used by %d defs, want 1", path, n)
+		}
+	}
+	for _, want := range []string{
+		"foo#T.x$0", "foo#U.x$0",
+		"foo#T.M.x$0", "foo#T.M.x$1", "foo#U.M.x$0",
+		"foo#init$f$0", "foo#init$f$0.x$0", "foo#init$f$1.x$0",
+		"foo#F.v$0", "foo#F.x$0", "foo#F.x$1", "foo#F.x$2", "foo#F.x$3", "foo#F.L$label$0",
+		"foo#G.x$0",
+	} {
+		if got[want] == 0 {
+			t.Errorf("no def with path %s", want)
+		}
+	}
+
+	gotEdited := paths(edited)
+	delete(gotEdited, "foo#Unrelated")
+	delete(gotEdited, "foo#Unrelated.y$0")
+	if !reflect.DeepEqual(gotEdited, got) {
+		t.Errorf("paths changed after cosmetic edits:\ngot  %v\nwant %v", gotEdited, got)
+	}
+}
@@ -0,0 +1,166 @@
+package gog
+
+import (
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+// emitDirectives records the //go:embed and //go:generate directives in
+// pkgInfo's files on the (already emitted) defs of the vars that they
+// precede and of the package. See definfo.DefInfo's Embed and Generate
+// fields.
+func (g *Grapher) emitDirectives(pkgInfo *loader.PackageInfo) error {
+	// The loader doesn't keep comments, so parse the files again.
+	objOf := g.defObjsByPosition(pkgInfo)
+	filenames := g.pkgFilenames(pkgInfo)
+	files, err := g.parseFiles(filenames, false)
+	if err != nil {
+		return err
+	}
+
+	pkgDef := g.emittedDef(&DefKey{PackageImportPath: pkgInfo.Pkg.Path(), Path: []string{}})
+	for _, filename := range filenames {
+		file := files[filename]
+
+		// Find the var that each go:embed directive applies to: a
+		// directive must be in the doc comment of the declaration of a
+		// single package-level var.
+		embedVars := map[*ast.Comment]types.Object{}
+		for _, decl := range file.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.ValueSpec)
+				dc := spec.Doc
+				if !decl.Lparen.IsValid() {
+					dc = decl.Doc
+				}
+				if dc == nil || len(spec.Names) != 1 {
+					continue
+				}
+				if obj := objOf[g.program.Fset.Position(spec.Names[0].Pos())]; obj != nil {
+					for _, c := range dc.List {
+						embedVars[c] = obj
+					}
+				}
+			}
+		}
+
+		for _, cg := range file.Comments {
+			for _, c := range cg.List {
+				name, text, ok := parseDirective(c.Text)
+				if !ok {
+					continue
+				}
+				pos := g.program.Fset.Position(c.Pos())
+				dir := definfo.Directive{
+					File: filepath.Base(filename),
+					Line: pos.Line,
+					Args: splitDirectiveArgs(text),
+					Raw:  text,
+				}
+				switch name {
+				case "embed":
+					def := pkgDef
+					if obj, present := embedVars[c]; present {
+						key, err := g.defKey(obj)
+						if err != nil {
+							return err
+						}
+						def = g.emittedDef(key)
+					}
+					if def != nil {
+						def.Embed = append(def.Embed, dir)
+					}
+				case "generate":
+					// go generate only recognizes directives at the
+					// beginning of a line.
+					if pos.Column == 1 && pkgDef != nil {
+						pkgDef.Generate = append(pkgDef.Generate, dir)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseDirective returns the name ("embed" or "generate") and the
+// trimmed text after the name of the //go:embed or //go:generate
+// directive comment, or false if comment isn't one.
+func parseDirective(comment string) (name, text string, ok bool) {
+	for _, name := range []string{"embed", "generate"} {
+		prefix := "//go:" + name
+		if !strings.HasPrefix(comment, prefix) {
+			continue
+		}
+		rest := comment[len(prefix):]
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			continue
+		}
+		return name, strings.TrimSpace(rest), true
+	}
+	return "", "", false
+}
+
+// splitDirectiveArgs splits the text of a directive into its
+// space-separated arguments, which may be double- or back-quoted Go
+// string literals. It returns nil if the text has no arguments or a
+// malformed quoted argument.
+func splitDirectiveArgs(text string) []string {
+	var args []string
+	for {
+		text = strings.TrimLeft(text, " \t")
+		if text == "" {
+			return args
+		}
+
+		var arg string
+		switch text[0] {
+		case '"', '`':
+			end := -1
+			for i := 1; i < len(text); i++ {
+				if text[0] == '"' && text[i] == '\\' {
+					i++
+				} else if text[i] == text[0] {
+					end = i + 1
+					break
+				}
+			}
+			if end == -1 {
+				return nil
+			}
+			var err error
+			if arg, err = strconv.Unquote(text[:end]); err != nil {
+				return nil
+			}
+			text = text[end:]
+			if text != "" && text[0] != ' ' && text[0] != '\t' {
+				return nil
+			}
+		default:
+			end := strings.IndexAny(text, " \t")
+			if end == -1 {
+				end = len(text)
+			}
+			arg, text = text[:end], text[end:]
+		}
+		args = append(args, arg)
+	}
+}
+
+// emittedDef returns the already emitted def with the given key, or nil
+// if there is none.
+func (g *Grapher) emittedDef(key *DefKey) *Def {
+	return g.defs[key.String()]
+}
@@ -0,0 +1,94 @@
+package gog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
+)
+
+func TestDirectives(t *testing.T) {
+	prog, cleanup := createPkgInTempDir(t, "foo", map[string]string{
+		"a.go": `package foo
+
+//go:generate stringer -type=T
+//go:generate sh -c "echo \"hi\" > x.txt"
+
+// A is embedded.
+//
+//go:embed a.txt files/*.html
+var A string
+
+var (
+	//go:embed "b c.txt" ` + "`d.txt`" + `
+	B []byte
+
+	C int
+)
+
+//go:embed "unterminated
+var D string
+
+//go:embed e.txt
+func F() {}
+
+	//go:generate not-at-line-start
+`,
+	})
+	defer cleanup()
+
+	g := New(prog)
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]*Def{}
+	for _, d := range g.Defs {
+		defs[strings.Join(d.Path, "/")] = d
+	}
+
+	tests := []struct {
+		path     string
+		embed    []definfo.Directive
+		generate []definfo.Directive
+	}{
+		{
+			path: "",
+			embed: []definfo.Directive{
+				{File: "a.go", Line: 21, Args: []string{"e.txt"}, Raw: "e.txt"},
+			},
+			generate: []definfo.Directive{
+				{File: "a.go", Line: 3, Args: []string{"stringer", "-type=T"}, Raw: "stringer -type=T"},
+				{File: "a.go", Line: 4, Args: []string{"sh", "-c", `echo "hi" > x.txt`}, Raw: `sh -c "echo \"hi\" > x.txt"`},
+			},
+		},
+		{
+			path:  "A",
+			embed: []definfo.Directive{{File: "a.go", Line: 8, Args: []string{"a.txt", "files/*.html"}, Raw: "a.txt files/*.html"}},
+		},
+		{
+			path:  "B",
+			embed: []definfo.Directive{{File: "a.go", Line: 12, Args: []string{"b c.txt", "d.txt"}, Raw: "\"b c.txt\" `d.txt`"}},
+		},
+		{path: "C"},
+		{
+			path:  "D",
+			embed: []definfo.Directive{{File: "a.go", Line: 18, Raw: `"unterminated`}},
+		},
+		{path: "F"},
+	}
+	for _, test := range tests {
+		def := defs[test.path]
+		if def == nil {
+			t.Errorf("%q: no def", test.path)
+			continue
+		}
+		if !reflect.DeepEqual(def.Embed, test.embed) {
+			t.Errorf("%q: got Embed %+v, want %+v", test.path, def.Embed, test.embed)
+		}
+		if !reflect.DeepEqual(def.Generate, test.generate) {
+			t.Errorf("%q: got Generate %+v, want %+v", test.path, def.Generate, test.generate)
+		}
+	}
+}
@@ -4,15 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
-	"go/build"
 	"go/doc"
-	"go/parser"
 	"go/token"
-	"io"
 	"log"
-	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
 
 	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/types"
@@ -28,38 +27,20 @@ type Doc struct {
 	Span [2]int `json:",omitempty"`
 }
 
-func parseFiles(fset *token.FileSet, filenames []string) (map[string]*ast.File, error) {
-	files := make(map[string]*ast.File)
-	for _, path := range filenames {
-		// read file contents using go/build context so we use our vfs if
-		// present
-		var f io.ReadCloser
-		var err error
-		if build.Default.OpenFile != nil {
-			f, err = build.Default.OpenFile(path)
-		} else {
-			f, err = os.Open(path)
-		}
-		if err != nil {
-			return nil, err
-		}
-		defer f.Close()
-
-		file, err := parser.ParseFile(fset, path, f, parser.ParseComments)
-		if err != nil {
-			return nil, err
-		}
-		files[path] = file
-	}
-	return files, nil
-}
-
-func (g *Grapher) emitDocs(pkgInfo *loader.PackageInfo) error {
+// defObjsByPosition maps the position of each def ident in pkgInfo to
+// its object, to find the objects of idents in files that are parsed
+// again (with comments).
+func (g *Grapher) defObjsByPosition(pkgInfo *loader.PackageInfo) map[token.Position]types.Object {
 	objOf := make(map[token.Position]types.Object, len(pkgInfo.Defs))
 	for ident, obj := range pkgInfo.Defs {
 		objOf[g.program.Fset.Position(ident.Pos())] = obj
 	}
+	return objOf
+}
 
+// pkgFilenames returns the sorted names of pkgInfo's files, omitting
+// cgo-generated files.
+func (g *Grapher) pkgFilenames(pkgInfo *loader.PackageInfo) []string {
 	var filenames []string
 	for _, f := range pkgInfo.Files {
 		name := g.program.Fset.Position(f.Name.Pos()).Filename
@@ -70,16 +51,67 @@ func (g *Grapher) emitDocs(pkgInfo *loader.PackageInfo) error {
 		filenames = append(filenames, name)
 	}
 	sort.Strings(filenames)
-	files, err := parseFiles(g.program.Fset, filenames)
+	return filenames
+}
+
+func (g *Grapher) emitDocs(pkgInfo *loader.PackageInfo) error {
+	objOf := g.defObjsByPosition(pkgInfo)
+
+	filenames := g.pkgFilenames(pkgInfo)
+	// doc.New modifies the ASTs, so take them from the parse cache.
+	files, err := g.parseFiles(filenames, true)
 	if err != nil {
 		return err
 	}
 
+	// emit examples before calling doc.New, which discards func bodies
+	var sortedFiles []*ast.File
+	for _, name := range filenames {
+		sortedFiles = append(sortedFiles, files[name])
+	}
+	if err := g.emitExamples(pkgInfo, sortedFiles, objOf); err != nil {
+		return err
+	}
+
+	// go/doc doesn't extract docs for struct fields and interface
+	// methods, so find them in the AST.
+	for _, file := range sortedFiles {
+		var err error
+		ast.Inspect(file, func(node ast.Node) bool {
+			var fields *ast.FieldList
+			switch node := node.(type) {
+			case *ast.StructType:
+				fields = node.Fields
+			case *ast.InterfaceType:
+				fields = node.Methods
+			default:
+				return err == nil
+			}
+			for _, field := range fields.List {
+				dc := firstNonNil(field.Doc, field.Comment)
+				if dc == nil {
+					continue
+				}
+				for _, name := range field.Names {
+					if err = g.emitDoc(objOf[g.program.Fset.Position(name.Pos())], dc, dc.Text()); err != nil {
+						return false
+					}
+				}
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	// ignore errors because we assume that syntax checking has already occurred
 	astPkg, _ := ast.NewPackage(g.program.Fset, files, nil, nil)
 
 	docPkg := doc.New(astPkg, pkgInfo.Pkg.Path(), doc.AllDecls)
 
+	g.recordNotes(pkgInfo, docPkg)
+
 	if docPkg.Doc != "" {
 		err := g.emitDoc(types.NewPkgName(0, pkgInfo.Pkg, pkgInfo.Pkg.Path(), pkgInfo.Pkg), nil, docPkg.Doc)
 		if err != nil {
@@ -91,8 +123,13 @@ func (g *Grapher) emitDocs(pkgInfo *loader.PackageInfo) error {
 		for _, spec := range decl.Specs {
 			switch spec := spec.(type) {
 			case *ast.ValueSpec:
+				dc, docstring := firstNonNil(decl.Doc, spec.Doc, spec.Comment), docstring
+				if decl.Lparen.IsValid() && spec.Doc != nil {
+					// prefer the spec's own doc in a grouped declaration
+					dc, docstring = spec.Doc, spec.Doc.Text()
+				}
 				for _, name := range spec.Names {
-					g.emitDoc(objOf[g.program.Fset.Position(name.Pos())], firstNonNil(decl.Doc, spec.Doc, spec.Comment), docstring)
+					g.emitDoc(objOf[g.program.Fset.Position(name.Pos())], dc, docstring)
 				}
 			case *ast.TypeSpec:
 				g.emitDoc(objOf[g.program.Fset.Position(spec.Name.Pos())], firstNonNil(decl.Doc, spec.Doc, spec.Comment), docstring)
@@ -173,9 +210,16 @@ func (g *Grapher) emitDoc(obj types.Object, dc *ast.CommentGroup, docstring stri
 	}
 	g.seenDocKeys[key.String()] = struct{}{}
 
+	if msg, deprecated := deprecation(docstring); deprecated {
+		g.markDeprecated(key, msg)
+	}
+
 	var htmlBuf bytes.Buffer
 	doc.ToHTML(&htmlBuf, docstring, nil)
 
+	var textBuf bytes.Buffer
+	doc.ToText(&textBuf, docstring, "", "\t", punchCardWidth)
+
 	var filename string
 	var span [2]int
 	if dc != nil {
@@ -193,7 +237,7 @@ func (g *Grapher) emitDoc(obj types.Object, dc *ast.CommentGroup, docstring stri
 	g.addDoc(&Doc{
 		DefKey: key,
 		Format: "text/plain",
-		Data:   docstring,
+		Data:   textBuf.String(),
 		File:   filename,
 		Span:   span,
 	})
@@ -201,6 +245,75 @@ func (g *Grapher) emitDoc(obj types.Object, dc *ast.CommentGroup, docstring stri
 	return nil
 }
 
+// punchCardWidth is the line width of plain text docs (the same width
+// that godoc uses).
+const punchCardWidth = 80
+
+// deprecation returns the deprecation message in docstring and true
+// if docstring has a paragraph beginning with "Deprecated:", the
+// convention for marking deprecated identifiers. The message is the rest
+// of the paragraph, with its lines joined by spaces.
+func deprecation(docstring string) (msg string, deprecated bool) {
+	var para []string
+	for _, line := range strings.Split(docstring+"\n", "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			para = append(para, line)
+			continue
+		}
+		if len(para) > 0 && strings.HasPrefix(para[0], "Deprecated:") {
+			para[0] = strings.TrimPrefix(para[0], "Deprecated:")
+			return strings.TrimSpace(strings.Join(para, " ")), true
+		}
+		para = nil
+	}
+	return "", false
+}
+
+// recordNotes records the marked notes (such as "BUG(uid): ...") that
+// go/doc found in the package's comments on the package def.
+func (g *Grapher) recordNotes(pkgInfo *loader.PackageInfo, docPkg *doc.Package) {
+	pkgDef := g.emittedDef(&DefKey{PackageImportPath: pkgInfo.Pkg.Path(), Path: []string{}})
+	if pkgDef == nil {
+		return
+	}
+	var notes []definfo.Note
+	for marker, markerNotes := range docPkg.Notes {
+		for _, n := range markerNotes {
+			start, end := g.program.Fset.Position(n.Pos), g.program.Fset.Position(n.End)
+			notes = append(notes, definfo.Note{
+				Marker: marker,
+				UID:    n.UID,
+				Body:   n.Body,
+				File:   filepath.Base(start.Filename),
+				Span:   [2]int{start.Offset, end.Offset},
+			})
+		}
+	}
+	sort.Sort(notesByPosition(notes))
+	pkgDef.Notes = notes
+}
+
+type notesByPosition []definfo.Note
+
+func (v notesByPosition) Len() int { return len(v) }
+func (v notesByPosition) Less(i, j int) bool {
+	if v[i].File != v[j].File {
+		return v[i].File < v[j].File
+	}
+	return v[i].Span[0] < v[j].Span[0]
+}
+func (v notesByPosition) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+
+// markDeprecated sets Deprecated and DeprecationMessage on the already
+// emitted def with the given key.
+func (g *Grapher) markDeprecated(key *DefKey, msg string) {
+	if def := g.emittedDef(key); def != nil {
+		def.Deprecated = true
+		def.DeprecationMessage = msg
+	}
+}
+
 func (g *Grapher) addDoc(doc *Doc) {
 	g.Docs = append(g.Docs, doc)
 }
@@ -0,0 +1,247 @@
+package gog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
+)
+
+func TestDocs(t *testing.T) {
+	prog, cleanup := createPkgInTempDir(t, "foo", map[string]string{"foo.go": `package foo
+
+// A is documented.
+//
+//	code block
+//
+// See http://example.com.
+func A() {}
+
+// B is old.
+//
+// Deprecated: Use A.
+func B() {}
+
+// C mentions that it isn't Deprecated: at all.
+func C() {}
+
+// D is old.
+// Deprecated: not a paragraph of its own.
+func D() {}
+
+type T struct {
+	// F is old.
+	//
+	// Deprecated: Use G
+	// instead.
+	F int
+
+	G int // G is new.
+}
+
+// M is old.
+//
+// Deprecated: Use N.
+func (T) M() {}
+
+type I interface {
+	// M is old.
+	//
+	// Deprecated: Use N.
+	M()
+}
+
+const (
+	// K is old.
+	//
+	// Deprecated: Use L.
+	K = 1
+	L = 2
+)
+`})
+	defer cleanup()
+
+	g := New(prog)
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	docs := map[string]string{}
+	for _, d := range g.Docs {
+		docs[strings.Join(d.Path, ".")+" "+d.Format] = d.Data
+	}
+	if got, want := docs["A text/plain"], "A is documented.\n\n\tcode block\n\nSee http://example.com.\n"; got != want {
+		t.Errorf("A text/plain: got %q, want %q", got, want)
+	}
+	// The exact HTML varies across Go versions, so just check that
+	// code blocks and links are preserved.
+	for _, want := range []string{"<pre>code block\n</pre>", `<a href="http://example.com">`} {
+		if got := docs["A text/html"]; !strings.Contains(got, want) {
+			t.Errorf("A text/html: got %q, want it to contain %q", got, want)
+		}
+	}
+
+	wantDeprecated := map[string]string{
+		"B":   "Use A.",
+		"T.F": "Use G instead.",
+		"T.M": "Use N.",
+		"I.M": "Use N.",
+		"K":   "Use L.",
+	}
+	for _, d := range g.Defs {
+		if len(d.Path) == 0 {
+			continue
+		}
+		path := strings.Join(d.Path, ".")
+		want, wantDep := wantDeprecated[path]
+		if d.Deprecated != wantDep || d.DeprecationMessage != want {
+			t.Errorf("%s: got Deprecated %v with message %q, want %v with message %q", path, d.Deprecated, d.DeprecationMessage, wantDep, want)
+		}
+	}
+
+	if got, want := docs["T.G text/plain"], "G is new.\n"; got != want {
+		t.Errorf("T.G text/plain: got %q, want %q", got, want)
+	}
+}
+
+func TestPackageDef(t *testing.T) {
+	prog, cleanup := createPkgInTempDir(t, "foo", map[string]string{
+		"a.go":      "// Package foo does things.\npackage foo\n",
+		"doc.go":    "// More about foo.\npackage foo\n",
+		"z.go":      "package foo\n",
+		"a_test.go": "// Tests for foo.\npackage foo\n",
+	})
+	defer cleanup()
+
+	g := New(prog)
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var pkgDef *Def
+	for _, d := range g.Defs {
+		if len(d.Path) == 0 {
+			pkgDef = d
+		}
+	}
+	if pkgDef == nil {
+		t.Fatal("no package def")
+	}
+	if got, want := pkgDef.PackageImportPath, "foo"; got != want {
+		t.Errorf("got package def import path %q, want %q", got, want)
+	}
+	if got, want := filepath.Base(pkgDef.File), "doc.go"; got != want {
+		t.Errorf("got package def file %q, want %q", got, want)
+	}
+	if got, want := pkgDef.IdentSpan, [2]int{27, 30}; got != want {
+		t.Errorf("got package def ident span %v, want %v", got, want)
+	}
+	if got, want := pkgDef.DeclSpan, [2]int{19, 30}; got != want {
+		t.Errorf("got package def decl span %v, want %v", got, want)
+	}
+
+	var pkgDoc string
+	for _, d := range g.Docs {
+		if len(d.Path) == 0 && d.Format == "text/plain" {
+			pkgDoc = d.Data
+		}
+	}
+	for _, want := range []string{"does things.", "More about foo.", "Tests for foo."} {
+		if !strings.Contains(pkgDoc, want) {
+			t.Errorf("got package doc %q, want it to contain %q", pkgDoc, want)
+		}
+	}
+}
+
+func TestDocsWithSyntaxErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "foo.go")
+	src := "package foo\n\n// A is documented.\nfunc A() {}\n\nfunc B() { if x := ; {\n"
+	if err := ioutil.WriteFile(filename, []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create the package from the partial AST, as the loader does for
+	// files with syntax errors.
+	conf := Default
+	f, err := conf.ParseFile(filename, src)
+	if err == nil {
+		t.Fatal("got no parse error")
+	}
+	conf.CreateFromFiles("foo", f)
+	prog, err := conf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := New(prog)
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, d := range g.Docs {
+		if strings.Join(d.Path, "/") == "A" && d.Format == "text/plain" {
+			found = true
+			if want := "A is documented.\n"; d.Data != want {
+				t.Errorf("got doc %q, want %q", d.Data, want)
+			}
+		}
+	}
+	if !found {
+		t.Error("no doc for A")
+	}
+}
+
+func TestNotes(t *testing.T) {
+	prog, cleanup := createPkgInTempDir(t, "foo", map[string]string{
+		"a.go": `// Package foo does things.
+package foo
+
+// BUG(alice): A is slow.
+func A() {}
+`,
+		"b.go": `package foo
+
+// B does things.
+//
+// TODO(bob): Make B
+// faster.
+func B() {}
+
+// NOTE(carol): a custom marker.
+var C int
+`,
+	})
+	defer cleanup()
+
+	g := New(prog)
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var pkgDef *Def
+	for _, d := range g.Defs {
+		if len(d.Path) == 0 {
+			pkgDef = d
+		}
+	}
+	if pkgDef == nil {
+		t.Fatal("no package def")
+	}
+	want := []definfo.Note{
+		{Marker: "BUG", UID: "alice", Body: "A is slow.\n", File: "a.go", Span: [2]int{41, 66}},
+		{Marker: "TODO", UID: "bob", Body: "Make B\nfaster.\n", File: "b.go", Span: [2]int{34, 65}},
+		{Marker: "NOTE", UID: "carol", Body: "a custom marker.\n", File: "b.go", Span: [2]int{79, 111}},
+	}
+	if !reflect.DeepEqual(pkgDef.Notes, want) {
+		t.Errorf("got notes %+v, want %+v", pkgDef.Notes, want)
+	}
+}
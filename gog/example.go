@@ -0,0 +1,160 @@
+package gog
+
+import (
+	"bytes"
+	"go/ast"
+	"go/doc"
+	"go/printer"
+	"go/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+// Example is a testable example function (such as ExampleFoo or
+// ExampleT_Method) and the def that it documents.
+type Example struct {
+	// Def is the def of the example function.
+	Def *DefKey
+
+	// Subject is the def that the example documents: a func, a type,
+	// a method, or (for the package-level Example) the package.
+	Subject *DefKey
+
+	// Suffix is the lowercase suffix of the example name (such as
+	// "basic" in ExampleFoo_basic), if any.
+	Suffix string `json:",omitempty"`
+
+	// Code is the example function body.
+	Code string
+
+	// Output is the expected output from the example's "Output:"
+	// comment. HasOutput is false if there is no such comment.
+	Output    string `json:",omitempty"`
+	HasOutput bool   `json:",omitempty"`
+
+	File string
+	Span [2]int
+}
+
+// emitExamples emits an Example for each example function in the
+// package's test files. The files must have been parsed with comments
+// (so that the expected output can be read).
+func (g *Grapher) emitExamples(pkgInfo *loader.PackageInfo, files []*ast.File, objOf map[token.Position]types.Object) error {
+	var testFiles []*ast.File
+	for _, f := range files {
+		if strings.HasSuffix(g.program.Fset.Position(f.Package).Filename, "_test.go") {
+			testFiles = append(testFiles, f)
+		}
+	}
+
+	for _, ex := range doc.Examples(testFiles...) {
+		fn := exampleFuncDecl(testFiles, "Example"+ex.Name)
+		if fn == nil {
+			continue
+		}
+		obj := objOf[g.program.Fset.Position(fn.Name.Pos())]
+		if obj == nil {
+			continue
+		}
+		subject, suffix := exampleSubject(pkgInfo.Pkg, ex.Name)
+		if subject == nil {
+			continue
+		}
+
+		key, err := g.defKey(obj)
+		if err != nil {
+			return err
+		}
+		subjectKey, err := g.defKey(subject)
+		if err != nil {
+			return err
+		}
+
+		var code bytes.Buffer
+		printer.Fprint(&code, g.program.Fset, ex.Code)
+
+		g.addExample(&Example{
+			Def:       key,
+			Subject:   subjectKey,
+			Suffix:    suffix,
+			Code:      code.String(),
+			Output:    ex.Output,
+			HasOutput: ex.Output != "" || ex.EmptyOutput,
+			File:      g.program.Fset.Position(fn.Pos()).Filename,
+			Span:      makeSpan(g.program.Fset, fn),
+		})
+	}
+	return nil
+}
+
+// exampleFuncDecl returns the declaration of the example function
+// named name.
+func exampleFuncDecl(files []*ast.File, name string) *ast.FuncDecl {
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == name {
+				return fn
+			}
+		}
+	}
+	return nil
+}
+
+// exampleSubject returns the object documented by the example named
+// "Example"+name, following the go/doc naming convention: Example (the
+// package), ExampleF (func F), ExampleT (type T), and ExampleT_M
+// (method M of type T), each optionally followed by "_suffix" where
+// suffix begins with a lowercase letter.
+func exampleSubject(pkg *types.Package, name string) (obj types.Object, suffix string) {
+	if name == "" {
+		return types.NewPkgName(0, pkg, pkg.Path(), pkg), ""
+	}
+
+	lookup := func(name string) types.Object {
+		if obj := pkg.Scope().Lookup(name); obj != nil {
+			switch obj.(type) {
+			case *types.Func, *types.TypeName:
+				return obj
+			}
+			return nil
+		}
+		i := strings.Index(name, "_")
+		if i == -1 {
+			return nil
+		}
+		tn, ok := pkg.Scope().Lookup(name[:i]).(*types.TypeName)
+		if !ok {
+			return nil
+		}
+		m, _, _ := types.LookupFieldOrMethod(tn.Type(), true, pkg, name[i+1:])
+		if m, ok := m.(*types.Func); ok {
+			return m
+		}
+		return nil
+	}
+
+	// Try the whole name first, because a lowercase last part may name
+	// an unexported method (ExampleT_method) instead of a suffix.
+	if obj := lookup(name); obj != nil {
+		return obj, ""
+	}
+	if i := strings.LastIndex(name, "_"); i != -1 && i+1 < len(name) {
+		if r, _ := utf8.DecodeRuneInString(name[i+1:]); unicode.IsLower(r) {
+			if name[:i] == "" {
+				return types.NewPkgName(0, pkg, pkg.Path(), pkg), name[i+1:]
+			}
+			if obj := lookup(name[:i]); obj != nil {
+				return obj, name[i+1:]
+			}
+		}
+	}
+	return nil, ""
+}
+
+func (g *Grapher) addExample(ex *Example) {
+	g.Examples = append(g.Examples, ex)
+}
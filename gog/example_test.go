@@ -0,0 +1,66 @@
+package gog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExamples(t *testing.T) {
+	sources := map[string]string{
+		"foo.go": `package foo
+type T int
+func (T) M() {}
+func (*T) m() {}
+func F() {}
+`,
+		"foo_test.go": `package foo
+func Example() {}
+func Example_other() {}
+func ExampleF() {
+	F()
+	// Output: hello
+}
+func ExampleF_second() {}
+func ExampleT() {}
+func ExampleT_M() {}
+func ExampleT_m() {}
+func ExampleNoSuchThing() {}
+`,
+	}
+	prog, cleanup := createPkgInTempDir(t, "foo", sources)
+	defer cleanup()
+	g := New(prog)
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	type exampleSubject struct {
+		def, subject []string
+		suffix       string
+	}
+	want := []exampleSubject{
+		{[]string{"Example"}, []string{}, ""},
+		{[]string{"ExampleF"}, []string{"F"}, ""},
+		{[]string{"ExampleF_second"}, []string{"F"}, "second"},
+		{[]string{"ExampleT"}, []string{"T"}, ""},
+		{[]string{"ExampleT_M"}, []string{"T", "M"}, ""},
+		{[]string{"ExampleT_m"}, []string{"T", "m"}, ""},
+		{[]string{"Example_other"}, []string{}, "other"},
+	}
+	var got []exampleSubject
+	for _, ex := range g.Examples {
+		got = append(got, exampleSubject{ex.Def.Path, ex.Subject.Path, ex.Suffix})
+
+		if ex.Def.Path[0] == "ExampleF" {
+			if !ex.HasOutput || ex.Output != "hello\n" {
+				t.Errorf("ExampleF: got output %q (HasOutput %v), want %q", ex.Output, ex.HasOutput, "hello\n")
+			}
+			if ex.Code == "" {
+				t.Errorf("ExampleF: no code")
+			}
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got examples %+v, want %+v", got, want)
+	}
+}
@@ -1,10 +1,14 @@
 package gog
 
 import (
+	"errors"
 	"go/ast"
+	"go/token"
 	"log"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"golang.org/x/tools/go/exact"
@@ -17,11 +21,46 @@ type Output struct {
 	Defs []*Def
 	Refs []*Ref
 	Docs []*Doc
+
+	Implementations []*Implementation `json:",omitempty"`
+	Examples        []*Example        `json:",omitempty"`
+	Calls           []*Call           `json:",omitempty"`
+	ControlFlows    []*ControlFlow    `json:",omitempty"`
 }
 
 type Grapher struct {
+	// SkipDocs is whether to skip emitting docs and recording comment
+	// directives (such as //go:embed), which requires reading and
+	// parsing the files again.
 	SkipDocs bool
 
+	// SkipImplementations is whether to skip computing which named
+	// types implement which named interfaces.
+	SkipImplementations bool
+
+	// DefKeyScheme is the scheme for def paths: DefKeySchemeV1 (the
+	// default, if empty) or DefKeySchemeV2.
+	DefKeyScheme string
+
+	// Callgraph is whether to emit Calls from functions and methods to
+	// the functions and methods that they call.
+	Callgraph bool
+
+	// ControlFlow is whether to emit ControlFlows for fallthrough
+	// statements and select case communications.
+	ControlFlow bool
+
+	// PrintfAnalysis is whether to mark the refs to the functions
+	// called by calls of printf-style functions, and emit refs from
+	// the verbs in their format strings to the types of the arguments
+	// (see emitPrintfRefs).
+	PrintfAnalysis bool
+
+	// Cancel, if non-nil, aborts graphing when it is closed. Graph then
+	// returns ErrCanceled, and Output contains the partial output
+	// emitted so far.
+	Cancel <-chan struct{}
+
 	program *loader.Program
 
 	defCacheLock sync.Mutex
@@ -33,10 +72,18 @@ type Grapher struct {
 	scopeNodes map[*types.Scope]ast.Node
 
 	paths      map[types.Object][]string
+	pathsV2    map[types.Object][]string
 	scopePaths map[*types.Scope][]string
 	exported   map[types.Object]bool
 	pkgscope   map[types.Object]bool
 
+	// anonMembers holds the members of the anonymous struct and
+	// interface types of package-level declarations (see
+	// assignAnonMemberPaths), keyed by their positions. The vars in a
+	// multiple-name var spec (such as var a, b struct{ X int }) each
+	// have their own type, so they have a member at each position.
+	anonMembers map[token.Pos][]types.Object
+
 	Output
 
 	// skipResolve is the set of *ast.Idents that the grapher encountered but
@@ -46,6 +93,20 @@ type Grapher struct {
 
 	seenDocObjs map[types.Object]struct{}
 	seenDocKeys map[string]struct{}
+
+	seenImplementations map[string]*Implementation
+
+	concreteTypeNames []*types.TypeName
+
+	// defs indexes Defs by their DefKeys' strings (see emittedDef).
+	defs map[string]*Def
+
+	// refs indexes Refs, to deduplicate them (see addRef).
+	refs map[refKey]*Ref
+
+	// parseCache holds the ASTs of files parsed by parseFiles, keyed
+	// by file name.
+	parseCache map[string]cachedFile
 }
 
 func New(prog *loader.Program) *Grapher {
@@ -63,7 +124,13 @@ func New(prog *loader.Program) *Grapher {
 		exported:   make(map[types.Object]bool),
 		pkgscope:   make(map[types.Object]bool),
 
+		anonMembers: make(map[token.Pos][]types.Object),
+
 		skipResolve: make(map[*ast.Ident]struct{}),
+
+		defs:       make(map[string]*Def),
+		refs:       make(map[refKey]*Ref),
+		parseCache: make(map[string]cachedFile),
 	}
 
 	for _, pkgInfo := range sortedPkgs(prog.AllPackages) {
@@ -97,6 +164,9 @@ func (g *Grapher) addDef(def *Def) {
 		return
 	}
 	g.Defs = append(g.Defs, def)
+	if k := def.DefKey.String(); g.defs[k] == nil {
+		g.defs[k] = def
+	}
 }
 
 func (g *Grapher) addRef(ref *Ref) {
@@ -105,9 +175,34 @@ func (g *Grapher) addRef(ref *Ref) {
 		// skip cgo-generated file
 		return
 	}
+	if ref.Def.PackageImportPath == "C" {
+		// skip refs to the cgo pseudo-package, which has no defs
+		return
+	}
+
+	// Emit at most one ref from each source range to each def, even if
+	// an ident is encountered more than once (such as in Defs and in
+	// Uses). The ref is a def ref if any of them is.
+	key := refKey{file: ref.File, span: ref.Span, def: ref.Def.String()}
+	if existing, present := g.refs[key]; present {
+		existing.IsDef = existing.IsDef || ref.IsDef
+		if existing.MethodUse == "" {
+			existing.MethodUse = ref.MethodUse
+		}
+		existing.PrintfCall = existing.PrintfCall || ref.PrintfCall
+		return
+	}
+	g.refs[key] = ref
 	g.Refs = append(g.Refs, ref)
 }
 
+// refKey identifies the refs from a source range to a def.
+type refKey struct {
+	file string
+	span [2]int
+	def  string // DefKey.String()
+}
+
 func (g *Grapher) GraphImported() error {
 	for _, pkgInfo := range g.program.Imported {
 		err := g.Graph(pkgInfo)
@@ -128,6 +223,19 @@ func (g *Grapher) GraphAll() error {
 	return nil
 }
 
+// ErrCanceled is returned by Graph when graphing was aborted because
+// the Grapher's Cancel channel was closed.
+var ErrCanceled = errors.New("graphing canceled")
+
+func (g *Grapher) canceled() bool {
+	select {
+	case <-g.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
 func (g *Grapher) Graph(pkgInfo *loader.PackageInfo) error {
 	if len(pkgInfo.Files) == 0 {
 		log.Printf("warning: attempted to graph package %+v with no files", pkgInfo)
@@ -135,21 +243,10 @@ func (g *Grapher) Graph(pkgInfo *loader.PackageInfo) error {
 	}
 
 	seen := make(map[ast.Node]struct{})
-	skipResolveObjs := make(map[types.Object]struct{})
 
-	for node, obj := range pkgInfo.Implicits {
-		if importSpec, ok := node.(*ast.ImportSpec); ok {
-			ref, err := g.NewRef(importSpec, obj)
-			if err != nil {
-				return err
-			}
-			g.addRef(ref)
-			seen[importSpec] = struct{}{}
-		} else if x, ok := node.(*ast.Ident); ok {
+	for node := range pkgInfo.Implicits {
+		if x, ok := node.(*ast.Ident); ok {
 			g.skipResolve[x] = struct{}{}
-		} else if _, ok := node.(*ast.CaseClause); ok {
-			// type-specific *Var for each type switch case clause
-			skipResolveObjs[obj] = struct{}{}
 		}
 	}
 
@@ -160,8 +257,11 @@ func (g *Grapher) Graph(pkgInfo *loader.PackageInfo) error {
 	g.addDef(pkgDef)
 
 	for ident, obj := range pkgInfo.Defs {
-		_, isLabel := obj.(*types.Label)
-		if obj == nil || ident.Name == "_" || isLabel {
+		if g.canceled() {
+			return ErrCanceled
+		}
+
+		if obj == nil || ident.Name == "_" {
 			g.skipResolve[ident] = struct{}{}
 			continue
 		}
@@ -188,36 +288,65 @@ func (g *Grapher) Graph(pkgInfo *loader.PackageInfo) error {
 		if err != nil {
 			return err
 		}
-		ref.IsDef = true
+		// An import alias refers to the imported package's def.
+		ref.IsDef = !isPkg
 		g.addRef(ref)
+
+		// go/types records only one of the members that the ident
+		// declares in a multiple-name var spec (see anonMembers), but
+		// refs to the others (and to all of them from other packages)
+		// need defs too.
+		for _, member := range g.anonMembers[ident.Pos()] {
+			if member == obj {
+				continue
+			}
+			def, err := g.NewDef(member, ident)
+			if err != nil {
+				return err
+			}
+			g.addDef(def)
+			ref, err := g.NewRef(ident, member)
+			if err != nil {
+				return err
+			}
+			ref.IsDef = true
+			g.addRef(ref)
+		}
+	}
+
+	if err := g.emitImportRefs(pkgInfo); err != nil {
+		return err
 	}
 
+	g.emitFailedImportRefs(pkgInfo)
+
+	if err := g.emitUnresolvedRecvRefs(pkgInfo); err != nil {
+		return err
+	}
+
+	if err := g.emitTypeSwitchDefs(pkgInfo); err != nil {
+		return err
+	}
+
+	methodUses := methodUses(pkgInfo)
 	for ident, obj := range pkgInfo.Uses {
-		if _, isLabel := obj.(*types.Label); isLabel {
-			g.skipResolve[ident] = struct{}{}
-			continue
+		if g.canceled() {
+			return ErrCanceled
 		}
 
 		if obj == nil || ident == nil || ident.Name == "_" {
 			continue
 		}
 
-		if _, skip := skipResolveObjs[obj]; skip {
-			g.skipResolve[ident] = struct{}{}
-		}
-
 		if _, seen := seen[ident]; seen {
 			continue
 		}
 
-		if _, isLabel := obj.(*types.Label); isLabel {
-			continue
-		}
-
 		ref, err := g.NewRef(ident, obj)
 		if err != nil {
 			return err
 		}
+		ref.MethodUse = methodUses[ident]
 		g.addRef(ref)
 	}
 
@@ -232,13 +361,51 @@ func (g *Grapher) Graph(pkgInfo *loader.PackageInfo) error {
 		g.addRef(ref)
 	}
 
+	if g.canceled() {
+		return ErrCanceled
+	}
+
 	if !g.SkipDocs {
+		if err := g.emitDirectives(pkgInfo); err != nil {
+			return err
+		}
 		err = g.emitDocs(pkgInfo)
 		if err != nil {
 			return err
 		}
 	}
 
+	if g.canceled() {
+		return ErrCanceled
+	}
+
+	if err := g.emitAssertedImplementations(pkgInfo); err != nil {
+		return err
+	}
+	if !g.SkipImplementations {
+		if err := g.emitImplementations(pkgInfo); err != nil {
+			return err
+		}
+	}
+
+	if g.Callgraph {
+		if err := g.emitCalls(pkgInfo); err != nil {
+			return err
+		}
+	}
+
+	if g.ControlFlow {
+		if err := g.emitControlFlows(pkgInfo); err != nil {
+			return err
+		}
+	}
+
+	if g.PrintfAnalysis {
+		if err := g.emitPrintfRefs(pkgInfo); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -283,6 +450,218 @@ func (g *Grapher) lookupDefInfo(obj types.Object) (*DefKey, *defInfo) {
 	return g.defKeyCache[obj], g.defInfoCache[obj]
 }
 
+// emitUnresolvedRecvRefs emits a ref from the receiver base type name
+// of each method declaration in pkgInfo that the type checker didn't
+// resolve to the package-level type with that name. The type checker
+// doesn't resolve generic receivers (such as *List[T]), which it
+// doesn't support.
+func (g *Grapher) emitUnresolvedRecvRefs(pkgInfo *loader.PackageInfo) error {
+	for _, f := range pkgInfo.Files {
+		for _, decl := range f.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv == nil || len(fd.Recv.List) == 0 {
+				continue
+			}
+			ident := methodRecvTypeIdent(fd.Recv.List[0].Type)
+			if ident == nil || pkgInfo.Uses[ident] != nil {
+				continue
+			}
+			tn, ok := pkgInfo.Pkg.Scope().Lookup(ident.Name).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			ref, err := g.NewRef(ident, tn)
+			if err != nil {
+				return err
+			}
+			g.addRef(ref)
+		}
+	}
+	return nil
+}
+
+// emitImportRefs emits a ref from the path of each import spec in
+// pkgInfo to the def of the imported package (including for dot and
+// blank imports, whose names don't refer to the package).
+func (g *Grapher) emitImportRefs(pkgInfo *loader.PackageInfo) error {
+	for _, f := range pkgInfo.Files {
+		for _, spec := range f.Imports {
+			var obj types.Object
+			if spec.Name != nil {
+				obj = pkgInfo.Defs[spec.Name]
+			} else {
+				obj = pkgInfo.Implicits[spec]
+			}
+			pkgName, ok := obj.(*types.PkgName)
+			if !ok {
+				// the import failed
+				continue
+			}
+			ref, err := g.NewRef(spec.Path, pkgName)
+			if err != nil {
+				return err
+			}
+			g.addRef(ref)
+		}
+	}
+	return nil
+}
+
+// emitFailedImportRefs emits refs for the imports in pkgInfo that
+// failed (usually because the imported package's source or export data
+// isn't available). Type-checking can't resolve the uses of such a
+// package, but a qualified identifier pkg.Name must refer to the
+// package-level def Name in the imported package, whose def key (the
+// import path and name) is known without loading the package. So this
+// emits refs from each import spec's path and from each qualified
+// identifier's package name to the imported package's def, and from
+// the qualified identifier's name to the def Name. The package name of
+// an import without an explicit name is guessed from its import path
+// (see guessPackageName).
+func (g *Grapher) emitFailedImportRefs(pkgInfo *loader.PackageInfo) {
+	for _, f := range pkgInfo.Files {
+		failed := map[string]string{} // package name -> import path
+		for _, spec := range f.Imports {
+			var obj types.Object
+			if spec.Name != nil {
+				obj = pkgInfo.Defs[spec.Name]
+			} else {
+				obj = pkgInfo.Implicits[spec]
+			}
+			if obj != nil {
+				continue
+			}
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil || path == "C" {
+				continue
+			}
+			g.addRef(g.newFailedImportRef(spec.Path, path, nil))
+
+			name := guessPackageName(path)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			if name != "." && name != "_" {
+				failed[name] = path
+			}
+		}
+		if len(failed) == 0 {
+			continue
+		}
+
+		ast.Inspect(f, func(node ast.Node) bool {
+			sel, ok := node.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			x, ok := sel.X.(*ast.Ident)
+			if !ok || pkgInfo.Uses[x] != nil || pkgInfo.Defs[x] != nil {
+				return true
+			}
+			path, present := failed[x.Name]
+			if !present {
+				return true
+			}
+			g.addRef(g.newFailedImportRef(x, path, nil))
+			g.addRef(g.newFailedImportRef(sel.Sel, path, []string{sel.Sel.Name}))
+			return false
+		})
+	}
+}
+
+// newFailedImportRef returns a ref from node to the def with the given
+// path in the package that importPath failed to import (or to the
+// package's def, if path is nil).
+func (g *Grapher) newFailedImportRef(node ast.Node, importPath string, path []string) *Ref {
+	if path == nil {
+		path = []string{}
+	}
+	pos := g.program.Fset.Position(node.Pos())
+	return &Ref{
+		File: pos.Filename,
+		Span: makeSpan(g.program.Fset, node),
+		Def:  &DefKey{PackageImportPath: importPath, Path: path},
+		Test: isTestFile(pos.Filename),
+	}
+}
+
+// guessPackageName returns the likely name of the package with the
+// given import path, according to common conventions: the last element
+// of the path, without a major version suffix ("/v2" or ".v2") or a
+// "go-" prefix or "-go" suffix.
+func guessPackageName(importPath string) string {
+	elems := strings.Split(importPath, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	if i := strings.LastIndex(name, "."); i != -1 && isMajorVersion(name[i+1:]) {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	return strings.TrimSuffix(name, "-go")
+}
+
+// isMajorVersion reports whether s is a major version, such as "v2".
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// emitTypeSwitchDefs emits a def for the variable that each clause of
+// a type switch with a short variable declaration (switch x :=
+// v.(type)) implicitly declares, with the type that the clause narrows
+// it to. The defs are all at the variable's identifier in the switch
+// header, which refers to each of them.
+func (g *Grapher) emitTypeSwitchDefs(pkgInfo *loader.PackageInfo) error {
+	var err error
+	for _, f := range pkgInfo.Files {
+		ast.Inspect(f, func(node ast.Node) bool {
+			if err != nil {
+				return false
+			}
+			ts, ok := node.(*ast.TypeSwitchStmt)
+			if !ok {
+				return true
+			}
+			assign, ok := ts.Assign.(*ast.AssignStmt)
+			if !ok || len(assign.Lhs) != 1 {
+				return true
+			}
+			ident, ok := assign.Lhs[0].(*ast.Ident)
+			if !ok || ident.Name == "_" {
+				return true
+			}
+			for _, clause := range ts.Body.List {
+				obj := pkgInfo.Implicits[clause]
+				if obj == nil {
+					continue
+				}
+				var def *Def
+				if def, err = g.NewDef(obj, ident); err != nil {
+					return false
+				}
+				g.addDef(def)
+				var ref *Ref
+				if ref, err = g.NewRef(ident, obj); err != nil {
+					return false
+				}
+				ref.IsDef = true
+				g.addRef(ref)
+			}
+			return true
+		})
+	}
+	return err
+}
+
 func (g *Grapher) makeDefInfo(obj types.Object) (*DefKey, *defInfo, error) {
 	switch obj := obj.(type) {
 	case *types.Builtin:
@@ -315,7 +694,7 @@ func (g *Grapher) makeDefInfo(obj types.Object) (*DefKey, *defInfo, error) {
 		return &DefKey{"builtin", []string{obj.Name()}}, &defInfo{pkgscope: false, exported: true}, nil
 	}
 
-	path := g.path(obj)
+	path := g.defPath(obj)
 
 	// Handle the case where a dir has 2 main packages that are not
 	// intended to be compiled together and have overlapping def
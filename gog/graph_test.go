@@ -0,0 +1,19 @@
+package gog
+
+import "testing"
+
+func TestGraphCancel(t *testing.T) {
+	prog := createPkg(t, "foo", []string{`package foo; type T int; var v T`}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	cancel := make(chan struct{})
+	close(cancel)
+	g.Cancel = cancel
+	if err := g.Graph(prog.Created[0]); err != ErrCanceled {
+		t.Errorf("got error %v, want ErrCanceled", err)
+	}
+	if len(g.Defs) != 1 {
+		t.Errorf("got %d defs, want only the package def (emitted before canceling)", len(g.Defs))
+	}
+}
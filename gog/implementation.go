@@ -0,0 +1,164 @@
+package gog
+
+import (
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+// Implementation records that a named concrete type implements a named
+// interface type.
+type Implementation struct {
+	// Type is the def of the concrete (non-interface) named type.
+	Type *DefKey
+
+	// Interface is the def of the interface type that Type implements.
+	Interface *DefKey
+
+	// Pointer is true if only *Type (not Type itself) implements
+	// Interface, because some of Interface's methods are implemented
+	// with pointer receivers.
+	Pointer bool `json:",omitempty"`
+
+	// Asserted is true if the program asserts that Type (or *Type)
+	// implements Interface with a package-level blank var, such as var
+	// _ io.Reader = (*T)(nil).
+	Asserted bool `json:",omitempty"`
+}
+
+// emitImplementations emits an Implementation for each pair of named
+// concrete type and named interface type in the program for which at
+// least one of the two is defined in pkgInfo.
+func (g *Grapher) emitImplementations(pkgInfo *loader.PackageInfo) error {
+	var types_, ifaces []*types.TypeName
+	for _, pi := range sortedPkgs(g.program.AllPackages) {
+		scope := pi.Pkg.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			if _, isNamed := tn.Type().(*types.Named); !isNamed {
+				continue
+			}
+			if iface, ok := tn.Type().Underlying().(*types.Interface); ok {
+				// every type implements the empty interface
+				if iface.NumMethods() > 0 {
+					ifaces = append(ifaces, tn)
+				}
+			} else {
+				types_ = append(types_, tn)
+			}
+		}
+	}
+
+	for _, iface := range ifaces {
+		if g.canceled() {
+			return ErrCanceled
+		}
+		for _, typ := range types_ {
+			if iface.Pkg() != pkgInfo.Pkg && typ.Pkg() != pkgInfo.Pkg {
+				continue
+			}
+
+			it := iface.Type().Underlying().(*types.Interface)
+			var ptr bool
+			if !types.Implements(typ.Type(), it) {
+				if !types.Implements(types.NewPointer(typ.Type()), it) {
+					continue
+				}
+				ptr = true
+			}
+
+			typeKey, err := g.defKey(typ)
+			if err != nil {
+				return err
+			}
+			ifaceKey, err := g.defKey(iface)
+			if err != nil {
+				return err
+			}
+			g.addImplementation(&Implementation{Type: typeKey, Interface: ifaceKey, Pointer: ptr})
+		}
+	}
+	return nil
+}
+
+// emitAssertedImplementations emits an Implementation for each
+// package-level blank var in pkgInfo that asserts that a named concrete
+// type implements a named interface type, such as:
+//
+//	var _ io.Reader = (*T)(nil)
+//	var _ I = T{}
+//
+// These are emitted even if SkipImplementations is set, because they
+// don't require checking every pair of types.
+func (g *Grapher) emitAssertedImplementations(pkgInfo *loader.PackageInfo) error {
+	for _, f := range pkgInfo.Files {
+		for _, decl := range f.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.ValueSpec)
+				if spec.Type == nil || len(spec.Values) != len(spec.Names) {
+					continue
+				}
+				iface, ok := pkgInfo.TypeOf(spec.Type).(*types.Named)
+				if !ok {
+					continue
+				}
+				it, ok := iface.Underlying().(*types.Interface)
+				if !ok || it.NumMethods() == 0 {
+					continue
+				}
+				for i, name := range spec.Names {
+					if name.Name != "_" {
+						continue
+					}
+					typ, ok := derefType(pkgInfo.TypeOf(spec.Values[i])).(*types.Named)
+					if !ok {
+						continue
+					}
+					if _, isIface := typ.Underlying().(*types.Interface); isIface {
+						continue
+					}
+					var ptr bool
+					if !types.Implements(typ, it) {
+						if !types.Implements(types.NewPointer(typ), it) {
+							continue // a type-checking error
+						}
+						ptr = true
+					}
+
+					typeKey, err := g.defKey(typ.Obj())
+					if err != nil {
+						return err
+					}
+					ifaceKey, err := g.defKey(iface.Obj())
+					if err != nil {
+						return err
+					}
+					g.addImplementation(&Implementation{Type: typeKey, Interface: ifaceKey, Pointer: ptr, Asserted: true})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (g *Grapher) addImplementation(impl *Implementation) {
+	if g.seenImplementations == nil {
+		g.seenImplementations = make(map[string]*Implementation)
+	}
+	k := impl.Type.String() + " " + impl.Interface.String()
+	if seen, present := g.seenImplementations[k]; present {
+		seen.Asserted = seen.Asserted || impl.Asserted
+		return
+	}
+	g.seenImplementations[k] = impl
+	g.Implementations = append(g.Implementations, impl)
+}
@@ -0,0 +1,117 @@
+package gog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImplementations(t *testing.T) {
+	cases := map[string]struct {
+		defs     string
+		want     []Implementation
+		dontWant []Implementation
+	}{
+		"value receiver": {
+			defs: `type I interface { M() }; type T int; func (T) M() {}`,
+			want: []Implementation{{Type: &DefKey{"foo", []string{"T"}}, Interface: &DefKey{"foo", []string{"I"}}}},
+		},
+		"pointer receiver": {
+			defs: `type I interface { M() }; type T int; func (*T) M() {}`,
+			want: []Implementation{{Type: &DefKey{"foo", []string{"T"}}, Interface: &DefKey{"foo", []string{"I"}}, Pointer: true}},
+		},
+		"missing method": {
+			defs:     `type I interface { M(); N() }; type T int; func (T) M() {}`,
+			dontWant: []Implementation{{Type: &DefKey{"foo", []string{"T"}}, Interface: &DefKey{"foo", []string{"I"}}}},
+		},
+		"promoted method": {
+			defs: `type I interface { M() }; type T int; func (T) M() {}; type U struct { T }`,
+			want: []Implementation{{Type: &DefKey{"foo", []string{"U"}}, Interface: &DefKey{"foo", []string{"I"}}}},
+		},
+		"asserted with pointer": {
+			defs: `type I interface { M() }; type T int; func (*T) M() {}; var _ I = (*T)(nil)`,
+			want: []Implementation{{Type: &DefKey{"foo", []string{"T"}}, Interface: &DefKey{"foo", []string{"I"}}, Pointer: true, Asserted: true}},
+		},
+		"asserted with pointer to value receiver": {
+			defs: `type I interface { M() }; type T int; func (T) M() {}; var _ I = (*T)(nil)`,
+			want: []Implementation{{Type: &DefKey{"foo", []string{"T"}}, Interface: &DefKey{"foo", []string{"I"}}, Asserted: true}},
+		},
+		"asserted with value": {
+			defs: `type I interface { M() }; type T struct{}; func (T) M() {}; var x, _ I = T{}, T{}`,
+			want: []Implementation{{Type: &DefKey{"foo", []string{"T"}}, Interface: &DefKey{"foo", []string{"I"}}, Asserted: true}},
+		},
+		"interfaces are not implementations": {
+			defs:     `type I interface { M() }; type J interface { M() }`,
+			dontWant: []Implementation{{Type: &DefKey{"foo", []string{"J"}}, Interface: &DefKey{"foo", []string{"I"}}}},
+		},
+	}
+
+	for label, c := range cases {
+		src := `package foo; ` + c.defs
+		prog := createPkg(t, "foo", []string{src}, nil)
+
+		g := New(prog)
+		g.SkipDocs = true
+		err := g.Graph(prog.Created[0])
+		if err != nil {
+			t.Fatal(label, err)
+		}
+
+		found := func(want Implementation) bool {
+			for _, impl := range g.Implementations {
+				if reflect.DeepEqual(*impl, want) {
+					return true
+				}
+			}
+			return false
+		}
+		for _, want := range c.want {
+			if !found(want) {
+				t.Errorf("%s: implementation not found: %v implements %v (pointer: %v)", label, want.Type, want.Interface, want.Pointer)
+			}
+		}
+		for _, dontWant := range c.dontWant {
+			if found(dontWant) {
+				t.Errorf("%s: unwanted implementation: %v implements %v", label, dontWant.Type, dontWant.Interface)
+			}
+		}
+	}
+}
+
+func TestAssertedImplementations(t *testing.T) {
+	src := `package foo
+
+type I interface { M() }
+type J interface { M() }
+type T int
+func (*T) M() {}
+var _ I = (*T)(nil)
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+	g := New(prog)
+	g.SkipDocs = true
+	g.SkipImplementations = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the asserted implementation is emitted.
+	want := []*Implementation{{Type: &DefKey{"foo", []string{"T"}}, Interface: &DefKey{"foo", []string{"I"}}, Pointer: true, Asserted: true}}
+	if !reflect.DeepEqual(g.Implementations, want) {
+		t.Errorf("got implementations %+v, want %+v", g.Implementations, want)
+	}
+
+	// The assertion refers to both types.
+	line := strings.Index(src, "var _")
+	refs := map[string]bool{}
+	for _, ref := range g.Refs {
+		if ref.Span[0] > line {
+			refs[ref.Def.String()] = true
+		}
+	}
+	for _, def := range []string{"foo#I", "foo#T"} {
+		if !refs[def] {
+			t.Errorf("no ref to %s in the assertion (got refs %v)", def, refs)
+		}
+	}
+}
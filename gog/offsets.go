@@ -0,0 +1,147 @@
+package gog
+
+import (
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	// OffsetsUTF8 is the offset encoding in which the spans in the
+	// Output are byte offsets in their (UTF-8) files, as token.Pos
+	// offsets are. It is the Grapher's encoding.
+	OffsetsUTF8 = "utf8"
+
+	// OffsetsUTF16 is the offset encoding in which the spans in the
+	// Output are offsets in UTF-16 code units, as in the Language
+	// Server Protocol: a character outside of the Basic Multilingual
+	// Plane (such as most emoji) counts as 2, and any other character
+	// as 1. See ConvertOffsetsToUTF16.
+	OffsetsUTF16 = "utf16"
+)
+
+// ConvertOffsetsToUTF16 converts the spans (and the snippet starts) of
+// the Output's defs, refs, docs, examples, calls, control flows, and
+// package notes from byte offsets to UTF-16 code unit offsets (see
+// OffsetsUTF16), reading each file once. It must be called after
+// everything else that uses the spans, such as AddRefSnippets.
+func (g *Grapher) ConvertOffsetsToUTF16() error {
+	tables := map[string]*utf16Table{}
+	conv := func(file string, offsets ...*int) error {
+		t, present := tables[file]
+		if !present {
+			src, err := readFile(file)
+			if err != nil {
+				return err
+			}
+			t = newUTF16Table(src)
+			tables[file] = t
+		}
+		for _, off := range offsets {
+			*off = t.offset(*off)
+		}
+		return nil
+	}
+
+	for _, def := range g.Defs {
+		if def.File == "" {
+			continue
+		}
+		if err := conv(def.File, &def.IdentSpan[0], &def.IdentSpan[1], &def.DeclSpan[0], &def.DeclSpan[1]); err != nil {
+			return err
+		}
+		for i := range def.Notes {
+			n := &def.Notes[i]
+			if err := conv(filepath.Join(filepath.Dir(def.File), n.File), &n.Span[0], &n.Span[1]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ref := range g.Refs {
+		if err := conv(ref.File, &ref.Span[0], &ref.Span[1]); err != nil {
+			return err
+		}
+		if ref.Snippet != nil {
+			if err := conv(ref.File, &ref.Snippet.Start); err != nil {
+				return err
+			}
+		}
+	}
+	for _, doc := range g.Docs {
+		if doc.File == "" {
+			continue
+		}
+		if err := conv(doc.File, &doc.Span[0], &doc.Span[1]); err != nil {
+			return err
+		}
+	}
+	for _, ex := range g.Examples {
+		if err := conv(ex.File, &ex.Span[0], &ex.Span[1]); err != nil {
+			return err
+		}
+	}
+	for _, call := range g.Calls {
+		if err := conv(call.File, &call.Span[0], &call.Span[1]); err != nil {
+			return err
+		}
+	}
+	for _, flow := range g.ControlFlows {
+		if err := conv(flow.File, &flow.Span[0], &flow.Span[1]); err != nil {
+			return err
+		}
+		if flow.TargetSpan != nil {
+			if err := conv(flow.File, &flow.TargetSpan[0], &flow.TargetSpan[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The refs are indexed by their spans, which have changed.
+	g.refs = map[refKey]*Ref{}
+	for _, ref := range g.Refs {
+		g.refs[refKey{file: ref.File, span: ref.Span, def: ref.Def.String()}] = ref
+	}
+	return nil
+}
+
+// A utf16Table converts byte offsets in a UTF-8 file to UTF-16 code
+// unit offsets. It records, for each multibyte character, the byte
+// offset just after it and the number of bytes by which the UTF-16
+// offsets of the characters after it (up to the next multibyte
+// character) are less than their byte offsets.
+type utf16Table struct {
+	ends   []int
+	deltas []int
+}
+
+func newUTF16Table(src []byte) *utf16Table {
+	t := &utf16Table{}
+	var delta int
+	for i := 0; i < len(src); {
+		if src[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(src[i:])
+		units := 1
+		if r >= 0x10000 {
+			units = 2
+		}
+		i += size
+		delta += size - units
+		t.ends = append(t.ends, i)
+		t.deltas = append(t.deltas, delta)
+	}
+	return t
+}
+
+// offset returns the UTF-16 code unit offset of the byte offset off,
+// which must be at the beginning of a character (or the end of the
+// file).
+func (t *utf16Table) offset(off int) int {
+	i := sort.SearchInts(t.ends, off+1) // the first multibyte character that ends after off
+	if i == 0 {
+		return off
+	}
+	return off - t.deltas[i-1]
+}
@@ -0,0 +1,80 @@
+package gog
+
+import (
+	"testing"
+	"unicode/utf16"
+)
+
+func TestConvertOffsetsToUTF16(t *testing.T) {
+	src := `package foo
+
+// Größe is the size (in 😀 units).
+var Größe = "日本語😀"
+
+var π, _ = 3.14, Größe
+
+func _() (x int) { _ = π; _ = "😀😀"; return 0 }
+`
+	prog, cleanup := createPkgInTempDir(t, "foo", map[string]string{"foo.go": src})
+	defer cleanup()
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+	g.AddRefSnippets()
+
+	// The spans are byte offsets.
+	type span struct {
+		name  string
+		start *int
+		end   *int
+		want  [2]int // in UTF-16 code units
+	}
+	utf16Offset := func(off int) int { return len(utf16.Encode([]rune(src[:off]))) }
+	var spans []span
+	add := func(name string, s *[2]int) {
+		if got := src[s[0]:s[1]]; got != name {
+			t.Errorf("got byte span %v of %q, want %q", *s, got, name)
+		}
+		spans = append(spans, span{name, &s[0], &s[1], [2]int{utf16Offset(s[0]), utf16Offset(s[1])}})
+	}
+	for _, d := range g.Defs {
+		if d.Name != "" && d.File != "" {
+			add(d.Name, &d.IdentSpan)
+		}
+	}
+	var snippetStarts []int
+	for _, r := range g.Refs {
+		add(src[r.Span[0]:r.Span[1]], &r.Span)
+		snippetStarts = append(snippetStarts, utf16Offset(r.Snippet.Start))
+	}
+	if len(spans) == 0 {
+		t.Fatal("no spans")
+	}
+
+	if err := g.ConvertOffsetsToUTF16(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range spans {
+		if got := [2]int{*s.start, *s.end}; got != s.want {
+			t.Errorf("%s: got UTF-16 span %v, want %v", s.name, got, s.want)
+		}
+	}
+	for i, r := range g.Refs {
+		if r.Snippet.Start != snippetStarts[i] {
+			t.Errorf("ref at %v: got UTF-16 snippet start %d, want %d", r.Span, r.Snippet.Start, snippetStarts[i])
+		}
+	}
+}
+
+func TestUTF16Table(t *testing.T) {
+	src := "aé😀b"
+	tests := map[int]int{0: 0, 1: 1, 3: 2, 7: 4, 8: 5}
+	tbl := newUTF16Table([]byte(src))
+	for off, want := range tests {
+		if got := tbl.offset(off); got != want {
+			t.Errorf("offset %d: got %d, want %d", off, got, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package gog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
+)
+
+func TestParamDefs(t *testing.T) {
+	src := `package foo; type T int; func (t T) M(a, _ int, b string) (r int, err error) { r = a; return }`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	type paramDef struct {
+		path       string
+		ident      string
+		typeString string
+	}
+	want := map[string]paramDef{
+		"T/M/t":   {"T/M/t", "t", "foo.T"},
+		"T/M/a":   {"T/M/a", "a", "int"},
+		"T/M/b":   {"T/M/b", "b", "string"},
+		"T/M/r":   {"T/M/r", "r", "int"},
+		"T/M/err": {"T/M/err", "err", "error"},
+	}
+	got := map[string]paramDef{}
+	for _, d := range g.Defs {
+		if d.Kind != definfo.Var {
+			continue
+		}
+		path := strings.Join(d.Path, "/")
+		got[path] = paramDef{path, src[d.IdentSpan[0]:d.IdentSpan[1]], d.TypeString}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got param defs %+v, want %+v", got, want)
+	}
+
+	// uses of params and results in the body refer to their defs
+	for _, use := range []struct {
+		ident string
+		path  []string
+	}{{"r =", []string{"T", "M", "r"}}, {"= a", []string{"T", "M", "a"}}} {
+		off := strings.Index(src, use.ident)
+		if use.ident[0] == '=' {
+			off += 2
+		}
+		var found bool
+		for _, r := range g.Refs {
+			if r.Span[0] == off && !r.IsDef && reflect.DeepEqual(r.Def.Path, use.path) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("ref at %d to %v not found", off, use.path)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package gog
+
+import (
+	"crypto/sha256"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"io/ioutil"
+)
+
+// cachedFile is a file in the Grapher's parse cache.
+type cachedFile struct {
+	hash [sha256.Size]byte // of the file's contents
+	file *ast.File
+}
+
+// parseFiles parses the named files with comments (which the loader
+// doesn't keep), to emit docs, examples, and directives. The ASTs are
+// cached, so that each file is only parsed once, and are keyed by the
+// files' contents (not modification times), so that the cache stays
+// correct when the files are overlaid. If take is true, the ASTs are
+// removed from the cache, so that the caller may modify them.
+func (g *Grapher) parseFiles(filenames []string, take bool) (map[string]*ast.File, error) {
+	files := make(map[string]*ast.File, len(filenames))
+	for _, filename := range filenames {
+		src, err := readFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(src)
+
+		cf, cached := g.parseCache[filename]
+		if !cached || cf.hash != hash {
+			// If the file has syntax errors, use the partial AST (the
+			// errors were already reported when the package was
+			// loaded).
+			file, err := parser.ParseFile(g.program.Fset, filename, src, parser.ParseComments)
+			if file == nil {
+				return nil, err
+			}
+			cf = cachedFile{hash: hash, file: file}
+		}
+		if take {
+			delete(g.parseCache, filename)
+		} else {
+			g.parseCache[filename] = cf
+		}
+		files[filename] = cf.file
+	}
+	return files, nil
+}
+
+// readFile reads the file using the go/build context, so that we use
+// our vfs (such as overlaid files) if present.
+func readFile(filename string) ([]byte, error) {
+	if build.Default.OpenFile == nil {
+		return ioutil.ReadFile(filename)
+	}
+	f, err := build.Default.OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
@@ -0,0 +1,44 @@
+package gog
+
+import (
+	"go/ast"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseCache(t *testing.T) {
+	prog, cleanup := createPkgInTempDir(t, "foo", map[string]string{"foo.go": "package foo\n"})
+	defer cleanup()
+	g := New(prog)
+	filename := prog.Fset.Position(prog.Created[0].Files[0].Pos()).Filename
+
+	parse := func(take bool) *ast.File {
+		files, err := g.parseFiles([]string{filename}, take)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files[filename]
+	}
+
+	f1 := parse(false)
+	if f2 := parse(false); f2 != f1 {
+		t.Error("unchanged file was parsed again")
+	}
+
+	// The cache is keyed by contents, not modification times.
+	if err := ioutil.WriteFile(filename, []byte("package foo\n\nvar X int\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f3 := parse(true)
+	if f3 == f1 {
+		t.Error("changed file was not parsed again")
+	}
+	if len(f3.Decls) != 1 {
+		t.Errorf("got %d decls, want 1", len(f3.Decls))
+	}
+
+	// Taken ASTs are removed from the cache.
+	if f4 := parse(false); f4 == f3 {
+		t.Error("taken file was not parsed again")
+	}
+}
@@ -36,6 +36,19 @@ func TestPaths(t *testing.T) {
 		{`func init() { x:=0;_=x};func init() { x:=0;_=x}`, []defPath{{"foo", "init$sources[0]28/x"}, {"foo", "init$sources[0]52/x"}}, nil},
 
 		{`func a() { const x = false; _ = x}; const x`, []defPath{{"foo", "a/x"}, {"foo", "x"}}, nil},
+
+		// Members of anonymous struct and interface types that aren't the
+		// type of a var or field are numbered by their type literal's
+		// position in the type of the declaration, or else in the func
+		// declaration or the var or type spec (not in the whole group).
+		{`func F() struct{ X int } { return struct{ X int }{} }`, []defPath{{"foo", "F/struct$0/X"}, {"foo", "F/struct$1/X"}}, nil},
+		{`var c chan interface{ M() }`, []defPath{{"foo", "c/interface$0/M"}}, nil},
+		{`type T int; func (T) M() (a struct{ A struct{ B int } }, i interface{ I() }) { return }`, []defPath{{"foo", "T/M/a/A"}, {"foo", "T/M/a/A/B"}, {"foo", "T/M/interface$0/I"}}, nil},
+		{`func F() []struct{ X struct{ Y int } } { return nil }`, []defPath{{"foo", "F/struct$0/X"}, {"foo", "F/struct$1/Y"}}, nil},
+		{`type ( A []struct{ X int }; B []struct{ Y int } )`, []defPath{{"foo", "A/struct$0/X"}, {"foo", "B/struct$0/Y"}}, []defPath{{"foo", "B/struct$1/Y"}}},
+		{`var ( a = []struct{ X int }{}; b = []struct{ Y int }{} )`, []defPath{{"foo", "a/struct$0/X"}, {"foo", "b/struct$0/Y"}}, nil},
+		{`type I interface { Z() struct{ Z int }; A() struct{ A int } }`, []defPath{{"foo", "I/struct$0/A"}, {"foo", "I/struct$1/Z"}}, nil},
+		{`var b, a struct{ S []struct{ N int } }`, []defPath{{"foo", "a/S"}, {"foo", "b/S"}, {"foo", "a/struct$1/N"}, {"foo", "b/struct$1/N"}}, nil},
 	}
 
 	for _, c := range cases {
@@ -0,0 +1,105 @@
+package gog
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// MergePlatformOutputs merges the outputs of graphing the same package
+// on several platforms (such as "linux/amd64" and "windows/amd64"), so
+// that the defs that only exist on some platforms (because they're in
+// files with build constraints) are all included. outs[i] is the output
+// for the platforms in platforms[i], on which the package has the same
+// files.
+//
+// Each def, ref, doc, implementation, example, call, and control flow is
+// included once, from the first output that has it. A def that doesn't
+// exist on all of the platforms lists the ones that it exists on in
+// Platforms, and a def whose type differs between platforms lists its
+// type on each in PlatformTypeStrings.
+func MergePlatformOutputs(platforms [][]string, outs []*Output) *Output {
+	merged := &Output{}
+
+	defs := map[string]*Def{}
+	defPlatforms := map[string][]string{}
+	defTypes := map[string]map[string]string{}
+	refs := map[refKey]*Ref{}
+	seen := map[string]struct{}{} // JSON of other items
+	isNew := func(kind string, item interface{}) bool {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return true
+		}
+		k := kind + " " + string(data)
+		if _, present := seen[k]; present {
+			return false
+		}
+		seen[k] = struct{}{}
+		return true
+	}
+
+	var numPlatforms int
+	for i, o := range outs {
+		numPlatforms += len(platforms[i])
+		for _, def := range o.Defs {
+			k := def.DefKey.String()
+			if _, present := defs[k]; !present {
+				defs[k] = def
+				merged.Defs = append(merged.Defs, def)
+				defTypes[k] = map[string]string{}
+			}
+			for _, platform := range platforms[i] {
+				defPlatforms[k] = append(defPlatforms[k], platform)
+				defTypes[k][platform] = def.TypeString
+			}
+		}
+		for _, ref := range o.Refs {
+			k := refKey{file: ref.File, span: ref.Span, def: ref.Def.String()}
+			if existing, present := refs[k]; present {
+				existing.IsDef = existing.IsDef || ref.IsDef
+				continue
+			}
+			refs[k] = ref
+			merged.Refs = append(merged.Refs, ref)
+		}
+		for _, doc := range o.Docs {
+			if isNew("doc", doc) {
+				merged.Docs = append(merged.Docs, doc)
+			}
+		}
+		for _, impl := range o.Implementations {
+			if isNew("implementation", impl) {
+				merged.Implementations = append(merged.Implementations, impl)
+			}
+		}
+		for _, ex := range o.Examples {
+			if isNew("example", ex) {
+				merged.Examples = append(merged.Examples, ex)
+			}
+		}
+		for _, call := range o.Calls {
+			if isNew("call", call) {
+				merged.Calls = append(merged.Calls, call)
+			}
+		}
+		for _, flow := range o.ControlFlows {
+			if isNew("control flow", flow) {
+				merged.ControlFlows = append(merged.ControlFlows, flow)
+			}
+		}
+	}
+
+	for k, def := range defs {
+		if len(defPlatforms[k]) < numPlatforms {
+			def.Platforms = defPlatforms[k]
+			sort.Strings(def.Platforms)
+		}
+		for _, t := range defTypes[k] {
+			if t != def.TypeString {
+				def.PlatformTypeStrings = defTypes[k]
+				break
+			}
+		}
+	}
+	return merged
+}
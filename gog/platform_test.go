@@ -0,0 +1,54 @@
+package gog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergePlatformOutputs(t *testing.T) {
+	def := func(name, typ string) *Def {
+		d := &Def{Name: name, DefKey: &DefKey{PackageImportPath: "foo", Path: []string{name}}}
+		d.TypeString = typ
+		return d
+	}
+	ref := func(name string, start int, isDef bool) *Ref {
+		return &Ref{File: "f.go", Span: [2]int{start, start + 1}, Def: &DefKey{PackageImportPath: "foo", Path: []string{name}}, IsDef: isDef}
+	}
+	platforms := [][]string{{"linux/amd64", "linux/386"}, {"windows/amd64"}}
+	outs := []*Output{
+		{
+			Defs: []*Def{def("A", "int"), def("L", "int"), def("W", "int64")},
+			Refs: []*Ref{ref("A", 10, true), ref("L", 20, true)},
+		},
+		{
+			Defs: []*Def{def("A", "int"), def("W", "int32")},
+			Refs: []*Ref{ref("A", 10, false), ref("W", 30, true)},
+		},
+	}
+	merged := MergePlatformOutputs(platforms, outs)
+
+	defs := map[string]*Def{}
+	for _, d := range merged.Defs {
+		if _, present := defs[d.Name]; present {
+			t.Errorf("got def %s more than once", d.Name)
+		}
+		defs[d.Name] = d
+	}
+	if d := defs["A"]; d == nil || d.Platforms != nil || d.PlatformTypeStrings != nil {
+		t.Errorf("got def A %+v, want it on all platforms with one type", d)
+	}
+	if d := defs["L"]; d == nil || !reflect.DeepEqual(d.Platforms, []string{"linux/386", "linux/amd64"}) {
+		t.Errorf("got def L %+v, want it on linux/386 and linux/amd64", d)
+	}
+	wantTypes := map[string]string{"linux/amd64": "int64", "linux/386": "int64", "windows/amd64": "int32"}
+	if d := defs["W"]; d == nil || d.Platforms != nil || !reflect.DeepEqual(d.PlatformTypeStrings, wantTypes) {
+		t.Errorf("got def W %+v, want PlatformTypeStrings %v", d, wantTypes)
+	}
+
+	if len(merged.Refs) != 3 {
+		t.Fatalf("got %d refs, want 3", len(merged.Refs))
+	}
+	if !merged.Refs[0].IsDef {
+		t.Errorf("got ref to A with IsDef=false, want true")
+	}
+}
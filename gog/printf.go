@@ -0,0 +1,239 @@
+package gog
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+// emitPrintfRefs marks the refs to the called function of each call of
+// a printf-style function (see printfFormatIndex) in pkgInfo as
+// PrintfCalls. If the call's format is a string literal, it also emits
+// a ref from each verb in the format (such as "%+v") to the named type
+// of the argument that the verb formats, with the verb as the ref's
+// FormatVerb.
+func (g *Grapher) emitPrintfRefs(pkgInfo *loader.PackageInfo) error {
+	var err error
+	for _, f := range pkgInfo.Files {
+		ast.Inspect(f, func(node ast.Node) bool {
+			if err != nil {
+				return false
+			}
+			if call, ok := node.(*ast.CallExpr); ok {
+				err = g.emitPrintfCall(pkgInfo, call)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if g.canceled() {
+			return ErrCanceled
+		}
+	}
+	return nil
+}
+
+func (g *Grapher) emitPrintfCall(pkgInfo *loader.PackageInfo, call *ast.CallExpr) error {
+	var ident *ast.Ident
+	switch fun := unparen(call.Fun).(type) {
+	case *ast.Ident:
+		ident = fun
+	case *ast.SelectorExpr:
+		ident = fun.Sel
+	default:
+		return nil
+	}
+	fn, ok := pkgInfo.Uses[ident].(*types.Func)
+	if !ok {
+		return nil
+	}
+	formatIndex := printfFormatIndex(fn)
+	if formatIndex == -1 || formatIndex >= len(call.Args) {
+		return nil
+	}
+
+	ref, err := g.NewRef(ident, fn)
+	if err != nil {
+		return err
+	}
+	ref.PrintfCall = true
+	g.addRef(ref)
+
+	// The args can't be matched to the verbs if they're passed as a
+	// slice (f(format, args...)).
+	lit, ok := unparen(call.Args[formatIndex]).(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING || call.Ellipsis.IsValid() {
+		return nil
+	}
+	format, offsets, ok := unquoteWithOffsets(lit.Value)
+	if !ok {
+		return nil
+	}
+	pos := g.program.Fset.Position(lit.Pos())
+	args := call.Args[formatIndex+1:]
+	for _, v := range parsePrintfVerbs(format) {
+		if v.arg < 0 || v.arg >= len(args) {
+			continue
+		}
+		typ := pkgInfo.TypeOf(args[v.arg])
+		if ptr, ok := typ.(*types.Pointer); ok {
+			typ = ptr.Elem()
+		}
+		named, ok := typ.(*types.Named)
+		if !ok || named.Obj().Pkg() == nil {
+			continue
+		}
+		key, err := g.defKey(named.Obj())
+		if err != nil {
+			return err
+		}
+		g.addRef(&Ref{
+			File:       pos.Filename,
+			Span:       [2]int{pos.Offset + offsets[v.start], pos.Offset + offsets[v.end]},
+			Def:        key,
+			FormatVerb: format[v.start:v.end],
+			Test:       isTestFile(pos.Filename),
+		})
+	}
+	return nil
+}
+
+// printfFormatIndex returns the index of the format parameter of fn if
+// fn is a printf-style function, or else -1. Like vet, it considers a
+// function to be printf-style if its name ends in "f" and its last two
+// parameters are a format string and a ...interface{} (as in
+// fmt.Printf(format string, a ...interface{}) and
+// (*testing.T).Errorf).
+func printfFormatIndex(fn *types.Func) int {
+	if !strings.HasSuffix(fn.Name(), "f") {
+		return -1
+	}
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok || !sig.Variadic() {
+		return -1
+	}
+	params := sig.Params()
+	n := params.Len()
+	if n < 2 {
+		return -1
+	}
+	if s, ok := params.At(n - 1).Type().(*types.Slice); !ok {
+		return -1
+	} else if iface, ok := s.Elem().Underlying().(*types.Interface); !ok || iface.NumMethods() != 0 {
+		return -1
+	}
+	if b, ok := params.At(n - 2).Type().Underlying().(*types.Basic); !ok || b.Kind() != types.String {
+		return -1
+	}
+	return n - 2
+}
+
+// printfVerb is a verb (such as "%+v" or "%[2]*d") in a printf format
+// string.
+type printfVerb struct {
+	start, end int // byte offsets in the format string
+	arg        int // index of the argument (after the format) that it formats, or -1
+}
+
+// parsePrintfVerbs returns the verbs in the printf format string
+// format, with the arguments that they format. It stops at a malformed
+// explicit argument index ("%[x]d"). Escaped percent signs ("%%") are
+// omitted.
+func parsePrintfVerbs(format string) []printfVerb {
+	var verbs []printfVerb
+	argNum := 0
+	for i := 0; i < len(format); {
+		if format[i] != '%' {
+			i++
+			continue
+		}
+		start := i
+		i++
+
+		// Flags, width, precision, and explicit argument indexes. A
+		// '*' width or precision consumes an argument.
+	prefix:
+		for i < len(format) {
+			switch c := format[i]; {
+			case strings.IndexByte("+-# .", c) != -1 || '0' <= c && c <= '9':
+				i++
+			case c == '*':
+				argNum++
+				i++
+			case c == '[':
+				j := strings.IndexByte(format[i:], ']')
+				if j == -1 {
+					return verbs
+				}
+				n, err := strconv.Atoi(format[i+1 : i+j])
+				if err != nil || n < 1 {
+					return verbs
+				}
+				argNum = n - 1
+				i += j + 1
+			default:
+				break prefix
+			}
+		}
+		if i == len(format) {
+			break
+		}
+
+		verb, size := utf8.DecodeRuneInString(format[i:])
+		i += size
+		if verb == '%' {
+			continue
+		}
+		verbs = append(verbs, printfVerb{start: start, end: i, arg: argNum})
+		argNum++
+	}
+	return verbs
+}
+
+// unquoteWithOffsets unquotes the Go string literal lit and returns the
+// string and the offset in lit of each of its bytes (and of the
+// closing quote, at offsets[len(s)]).
+func unquoteWithOffsets(lit string) (s string, offsets []int, ok bool) {
+	if len(lit) < 2 {
+		return "", nil, false
+	}
+	body := lit[1 : len(lit)-1]
+	var b []byte
+	if lit[0] == '`' {
+		for i := 0; i < len(body); i++ {
+			// Raw string literals omit carriage returns.
+			if body[i] != '\r' {
+				b = append(b, body[i])
+				offsets = append(offsets, 1+i)
+			}
+		}
+		return string(b), append(offsets, len(lit)-1), true
+	}
+
+	for i := 0; i < len(body); {
+		value, multibyte, tail, err := strconv.UnquoteChar(body[i:], lit[0])
+		if err != nil {
+			return "", nil, false
+		}
+		var enc []byte
+		if value < utf8.RuneSelf || !multibyte && value < 256 {
+			// An ASCII character or a single byte (such as "\xff").
+			enc = []byte{byte(value)}
+		} else {
+			enc = make([]byte, utf8.RuneLen(value))
+			utf8.EncodeRune(enc, value)
+		}
+		for range enc {
+			offsets = append(offsets, 1+i)
+		}
+		b = append(b, enc...)
+		i = len(body) - len(tail)
+	}
+	return string(b), append(offsets, len(lit)-1), true
+}
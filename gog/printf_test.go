@@ -0,0 +1,100 @@
+package gog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrintfRefs(t *testing.T) {
+	src := `package foo
+
+type T struct{ X int }
+type U string
+
+func Logf(format string, args ...interface{}) {}
+func Log(format string, n int)                {}
+
+func _(t T, u U, args []interface{}) {
+	Logf("t=%+v %[1]d\t%*d %s %% %q", t, &t, 3, u, "x")
+	Logf(` + "`%v`" + `, u)
+	Logf("%v", args...)
+	Log("%v", 1)
+}
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+	g := New(prog)
+	g.SkipDocs = true
+	g.PrintfAnalysis = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var printfCalls []int
+	type verbRef struct {
+		verb, src, def string
+	}
+	var verbRefs []verbRef
+	for _, r := range g.Refs {
+		if r.PrintfCall {
+			printfCalls = append(printfCalls, r.Span[0])
+		}
+		if r.FormatVerb != "" {
+			verbRefs = append(verbRefs, verbRef{r.FormatVerb, src[r.Span[0]:r.Span[1]], strings.Join(r.Def.Path, "/")})
+		}
+	}
+
+	for _, start := range printfCalls {
+		if !strings.HasPrefix(src[start:], "Logf(") {
+			t.Errorf("got PrintfCall ref at %q, want only at calls of Logf", src[start:start+10])
+		}
+	}
+	if want := strings.Count(src, "\tLogf("); len(printfCalls) != want {
+		t.Errorf("got %d PrintfCall refs, want %d", len(printfCalls), want)
+	}
+
+	want := []verbRef{
+		{"%+v", "%+v", "T"},
+		{"%[1]d", "%[1]d", "T"},
+		{"%s", "%s", "U"},
+		{"%v", "%v", "U"},
+	}
+	if !reflect.DeepEqual(verbRefs, want) {
+		t.Errorf("got verb refs %+v, want %+v", verbRefs, want)
+	}
+}
+
+func TestParsePrintfVerbs(t *testing.T) {
+	tests := map[string][]printfVerb{
+		"":               nil,
+		"%d %s":          {{0, 2, 0}, {3, 5, 1}},
+		"%% %-5.2f":      {{3, 9, 0}},
+		"%*d %.*s":       {{0, 3, 1}, {4, 8, 3}},
+		"%[2]d %[1]d %d": {{0, 5, 1}, {6, 11, 0}, {12, 14, 1}},
+		"%[x]d":          nil,
+		"%é %":           {{0, 3, 0}},
+	}
+	for format, want := range tests {
+		if got := parsePrintfVerbs(format); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", format, got, want)
+		}
+	}
+}
+
+func TestUnquoteWithOffsets(t *testing.T) {
+	tests := []struct {
+		lit     string
+		want    string
+		offsets []int
+	}{
+		{`"a\tb"`, "a\tb", []int{1, 2, 4, 5}},
+		{`"\xffé"`, "\xffé", []int{1, 5, 5, 7}},
+		{"`a\r\nb`", "a\nb", []int{1, 3, 4, 5}},
+	}
+	for _, test := range tests {
+		s, offsets, ok := unquoteWithOffsets(test.lit)
+		if !ok || s != test.want || !reflect.DeepEqual(offsets, test.offsets) {
+			t.Errorf("%s: got %q, %v, %v, want %q, %v", test.lit, s, offsets, ok, test.want, test.offsets)
+		}
+	}
+}
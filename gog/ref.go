@@ -3,6 +3,7 @@ package gog
 import (
 	"go/ast"
 
+	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/types"
 )
 
@@ -17,6 +18,7 @@ func (g *Grapher) NewRef(node ast.Node, obj types.Object) (*Ref, error) {
 		File: pos.Filename,
 		Span: makeSpan(g.program.Fset, node),
 		Def:  key,
+		Test: isTestFile(pos.Filename),
 	}, nil
 }
 
@@ -28,4 +30,79 @@ type Ref struct {
 	// IsDef is true if ref is to the definition of Def, and false if it's to a
 	// use of Def.
 	IsDef bool
+
+	// MethodUse is how the method Def is used, if ref is a method
+	// selector: MethodCall, MethodValue, or MethodExpr.
+	MethodUse string `json:",omitempty"`
+
+	// Test is whether the ref is in a test file (*_test.go).
+	Test bool `json:",omitempty"`
+
+	// Snippet is the source code around the ref, if the Grapher added
+	// snippets (see AddRefSnippets).
+	Snippet *Snippet `json:",omitempty"`
+
+	// PrintfCall is whether the ref is to the function called by a
+	// call of a printf-style function (with PrintfAnalysis).
+	PrintfCall bool `json:",omitempty"`
+
+	// FormatVerb is the verb (such as "%+v"), if the ref is from a verb
+	// in a printf format string to the named type of the argument that
+	// it formats (with PrintfAnalysis).
+	FormatVerb string `json:",omitempty"`
+}
+
+// Ways in which a method is used (see Ref.MethodUse).
+const (
+	// MethodCall is a call of a method (v.M()).
+	MethodCall = "call"
+
+	// MethodValue is a method value (v.M, not called), which binds the
+	// method to its receiver.
+	MethodValue = "value"
+
+	// MethodExpr is a method expression (T.M or (*T).M), which yields
+	// a function whose first parameter is the receiver.
+	MethodExpr = "expr"
+)
+
+// methodUses returns how the method selected by each method selector
+// ident in pkgInfo is used.
+func methodUses(pkgInfo *loader.PackageInfo) map[*ast.Ident]string {
+	called := make(map[*ast.SelectorExpr]bool)
+	for _, f := range pkgInfo.Files {
+		ast.Inspect(f, func(node ast.Node) bool {
+			if call, ok := node.(*ast.CallExpr); ok {
+				if sel, ok := unparen(call.Fun).(*ast.SelectorExpr); ok {
+					called[sel] = true
+				}
+			}
+			return true
+		})
+	}
+
+	uses := make(map[*ast.Ident]string)
+	for sel, selection := range pkgInfo.Selections {
+		switch selection.Kind() {
+		case types.MethodVal:
+			if called[sel] {
+				uses[sel.Sel] = MethodCall
+			} else {
+				uses[sel.Sel] = MethodValue
+			}
+		case types.MethodExpr:
+			uses[sel.Sel] = MethodExpr
+		}
+	}
+	return uses
+}
+
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
 }
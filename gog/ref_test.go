@@ -0,0 +1,888 @@
+package gog
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/types"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
+)
+
+func TestMethodRefs(t *testing.T) {
+	pkgDefs := `type I interface { M() }; type T struct{}; func (T) M() {}; func (*T) P() {}; type E struct { *T };`
+	cases := map[string]struct {
+		ref           string
+		wantDef       *DefKey
+		wantMethodUse string
+	}{
+		"method call": {
+			ref:           `t.M()`,
+			wantDef:       &DefKey{"foo", []string{"T", "M"}},
+			wantMethodUse: MethodCall,
+		},
+		"parenthesized method call": {
+			ref:           `(t.M)()`,
+			wantDef:       &DefKey{"foo", []string{"T", "M"}},
+			wantMethodUse: MethodCall,
+		},
+		"method value": {
+			ref:           `_ = t.M`,
+			wantDef:       &DefKey{"foo", []string{"T", "M"}},
+			wantMethodUse: MethodValue,
+		},
+		"method expression": {
+			ref:           `_ = T.M`,
+			wantDef:       &DefKey{"foo", []string{"T", "M"}},
+			wantMethodUse: MethodExpr,
+		},
+		"pointer method expression": {
+			ref:           `_ = (*T).P`,
+			wantDef:       &DefKey{"foo", []string{"T", "P"}},
+			wantMethodUse: MethodExpr,
+		},
+		"interface method value": {
+			ref:           `_ = i.M`,
+			wantDef:       &DefKey{"foo", []string{"I", "M"}},
+			wantMethodUse: MethodValue,
+		},
+		"interface method expression": {
+			ref:           `_ = I.M`,
+			wantDef:       &DefKey{"foo", []string{"I", "M"}},
+			wantMethodUse: MethodExpr,
+		},
+		"method value promoted through pointer embedding": {
+			ref:           `_ = e.P`,
+			wantDef:       &DefKey{"foo", []string{"T", "P"}},
+			wantMethodUse: MethodValue,
+		},
+		"method promoted through pointer embedding": {
+			ref:           `e.M()`,
+			wantDef:       &DefKey{"foo", []string{"T", "M"}},
+			wantMethodUse: MethodCall,
+		},
+		"deferred method call": {
+			ref:           `defer t.M()`,
+			wantDef:       &DefKey{"foo", []string{"T", "M"}},
+			wantMethodUse: MethodCall,
+		},
+		"deferred interface method call": {
+			ref:           `defer i.M()`,
+			wantDef:       &DefKey{"foo", []string{"I", "M"}},
+			wantMethodUse: MethodCall,
+		},
+		"method call in goroutine": {
+			ref:           `go e.P()`,
+			wantDef:       &DefKey{"foo", []string{"T", "P"}},
+			wantMethodUse: MethodCall,
+		},
+		"method call in goroutine closure": {
+			ref:           `go func() { t.M() }()`,
+			wantDef:       &DefKey{"foo", []string{"T", "M"}},
+			wantMethodUse: MethodCall,
+		},
+		"method value passed to deferred call": {
+			ref:           `defer func(func()) {}(t.M)`,
+			wantDef:       &DefKey{"foo", []string{"T", "M"}},
+			wantMethodUse: MethodValue,
+		},
+	}
+
+	for label, c := range cases {
+		src := `package foo; ` + pkgDefs + ` func _(t T, i I, e E) { /*START*/` + c.ref + `/*END*/ }`
+		start, end := strings.Index(src, "/*START*/"), strings.Index(src, "/*END*/")
+		prog := createPkg(t, "foo", []string{src}, nil)
+
+		g := New(prog)
+		g.SkipDocs = true
+		if err := g.Graph(prog.Created[0]); err != nil {
+			t.Fatal(label, err)
+		}
+
+		var found bool
+		for _, r := range g.Refs {
+			if r.Span[0] < start || r.Span[1] > end || !reflect.DeepEqual(r.Def, c.wantDef) {
+				continue
+			}
+			found = true
+			if r.MethodUse != c.wantMethodUse {
+				t.Errorf("%s: got MethodUse %q, want %q", label, r.MethodUse, c.wantMethodUse)
+			}
+		}
+		if !found {
+			t.Errorf("%s: ref not found: %+v", label, c.wantDef)
+		}
+	}
+}
+
+func TestImportRefs(t *testing.T) {
+	// Only unsafe is importable from source-less test packages.
+	src := `package foo
+
+import (
+	"unsafe"
+	u "unsafe"
+	. "unsafe"
+	_ "unsafe"
+)
+
+var _, _, _ = unsafe.Sizeof(0), u.Sizeof(0), Sizeof(0)
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	refs := map[string]int{}
+	for _, r := range g.Refs {
+		if r.Def.PackageImportPath != "unsafe" || len(r.Def.Path) != 0 {
+			continue
+		}
+		if r.IsDef {
+			t.Errorf("ref at %d: got IsDef, want a use", r.Span[0])
+		}
+		refs[src[r.Span[0]:r.Span[1]]]++
+	}
+	wantRefs := map[string]int{
+		`"unsafe"`: 4,
+		`u`:        2, // the alias and its use
+		`.`:        1,
+		`unsafe`:   1,
+	}
+	if !reflect.DeepEqual(refs, wantRefs) {
+		t.Errorf("got refs to package unsafe %v, want %v", refs, wantRefs)
+	}
+}
+
+func TestFailedImportRefs(t *testing.T) {
+	src := `package foo
+
+import (
+	"example.com/missing/bytes"
+	yaml "example.com/missing/go-yaml.v2"
+	_ "example.com/missing/blank"
+)
+
+var b bytes.Buffer
+
+func F() {
+	_ = yaml.Marshal
+	bytes := 0
+	_ = bytes
+}
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	refs := map[string]string{}
+	for _, r := range g.Refs {
+		if !strings.HasPrefix(r.Def.PackageImportPath, "example.com/") {
+			continue
+		}
+		refs[fmt.Sprintf("%d:%s", r.Span[0], src[r.Span[0]:r.Span[1]])] = r.Def.String()
+	}
+	at := func(s string, n int) string {
+		i := -1
+		for ; n >= 0; n-- {
+			i += 1 + strings.Index(src[i+1:], s)
+		}
+		return fmt.Sprintf("%d:%s", i, s)
+	}
+	wantRefs := map[string]string{
+		at(`"example.com/missing/bytes"`, 0):      "example.com/missing/bytes#",
+		at(`"example.com/missing/go-yaml.v2"`, 0): "example.com/missing/go-yaml.v2#",
+		at(`"example.com/missing/blank"`, 0):      "example.com/missing/blank#",
+		at(`bytes`, 1):                            "example.com/missing/bytes#",
+		at(`Buffer`, 0):                           "example.com/missing/bytes#Buffer",
+		at(`yaml`, 2):                             "example.com/missing/go-yaml.v2#",
+		at(`Marshal`, 0):                          "example.com/missing/go-yaml.v2#Marshal",
+	}
+	if !reflect.DeepEqual(refs, wantRefs) {
+		t.Errorf("got refs to packages that failed to import %v, want %v", refs, wantRefs)
+	}
+}
+
+// exportDataRef is a ref (to def) from the ident in the line of the
+// source that contains the string line.
+type exportDataRef struct {
+	line, ident string
+	def         string
+}
+
+// TestExportDataSelectorRefs tests refs from selector chains to the
+// fields and methods of a package that is imported from export data
+// (so the grapher has its types but not its AST).
+func TestExportDataSelectorRefs(t *testing.T) {
+	depSrc := `package dep
+
+type Client struct {
+	Transport *Transport
+	Items     []struct{ N int }
+	Opts      map[string]struct{ Timeout int }
+}
+
+type Transport struct{ Dialer *Dialer }
+
+type Dialer struct{ Timeout int }
+
+func (*Dialer) Dial() {}
+
+var DefaultClient = &Client{}
+`
+	src := `package foo
+
+import "dep"
+
+var _ = dep.DefaultClient.Transport.Dialer.Timeout
+var _ = dep.DefaultClient.Transport.Dialer.Dial
+var _ = dep.DefaultClient.Items[0].N
+var _ = dep.DefaultClient.Opts[""].Timeout
+`
+	testExportDataRefs(t, depSrc, src, []exportDataRef{
+		{"Dialer.Timeout", "DefaultClient", "dep#DefaultClient"},
+		{"Dialer.Timeout", "Transport", "dep#Client.Transport"},
+		{"Dialer.Timeout", "Dialer", "dep#Transport.Dialer"},
+		{"Dialer.Timeout", "Timeout", "dep#Dialer.Timeout"},
+		{"Dialer.Dial", "Dial", "dep#Dialer.Dial"},
+		{"Items[0].N", "Items", "dep#Client.Items"},
+		{"Items[0].N", "N", "dep#Client.struct$1.N"},
+		{"Opts[\"\"].Timeout", "Timeout", "dep#Client.struct$2.Timeout"},
+	})
+}
+
+// TestExportDataAnonMemberRefs tests that the members of anonymous
+// struct types have the same paths when their package is graphed from
+// source and when it is imported from export data.
+func TestExportDataAnonMemberRefs(t *testing.T) {
+	tests := map[string]struct {
+		depSrc, src string
+		want        []exportDataRef
+	}{
+		"grouped type decl": {
+			// Each spec's type literals are numbered separately.
+			depSrc: `package dep
+
+type (
+	A []struct{ X int }
+	B []struct{ Y int }
+)
+
+var DefaultA A
+var DefaultB B
+`,
+			src: `package foo
+
+import "dep"
+
+var _ = dep.DefaultA[0].X
+var _ = dep.DefaultB[0].Y
+`,
+			want: []exportDataRef{
+				{"DefaultA[0].X", "X", "dep#A.struct$0.X"},
+				{"DefaultB[0].Y", "Y", "dep#B.struct$0.Y"},
+			},
+		},
+		"interface methods out of order": {
+			// The methods are walked in sorted order (A, then Z).
+			depSrc: `package dep
+
+type I interface {
+	Z() struct{ Z int }
+	A() struct{ A int }
+}
+
+var DefaultI I
+`,
+			src: `package foo
+
+import "dep"
+
+var _ = dep.DefaultI.Z().Z
+var _ = dep.DefaultI.A().A
+`,
+			want: []exportDataRef{
+				{"Z().Z", "Z", "dep#I.struct$1.Z"},
+				{"A().A", "A", "dep#I.struct$0.A"},
+			},
+		},
+		"multiple-name var spec": {
+			// Each name has its own struct type, whose literals are
+			// numbered under that name (not under the spec's first
+			// name).
+			depSrc: `package dep
+
+var B, A struct{ S []struct{ N int } }
+`,
+			src: `package foo
+
+import "dep"
+
+var _ = dep.B.S[0].N
+var _ = dep.A.S[0].N
+`,
+			want: []exportDataRef{
+				{"B.S[0].N", "N", "dep#B.struct$1.N"},
+				{"A.S[0].N", "N", "dep#A.struct$1.N"},
+			},
+		},
+	}
+	for label, test := range tests {
+		t.Logf("# %s", label)
+		testExportDataRefs(t, test.depSrc, test.src, test.want)
+	}
+}
+
+// testExportDataRefs graphs src, which imports the package dep (whose
+// source is depSrc) from export data, and checks that it has the
+// wanted refs to dep and that they refer to the defs that are emitted
+// when dep is graphed from source.
+func testExportDataRefs(t *testing.T, depSrc, src string, want []exportDataRef) {
+	// The paths of the members of anonymous structs must be the same as
+	// when dep is graphed from source.
+	depProg := createPkg(t, "dep", []string{depSrc}, nil)
+	dg := New(depProg)
+	dg.SkipDocs = true
+	if err := dg.Graph(depProg.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+	depDefs := map[string]bool{}
+	for _, def := range dg.Defs {
+		depDefs[def.DefKey.String()] = true
+	}
+	for _, w := range want {
+		if !depDefs[w.def] {
+			t.Errorf("graphing dep from source emitted no def %s", w.def)
+		}
+	}
+
+	conf := Default
+	conf.Fset = token.NewFileSet()
+	conf.TypeChecker.Import = func(imports map[string]*types.Package, path string) (*types.Package, error) {
+		f, err := parser.ParseFile(conf.Fset, "dep.go", depSrc, 0)
+		if err != nil {
+			return nil, err
+		}
+		pkg, err := (&types.Config{}).Check(path, conf.Fset, []*ast.File{f}, nil)
+		imports[path] = pkg
+		return pkg, err
+	}
+	f, err := conf.ParseFile("foo.go", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.CreateFromFiles("foo", f)
+	prog, err := conf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	refs := map[int]string{}
+	for _, ref := range g.Refs {
+		refs[ref.Span[0]] = ref.Def.String()
+	}
+	for _, w := range want {
+		line := strings.Index(src, w.line)
+		start := strings.LastIndex(src[:line+len(w.line)], w.ident)
+		if got := refs[start]; got != w.def {
+			t.Errorf("%s in %s: got ref to %q, want %q", w.ident, w.line, got, w.def)
+		}
+	}
+}
+
+func TestReceiverRefs(t *testing.T) {
+	cases := map[string]struct {
+		src  string
+		recv string   // the receiver type name's path
+		want []string // refs (text at ref: def key), in order
+	}{
+		"pointer receiver": {
+			src:  `type Server struct{}; func (s *Server) Start() { _ = s }`,
+			want: []string{"s: foo#Server.Start.s (def)", "Server: foo#Server", "Start: foo#Server.Start (def)", "s: foo#Server.Start.s"},
+		},
+		"value receiver": {
+			src:  `type Server struct{}; func (s Server) Start() { _ = s }`,
+			want: []string{"s: foo#Server.Start.s (def)", "Server: foo#Server", "Start: foo#Server.Start (def)", "s: foo#Server.Start.s"},
+		},
+		"generic receiver": {
+			// The vendored type checker doesn't support generics, but the
+			// receiver and the method still get refs and defs.
+			src:  `type List[T any] struct{}; func (l *List[T]) Len() int { _ = l; return 0 }`,
+			want: []string{"l: foo#List.Len.l (def)", "List: foo#List", "Len: foo#List.Len (def)", "l: foo#List.Len.l"},
+		},
+	}
+	for label, c := range cases {
+		src := "package foo; " + c.src
+		prog := createPkg(t, "foo", []string{src}, nil)
+		g := New(prog)
+		g.SkipDocs = true
+		if err := g.Graph(prog.Created[0]); err != nil {
+			t.Fatal(label, err)
+		}
+
+		var refs refsByStart
+		for _, ref := range g.Refs {
+			if ref.Span[0] > strings.Index(src, "func") {
+				refs = append(refs, ref)
+			}
+		}
+		sort.Sort(refs)
+		var got []string
+		for _, ref := range refs {
+			if ref.Def.PackageImportPath != "foo" {
+				continue
+			}
+			s := fmt.Sprintf("%s: %s", src[ref.Span[0]:ref.Span[1]], ref.Def)
+			if ref.IsDef {
+				s += " (def)"
+			}
+			got = append(got, s)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got refs %q, want %q", label, got, c.want)
+		}
+	}
+}
+
+type refsByStart []*Ref
+
+func (r refsByStart) Len() int           { return len(r) }
+func (r refsByStart) Less(i, j int) bool { return r[i].Span[0] < r[j].Span[0] }
+func (r refsByStart) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func TestGuessPackageName(t *testing.T) {
+	tests := map[string]string{
+		"bytes":                         "bytes",
+		"github.com/a/b":                "b",
+		"github.com/a/b/v2":             "b",
+		"gopkg.in/yaml.v2":              "yaml",
+		"github.com/mattn/go-sqlite3":   "sqlite3",
+		"github.com/a/b-go":             "b",
+		"example.com/go-yaml.v2/v3/foo": "foo",
+	}
+	for path, want := range tests {
+		if got := guessPackageName(path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	src := `package foo
+
+func F() {
+outer:
+	for {
+	inner:
+		for {
+			if true {
+				continue outer
+			}
+			break inner
+		}
+		break outer
+	}
+	goto end
+end:
+	_ = func() {
+	outer:
+		for {
+			break outer
+		}
+	}
+}
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]string{}
+	for _, d := range g.Defs {
+		if d.Kind == definfo.Label {
+			defs[d.DefKey.String()] = src[d.IdentSpan[0]:d.IdentSpan[1]]
+		}
+	}
+	if len(defs) != 4 {
+		t.Errorf("got %d label defs %v, want 4", len(defs), defs)
+	}
+	for _, key := range []string{"foo#F.outer$label", "foo#F.inner$label", "foo#F.end$label"} {
+		if _, ok := defs[key]; !ok {
+			t.Errorf("no label def %s; got %v", key, defs)
+		}
+	}
+
+	refs := map[string]int{}
+	for _, r := range g.Refs {
+		if strings.HasSuffix(r.Def.String(), "$label") && !r.IsDef {
+			refs[r.Def.String()]++
+		}
+	}
+	wantRefs := map[string]int{
+		"foo#F.outer$label": 2,
+		"foo#F.inner$label": 1,
+		"foo#F.end$label":   1,
+	}
+	for key, want := range wantRefs {
+		if refs[key] != want {
+			t.Errorf("got %d refs to %s, want %d", refs[key], key, want)
+		}
+	}
+	var total int
+	for _, n := range refs {
+		total += n
+	}
+	if total != 5 {
+		t.Errorf("got %d refs to labels %v, want 5 (including one to the func literal's label)", total, refs)
+	}
+}
+
+func TestShadowedRefs(t *testing.T) {
+	src := `package foo
+
+var x = 0
+
+func F() {
+	_ = x /*pkg*/
+	x := 1
+	_ = x /*outer*/
+	{
+		x := 2
+		_ = x /*inner*/
+	}
+	_ = x /*outer again*/
+	func() {
+		_ = x /*closure captures outer*/
+		x := 3
+		_ = x /*closure*/
+	}()
+	for x := 0; x < 1; x++ /*loop*/ {
+	}
+	if x := 4; x > 0 /*if*/ {
+	}
+}
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The def of each x, keyed by the comment that follows its use.
+	defOf := map[string]string{}
+	for _, r := range g.Refs {
+		if r.IsDef || src[r.Span[0]:r.Span[1]] != "x" {
+			continue
+		}
+		rest := src[r.Span[1]:]
+		if i := strings.Index(rest, "/*"); i != -1 && !strings.Contains(rest[:i], "\n") {
+			defOf[rest[i+2:i+strings.Index(rest[i:], "*/")]] = r.Def.String()
+		}
+	}
+
+	defs := map[string]bool{}
+	for _, d := range g.Defs {
+		if d.Name == "x" {
+			defs[d.DefKey.String()] = true
+		}
+	}
+	if len(defs) != 6 {
+		t.Errorf("got %d defs of x %v, want 6", len(defs), defs)
+	}
+
+	same := [][]string{
+		{"outer", "outer again", "closure captures outer"},
+	}
+	for _, uses := range same {
+		for _, use := range uses[1:] {
+			if defOf[use] != defOf[uses[0]] {
+				t.Errorf("use %q refers to %s, want %s (same as %q)", use, defOf[use], defOf[uses[0]], uses[0])
+			}
+		}
+	}
+	distinct := []string{"pkg", "outer", "inner", "closure", "loop", "if"}
+	seen := map[string]string{}
+	for _, use := range distinct {
+		def, ok := defOf[use]
+		if !ok {
+			t.Errorf("no ref for use %q", use)
+			continue
+		}
+		if !defs[def] {
+			t.Errorf("use %q refers to %s, which is not a def of x", use, def)
+		}
+		if other, dup := seen[def]; dup {
+			t.Errorf("uses %q and %q both refer to %s, want distinct defs", other, use, def)
+		}
+		seen[def] = use
+	}
+	if got, want := defOf["pkg"], "foo#x"; got != want {
+		t.Errorf("use %q refers to %s, want %s", "pkg", got, want)
+	}
+}
+
+func TestCompositeLitKeyRefs(t *testing.T) {
+	src := `package foo
+
+type Point struct{ X, Y int }
+type Line struct{ A, B Point }
+
+const N = 2
+
+var K = "k"
+
+var _ = Point{X /*struct*/ : 1, Y: 2}
+var _ = &Point{X /*pointer*/ : 1}
+var _ = Line{A: Point{X /*nested*/ : 1}, B: Point{Y /*nested second*/ : 2}}
+var _ = []Point{{X /*slice implicit*/ : 1}, 1: {Y: 2}}
+var _ = []*Point{{X /*pointer implicit*/ : 1}}
+var _ = map[string]Point{K /*map key*/ : {X /*map value implicit*/ : 1}}
+var _ = [...]int{N /*array index*/ : 1}
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The def of each key, keyed by the comment that follows it.
+	defOf := map[string]string{}
+	for _, r := range g.Refs {
+		if r.IsDef {
+			continue
+		}
+		rest := src[r.Span[1]:]
+		if strings.HasPrefix(rest, " /*") {
+			defOf[rest[3:strings.Index(rest, "*/")]] = r.Def.String()
+		}
+	}
+
+	want := map[string]string{
+		"struct":             "foo#Point.X",
+		"pointer":            "foo#Point.X",
+		"nested":             "foo#Point.X",
+		"nested second":      "foo#Point.Y",
+		"slice implicit":     "foo#Point.X",
+		"pointer implicit":   "foo#Point.X",
+		"map key":            "foo#K",
+		"map value implicit": "foo#Point.X",
+		"array index":        "foo#N",
+	}
+	for key, wantDef := range want {
+		if got := defOf[key]; got != wantDef {
+			t.Errorf("key %q: got ref to %q, want %q", key, got, wantDef)
+		}
+	}
+}
+
+func TestTestDefsAndRefs(t *testing.T) {
+	srcs := []string{
+		`package foo; func F() {}; func _() { F() }`,
+		`package foo; func helper() { F() }; func _() { helper() }`,
+	}
+	prog := createPkg(t, "foo", srcs, []string{"foo.go", "foo_test.go"})
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range g.Defs {
+		if want := d.File == "foo_test.go"; d.Test != want {
+			t.Errorf("def %s in %s: got Test %v, want %v", d.DefKey, d.File, d.Test, want)
+		}
+	}
+	var testRefsToF int
+	for _, r := range g.Refs {
+		if want := r.File == "foo_test.go"; r.Test != want {
+			t.Errorf("ref to %s in %s: got Test %v, want %v", r.Def, r.File, r.Test, want)
+		}
+		if r.Test && !r.IsDef && r.Def.String() == "foo#F" {
+			testRefsToF++
+		}
+	}
+	if testRefsToF != 1 {
+		t.Errorf("got %d refs from the test file to the non-test def foo#F, want 1", testRefsToF)
+	}
+}
+
+func TestTypeSwitchDefs(t *testing.T) {
+	src := `package foo
+
+type T struct{}
+type U struct{}
+
+func F(v interface{}) {
+	_ = v.(T /*assert*/)
+	switch x := v.(type) {
+	case T /*case*/ :
+		_ = x /*T*/
+	case U, *T:
+		_ = x /*multi*/
+	case nil:
+		_ = x /*nil*/
+	default:
+		_ = x /*default*/
+	}
+}
+`
+	prog := createPkg(t, "foo", []string{src}, nil)
+
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]*Def{}
+	for _, d := range g.Defs {
+		defs[d.DefKey.String()] = d
+	}
+
+	// The def of each ref, keyed by the comment that follows it.
+	defOf := map[string]string{}
+	var headerDefRefs int
+	for _, r := range g.Refs {
+		if r.IsDef && src[r.Span[0]:r.Span[1]] == "x" {
+			headerDefRefs++
+			continue
+		}
+		rest := src[r.Span[1]:]
+		if strings.HasPrefix(rest, " /*") {
+			defOf[rest[3:strings.Index(rest, "*/")]] = r.Def.String()
+		}
+	}
+	if headerDefRefs != 4 {
+		t.Errorf("got %d def refs at the switch header's x, want 4 (one per clause)", headerDefRefs)
+	}
+
+	for _, use := range []string{"assert", "case"} {
+		if got, want := defOf[use], "foo#T"; got != want {
+			t.Errorf("%s: got ref to %q, want %q", use, got, want)
+		}
+	}
+
+	wantTypes := map[string]string{
+		"T":       "foo.T",
+		"multi":   "interface{}",
+		"nil":     "interface{}",
+		"default": "interface{}",
+	}
+	seen := map[string]string{}
+	for use, wantType := range wantTypes {
+		def, ok := defs[defOf[use]]
+		if !ok {
+			t.Errorf("use %q: no def for %q", use, defOf[use])
+			continue
+		}
+		if def.Name != "x" || def.TypeString != wantType {
+			t.Errorf("use %q: got def %s with type %q, want x with type %q", use, def.DefKey, def.TypeString, wantType)
+		}
+		if other, dup := seen[defOf[use]]; dup {
+			t.Errorf("uses %q and %q both refer to %s, want a def per clause", other, use, defOf[use])
+		}
+		seen[defOf[use]] = use
+	}
+}
+
+func TestNoDuplicateRefs(t *testing.T) {
+	srcs := []string{`package foo
+
+import u "unsafe"
+
+type Point struct{ X, Y int }
+
+type Embedder struct {
+	Point
+	*Line
+}
+
+type Line struct{ A, B Point }
+
+func (p Point) M() int { return p.X }
+
+var _ = u.Sizeof(0)
+
+func F(v interface{}) {
+	e := Embedder{Point: Point{X: 1}}
+	_ = e.Point.M()
+	_ = e.M()
+	_ = e.A.Y
+	f := Point.M
+	_ = f
+	switch x := v.(type) {
+	case int, string:
+		_ = x
+	case Point:
+		_ = x.X
+	}
+L:
+	for {
+		break L
+	}
+}
+`, `package foo
+
+var _ = Point{}.M
+`}
+	prog := createPkg(t, "foo", srcs, []string{"a.go", "b.go"})
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	type refKey struct {
+		file string
+		span [2]int
+		def  string
+	}
+	seen := map[refKey]int{}
+	for _, r := range g.Refs {
+		seen[refKey{r.File, r.Span, r.Def.String()}]++
+	}
+	for k, n := range seen {
+		if n > 1 {
+			t.Errorf("%d refs at %s:%v to %s, want at most 1", n, k.file, k.span, k.def)
+		}
+	}
+
+	// A ref that duplicates an emitted one is merged into it.
+	n := len(g.Refs)
+	def := &DefKey{PackageImportPath: "foo", Path: []string{"Point"}}
+	g.addRef(&Ref{File: "a.go", Span: [2]int{1000, 1005}, Def: def})
+	g.addRef(&Ref{File: "a.go", Span: [2]int{1000, 1005}, Def: &DefKey{PackageImportPath: "foo", Path: []string{"Point"}}, IsDef: true})
+	g.addRef(&Ref{File: "a.go", Span: [2]int{1000, 1005}, Def: &DefKey{PackageImportPath: "foo", Path: []string{"Line"}}})
+	if got, want := len(g.Refs), n+2; got != want {
+		t.Fatalf("got %d refs after adding a duplicate, want %d", got, want)
+	}
+	if r := g.Refs[n]; !r.IsDef {
+		t.Errorf("got merged ref %+v, want IsDef", r)
+	}
+}
@@ -24,6 +24,18 @@ func (g *Grapher) path(obj types.Object) (path []string) {
 		return path
 	}
 
+	if label, ok := obj.(*types.Label); ok {
+		return g.labelPath(label)
+	}
+
+	if path := g.anonMemberPath(obj); path != nil {
+		return path
+	}
+
+	if path := g.unresolvedMethodPath(obj); path != nil {
+		return path
+	}
+
 	var scope *types.Scope
 	pkgInfo, astPath, _ := g.program.PathEnclosingInterval(obj.Pos(), obj.Pos())
 	if astPath != nil {
@@ -56,6 +68,162 @@ func (g *Grapher) path(obj types.Object) (path []string) {
 	panic("no scope node for object " + obj.String())
 }
 
+// labelPath returns the path of a label, which is the path of the
+// function (or function literal) that declares it plus the label's name
+// with a "$label" suffix (so that it doesn't collide with a local
+// identifier of the same name).
+func (g *Grapher) labelPath(label *types.Label) []string {
+	pkgInfo, astPath, _ := g.program.PathEnclosingInterval(label.Pos(), label.Pos())
+	for _, node := range astPath {
+		var funcType *ast.FuncType
+		switch node := node.(type) {
+		case *ast.FuncDecl:
+			funcType = node.Type
+		case *ast.FuncLit:
+			funcType = node.Type
+		default:
+			continue
+		}
+		if prefix, hasPath := g.scopePaths[pkgInfo.Scopes[funcType]]; hasPath {
+			return append(append([]string{}, prefix...), label.Name()+"$label")
+		}
+		break
+	}
+	log.Printf("Warning: no enclosing function for label %s at pos %s", label.Name(), g.program.Fset.Position(label.Pos()))
+	return nil
+}
+
+// anonMemberPath returns the path of obj if it's a field or method of
+// an anonymous struct or interface type that isn't part of the type of
+// a package-level declaration (whose members' paths are assigned by
+// assignAnonMemberPaths), such as a type literal in a func body or in a
+// var's value. The path is the path of the declaration containing the
+// type literal, followed by "struct$N" or "interface$N" (where N is the
+// index of the literal among the struct or interface type literals in
+// the func declaration or in the var or type spec, in source order) and
+// obj's name, such as F/struct$2/X. It returns nil otherwise.
+func (g *Grapher) anonMemberPath(obj types.Object) []string {
+	switch obj := obj.(type) {
+	case *types.Var:
+		if !obj.IsField() {
+			return nil
+		}
+	case *types.Func:
+		if sig, ok := obj.Type().(*types.Signature); !ok || sig.Recv() == nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	if obj.Pos() == token.NoPos {
+		return nil
+	}
+
+	pkgInfo, astPath, _ := g.program.PathEnclosingInterval(obj.Pos(), obj.Pos())
+	if len(astPath) < 3 {
+		return nil
+	}
+	// Fields and interface methods are directly in the type literal.
+	var lit ast.Node
+	for _, node := range astPath {
+		switch node.(type) {
+		case *ast.StructType, *ast.InterfaceType:
+			lit = node
+		}
+		if lit != nil {
+			break
+		}
+	}
+	if lit == nil {
+		return nil
+	}
+
+	// The literals are numbered within the func declaration, or within
+	// the spec (not the whole var or type declaration group, so that
+	// adding or removing a spec doesn't renumber the others).
+	var prefix []string
+	var decl ast.Node
+	switch d := astPath[len(astPath)-2].(type) {
+	case *ast.FuncDecl:
+		decl = d
+		if fobj := pkgInfo.Defs[d.Name]; fobj != nil {
+			prefix = g.path(fobj)
+		}
+	case *ast.GenDecl:
+		var name *ast.Ident
+		switch spec := astPath[len(astPath)-3].(type) {
+		case *ast.ValueSpec:
+			decl = spec
+			if len(spec.Names) > 0 {
+				name = spec.Names[0]
+			}
+		case *ast.TypeSpec:
+			decl = spec
+			name = spec.Name
+		}
+		if name != nil && pkgInfo.Defs[name] != nil {
+			prefix = g.path(pkgInfo.Defs[name])
+		}
+	}
+	if prefix == nil {
+		return nil
+	}
+
+	kind := typeLitKind(lit)
+	n, found := 0, false
+	ast.Inspect(decl, func(node ast.Node) bool {
+		switch {
+		case found:
+			return false
+		case node == lit:
+			found = true
+		case node != nil && typeLitKind(node) == kind:
+			n++
+		}
+		return true
+	})
+	if !found {
+		return nil
+	}
+	return append(append([]string{}, prefix...), fmt.Sprintf("%s$%d", kind, n), obj.Name())
+}
+
+// unresolvedMethodPath returns the path (T/M) of a method whose
+// receiver type the type checker couldn't resolve to a named type, so
+// that it isn't one of the type's methods (such as the methods of
+// generic types, which the type checker doesn't support). It returns
+// nil otherwise.
+func (g *Grapher) unresolvedMethodPath(obj types.Object) []string {
+	fn, ok := obj.(*types.Func)
+	if !ok || fn.Pos() == token.NoPos {
+		return nil
+	}
+	if sig, ok := fn.Type().(*types.Signature); !ok || sig.Recv() == nil {
+		return nil
+	}
+	_, astPath, _ := g.program.PathEnclosingInterval(fn.Pos(), fn.Pos())
+	for _, node := range astPath {
+		if fd, ok := node.(*ast.FuncDecl); ok && fd.Recv != nil && len(fd.Recv.List) > 0 && fd.Name.Pos() == fn.Pos() {
+			if name := methodRecvTypeName(fd.Recv.List[0].Type); name != "" {
+				return []string{name, fn.Name()}
+			}
+		}
+	}
+	return nil
+}
+
+// typeLitKind returns "struct" or "interface" if node is a struct or
+// interface type literal, and "" otherwise.
+func typeLitKind(node ast.Node) string {
+	switch node.(type) {
+	case *ast.StructType:
+		return "struct"
+	case *ast.InterfaceType:
+		return "interface"
+	}
+	return ""
+}
+
 func uniqID(p token.Position) string {
 	return fmt.Sprintf("$%s%d", strippedFilename(p.Filename), p.Offset)
 }
@@ -95,8 +263,10 @@ func (g *Grapher) scopeLabel(s *types.Scope) (path []string) {
 		}
 		if f, ok := astPath[0].(*ast.FuncDecl); ok {
 			var path []string
-			if f.Recv != nil {
-				path = []string{derefNode(f.Recv.List[0].Type).(*ast.Ident).Name}
+			if f.Recv != nil && len(f.Recv.List) > 0 {
+				if name := methodRecvTypeName(f.Recv.List[0].Type); name != "" {
+					path = []string{name}
+				}
 			}
 			var uniqName string
 			if f.Name.Name == "init" {
@@ -138,6 +308,104 @@ func strippedFilename(filename string) string {
 func (g *Grapher) assignPathsInPackage(pkgInfo *loader.PackageInfo) {
 	pkg := pkgInfo.Pkg
 	g.assignPaths(pkg.Scope(), []string{}, true)
+	g.assignAnonMemberPaths(pkg)
+}
+
+// assignAnonMemberPaths assigns paths to the members of the anonymous
+// struct and interface types in the types of pkg's package-level
+// declarations (such as the elements of []struct{ N int }), which
+// don't already have paths. The path is the declaration's path followed
+// by "struct$N" or "interface$N" and the member's name, such as
+// T/struct$1/N.
+//
+// The paths are computed from pkg's types only, so they're the same
+// whether pkg was loaded from source or imported from export data (in
+// which case it has no AST). Otherwise refs from selectors (such as
+// x.Items[0].N) in other packages to those members wouldn't refer to
+// the defs that are emitted when pkg is graphed. For the same reason,
+// the type literals are numbered separately for each name (such as each
+// type in a type (...) group, or each var in var a, b struct{ ... }) by
+// walking its type depth-first, with struct fields in source order and
+// interface methods in the order that go/types sorts them in.
+func (g *Grapher) assignAnonMemberPaths(pkg *types.Package) {
+	walkDecl := func(prefix []string, t types.Type) {
+		counts := map[string]int{}
+		var walk func(t types.Type)
+		assign := func(obj types.Object, lit string) {
+			if obj.Pkg() != pkg {
+				return
+			}
+			if obj.Pos() != token.NoPos && !containsObject(g.anonMembers[obj.Pos()], obj) {
+				g.anonMembers[obj.Pos()] = append(g.anonMembers[obj.Pos()], obj)
+			}
+			if _, present := g.paths[obj]; !present {
+				g.paths[obj] = append(append([]string{}, prefix...), lit, obj.Name())
+			}
+		}
+		walk = func(t types.Type) {
+			switch t := t.(type) {
+			case *types.Struct:
+				lit := fmt.Sprintf("struct$%d", counts["struct"])
+				counts["struct"]++
+				for i := 0; i < t.NumFields(); i++ {
+					assign(t.Field(i), lit)
+					walk(t.Field(i).Type())
+				}
+			case *types.Interface:
+				lit := fmt.Sprintf("interface$%d", counts["interface"])
+				counts["interface"]++
+				for i := 0; i < t.NumExplicitMethods(); i++ {
+					assign(t.ExplicitMethod(i), lit)
+					walk(t.ExplicitMethod(i).Type())
+				}
+			case *types.Pointer:
+				walk(t.Elem())
+			case *types.Slice:
+				walk(t.Elem())
+			case *types.Array:
+				walk(t.Elem())
+			case *types.Chan:
+				walk(t.Elem())
+			case *types.Map:
+				walk(t.Key())
+				walk(t.Elem())
+			case *types.Signature:
+				for _, tuple := range []*types.Tuple{t.Params(), t.Results()} {
+					for i := 0; i < tuple.Len(); i++ {
+						walk(tuple.At(i).Type())
+					}
+				}
+			}
+		}
+		walk(t)
+	}
+
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		switch obj := scope.Lookup(name).(type) {
+		case *types.TypeName:
+			named, ok := obj.Type().(*types.Named)
+			if !ok {
+				continue
+			}
+			walkDecl([]string{name}, named.Underlying())
+			for i := 0; i < named.NumMethods(); i++ {
+				m := named.Method(i)
+				walkDecl([]string{name, m.Name()}, m.Type())
+			}
+		case *types.Var, *types.Func:
+			walkDecl([]string{name}, obj.Type())
+		}
+	}
+}
+
+func containsObject(objs []types.Object, obj types.Object) bool {
+	for _, o := range objs {
+		if o == obj {
+			return true
+		}
+	}
+	return false
 }
 
 func (g *Grapher) assignPaths(s *types.Scope, prefix []string, pkgscope bool) {
@@ -154,9 +422,11 @@ func (g *Grapher) assignPaths(s *types.Scope, prefix []string, pkgscope bool) {
 		g.pkgscope[e] = pkgscope
 
 		if tn, ok := e.(*types.TypeName); ok {
-			// methods
-			named := tn.Type().(*types.Named)
-			g.assignMethodPaths(named, path, pkgscope)
+			// methods (unsafe.Pointer, which may be dot-imported into a
+			// file scope, is not a named type)
+			if named, ok := tn.Type().(*types.Named); ok {
+				g.assignMethodPaths(named, path, pkgscope)
+			}
 
 			// struct fields
 			typ := derefType(tn.Type().Underlying())
@@ -215,7 +485,7 @@ func (g *Grapher) assignMethodPaths(named *types.Named, prefix []string, pkgscop
 
 	if iface, ok := named.Underlying().(*types.Interface); ok {
 		for i := 0; i < iface.NumExplicitMethods(); i++ {
-			m := iface.Method(i)
+			m := iface.ExplicitMethod(i)
 			path := append(append([]string{}, prefix...), m.Name())
 			g.paths[m] = path
 
@@ -0,0 +1,81 @@
+package gog
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// A Snippet is the source code around a ref: the line that the ref
+// starts on, or (if the line is long) part of it.
+type Snippet struct {
+	// Text is the source code. It contains whole UTF-8 characters and
+	// is at most MaxSnippetLen bytes long. (If the file isn't valid
+	// UTF-8, Text may contain invalid bytes, which are encoded as
+	// U+FFFD in JSON.)
+	Text string
+
+	// Start is the byte offset in the file of the beginning of Text.
+	Start int
+}
+
+const (
+	// MaxSnippetLen is the maximum length in bytes of a Snippet's Text.
+	MaxSnippetLen = 160
+
+	// snippetContext is how many bytes before a ref in a long line
+	// are included in its snippet.
+	snippetContext = 40
+)
+
+// AddRefSnippets sets the Snippet of each of the Output's refs, reading
+// each file once. Refs in files that can't be read are left without
+// snippets.
+func (g *Grapher) AddRefSnippets() {
+	srcs := map[string][]byte{}
+	for _, ref := range g.Refs {
+		src, read := srcs[ref.File]
+		if !read {
+			src, _ = readFile(ref.File)
+			srcs[ref.File] = src
+		}
+		if src != nil {
+			ref.Snippet = snippet(src, ref.Span)
+		}
+	}
+}
+
+// snippet returns the snippet of src around the span [start, end): the
+// line that contains start, truncated to MaxSnippetLen bytes (beginning
+// at most snippetContext bytes before start) if it's longer.
+func snippet(src []byte, span [2]int) *Snippet {
+	if span[0] < 0 || span[0] > len(src) {
+		return nil
+	}
+	lineStart := bytes.LastIndexByte(src[:span[0]], '\n') + 1
+	lineEnd := len(src)
+	if i := bytes.IndexByte(src[span[0]:], '\n'); i != -1 {
+		lineEnd = span[0] + i
+	}
+	if lineEnd > lineStart && src[lineEnd-1] == '\r' {
+		lineEnd--
+	}
+
+	start, end := lineStart, lineEnd
+	if end-start > MaxSnippetLen {
+		if span[0]-snippetContext > start {
+			start = span[0] - snippetContext
+		}
+		if end-start > MaxSnippetLen {
+			end = start + MaxSnippetLen
+		}
+	}
+
+	// Don't split UTF-8 characters.
+	for start < end && !utf8.RuneStart(src[start]) {
+		start++
+	}
+	for end < lineEnd && end > start && !utf8.RuneStart(src[end]) {
+		end--
+	}
+	return &Snippet{Text: string(src[start:end]), Start: start}
+}
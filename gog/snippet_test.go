@@ -0,0 +1,60 @@
+package gog
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAddRefSnippets(t *testing.T) {
+	src := "package foo\r\n\r\nvar x int\r\n\r\nvar _ = x\r\n"
+	prog, cleanup := createPkgInTempDir(t, "foo", map[string]string{"foo.go": src})
+	defer cleanup()
+	g := New(prog)
+	g.SkipDocs = true
+	if err := g.Graph(prog.Created[0]); err != nil {
+		t.Fatal(err)
+	}
+	g.AddRefSnippets()
+
+	want := map[int]Snippet{
+		strings.Index(src, "x int"): {Text: "var x int", Start: strings.Index(src, "var x")},
+		strings.LastIndex(src, "x"): {Text: "var _ = x", Start: strings.Index(src, "var _")},
+	}
+	for _, ref := range g.Refs {
+		w, present := want[ref.Span[0]]
+		if !present {
+			continue
+		}
+		delete(want, ref.Span[0])
+		if ref.Snippet == nil {
+			t.Errorf("ref at %v: got no snippet, want %+v", ref.Span, w)
+		} else if *ref.Snippet != w {
+			t.Errorf("ref at %v: got snippet %+v, want %+v", ref.Span, *ref.Snippet, w)
+		}
+	}
+	for start := range want {
+		t.Errorf("no ref at offset %d", start)
+	}
+}
+
+func TestSnippet_long(t *testing.T) {
+	src := "var s = \"" + strings.Repeat("é", 200) + "\" + x + \"" + strings.Repeat("é", 200) + "\""
+	start := strings.Index(src, "x")
+	s := snippet([]byte(src), [2]int{start, start + 1})
+	if s == nil {
+		t.Fatal("got no snippet")
+	}
+	if len(s.Text) > MaxSnippetLen {
+		t.Errorf("got snippet of %d bytes, want at most %d", len(s.Text), MaxSnippetLen)
+	}
+	if !utf8.ValidString(s.Text) {
+		t.Errorf("got snippet %q, which is not valid UTF-8", s.Text)
+	}
+	if !strings.Contains(s.Text, "\" + x + \"") {
+		t.Errorf("got snippet %q, which doesn't contain the ref", s.Text)
+	}
+	if src[s.Start:s.Start+len(s.Text)] != s.Text {
+		t.Errorf("got snippet start %d, which doesn't match its text", s.Start)
+	}
+}
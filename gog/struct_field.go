@@ -1,6 +1,11 @@
 package gog
 
 import (
+	"strconv"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
+
 	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/types"
 )
@@ -45,3 +50,62 @@ func (g *Grapher) buildStructFields(pkgInfo *loader.PackageInfo) {
 		}
 	}
 }
+
+// parseStructTag parses a struct field tag into its key/value pairs,
+// using the same syntax that reflect.StructTag.Get accepts. It returns
+// false if the tag is not in the conventional format.
+func parseStructTag(tag string) (map[string]definfo.StructTagValue, bool) {
+	tags := make(map[string]definfo.StructTagValue)
+	for tag != "" {
+		// skip leading space
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+
+		// scan to colon; a space, a quote or a control character is a
+		// syntax error
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, false
+		}
+		name := tag[:i]
+		tag = tag[i+1:]
+
+		// scan quoted string to find value
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, false
+		}
+		qvalue := tag[:i+1]
+		tag = tag[i+1:]
+
+		value, err := strconv.Unquote(qvalue)
+		if err != nil {
+			return nil, false
+		}
+		parts := strings.Split(value, ",")
+		tv := definfo.StructTagValue{Name: parts[0]}
+		if len(parts) > 1 {
+			tv.Options = parts[1:]
+		}
+		tags[name] = tv
+	}
+	if len(tags) == 0 {
+		return nil, false
+	}
+	return tags, true
+}
@@ -4,6 +4,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
 )
 
 func TestResolveStructFields(t *testing.T) {
@@ -46,6 +48,30 @@ func TestResolveStructFields(t *testing.T) {
 			ref:       `b.A`,
 			wantRefs:  []*DefKey{{PackageImportPath: "foo", Path: []string{"B", "A"}}},
 		},
+		"field in embedded pointer struct ref": {
+			pkgDefs:   `type A struct {a string};type B struct { *A };`,
+			localDefs: `var b B;`,
+			ref:       `b.a`,
+			wantRefs:  []*DefKey{{PackageImportPath: "foo", Path: []string{"A", "a"}}},
+		},
+		"field in multi-level embedded struct ref": {
+			pkgDefs:   `type A struct {a string};type B struct { A };type C struct { *B };`,
+			localDefs: `var c C;`,
+			ref:       `c.a`,
+			wantRefs:  []*DefKey{{PackageImportPath: "foo", Path: []string{"A", "a"}}},
+		},
+		"method selector ref": {
+			pkgDefs:   `type A struct {};func (A) m() {};`,
+			localDefs: `var a A;`,
+			ref:       `a.m`,
+			wantRefs:  []*DefKey{{PackageImportPath: "foo", Path: []string{"A", "m"}}},
+		},
+		"promoted method selector ref": {
+			pkgDefs:   `type A struct {};func (*A) m() {};type B struct { *A };`,
+			localDefs: `var b B;`,
+			ref:       `b.m`,
+			wantRefs:  []*DefKey{{PackageImportPath: "foo", Path: []string{"A", "m"}}},
+		},
 
 		"local: basic struct field ref": {
 			pkgDefs:   ``,
@@ -55,8 +81,10 @@ func TestResolveStructFields(t *testing.T) {
 		},
 
 		"anonymous struct field ref": {
-			ref:      `(struct{x int}{}).x`,
-			wantRefs: []*DefKey{{PackageImportPath: "foo", Path: []string{"x$sources[0]47"}}},
+			ref: `(struct{x int}{}).x`,
+			// The field is numbered by its type literal's position in
+			// the declaration (the blank var that the ref is in).
+			wantRefs: []*DefKey{{PackageImportPath: "foo", Path: []string{"_$sources[0]19", "struct$0", "x"}}},
 		},
 
 		"stdlib struct field ref": {
@@ -112,3 +140,60 @@ func TestResolveStructFields(t *testing.T) {
 		}
 	}
 }
+
+func TestStructFieldTags(t *testing.T) {
+	cases := map[string]struct {
+		field    string
+		wantRaw  string
+		wantTags map[string]definfo.StructTagValue
+	}{
+		"no tag": {
+			field: "X int",
+		},
+		"json and db tags": {
+			field:   "X int `json:\"x,omitempty\" db:\"x_col\"`",
+			wantRaw: `json:"x,omitempty" db:"x_col"`,
+			wantTags: map[string]definfo.StructTagValue{
+				"json": {Name: "x", Options: []string{"omitempty"}},
+				"db":   {Name: "x_col"},
+			},
+		},
+		"escaped quote": {
+			field:    "X int `k:\"a\\\"b\"`",
+			wantRaw:  `k:"a\"b"`,
+			wantTags: map[string]definfo.StructTagValue{"k": {Name: `a"b`}},
+		},
+		"malformed tag is stored raw": {
+			field:   "X int `not a tag`",
+			wantRaw: "not a tag",
+		},
+	}
+
+	for label, c := range cases {
+		src := "package foo; type A struct { " + c.field + " }"
+		prog := createPkg(t, "foo", []string{src}, nil)
+
+		g := New(prog)
+		g.SkipDocs = true
+		if err := g.Graph(prog.Created[0]); err != nil {
+			t.Fatal(label, err)
+		}
+
+		var field *Def
+		for _, d := range g.Defs {
+			if d.Kind == definfo.Field {
+				field = d
+			}
+		}
+		if field == nil {
+			t.Errorf("%s: no field def found", label)
+			continue
+		}
+		if field.FieldTag != c.wantRaw {
+			t.Errorf("%s: got FieldTag %q, want %q", label, field.FieldTag, c.wantRaw)
+		}
+		if !reflect.DeepEqual(field.FieldTags, c.wantTags) {
+			t.Errorf("%s: got FieldTags %+v, want %+v", label, field.FieldTags, c.wantTags)
+		}
+	}
+}
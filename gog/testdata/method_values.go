@@ -0,0 +1,25 @@
+package testdata
+
+type MethodValuer interface {
+	Value() int
+}
+
+type Valued struct{}
+
+func (Valued) Value() int { return 0 }
+
+func (*Valued) PtrValue() int { return 0 }
+
+type EmbedsValued struct {
+	*Valued
+}
+
+func useMethodValues(v Valued, i MethodValuer, e EmbedsValued) {
+	_ = v.Value
+	_ = Valued.Value
+	_ = (*Valued).PtrValue
+	_ = i.Value
+	_ = MethodValuer.Value
+	_ = e.PtrValue
+	_ = (v.Value)()
+}
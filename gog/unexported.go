@@ -0,0 +1,169 @@
+package gog
+
+import (
+	"go/ast"
+
+	"golang.org/x/tools/go/types"
+)
+
+// OmitUnexported removes the defs of unexported identifiers (and of
+// local identifiers, such as params and local vars), and the refs,
+// docs, implementations, examples, calls, and control flows that
+// involve them, from the Output. It must be called after all packages
+// are graphed, so that their unexported defs are still used to resolve
+// refs and compute relationships.
+//
+// A def is exported if it's a package-level def with an exported name
+// or an exported method or field of an exported type. If
+// keepReturnedTypes is true, the exported methods and fields of
+// unexported types that exported funcs and methods return (such as the
+// *t that func New() *t returns) are also kept, because they are
+// usable outside of the package.
+func (g *Grapher) OmitUnexported(keepReturnedTypes bool) {
+	keptTypes := map[string]map[string]bool{} // import path -> type name -> kept
+	if keepReturnedTypes {
+		for _, def := range g.Defs {
+			if _, present := keptTypes[def.PackageImportPath]; present {
+				continue
+			}
+			keptTypes[def.PackageImportPath] = map[string]bool{}
+			for pkg := range g.program.AllPackages {
+				if pkg.Path() == def.PackageImportPath {
+					keptTypes[pkg.Path()] = returnedUnexportedTypes(pkg)
+				}
+			}
+		}
+	}
+	isExportedPath := func(key *DefKey) bool {
+		path := key.Path
+		if len(path) >= 2 && keptTypes[key.PackageImportPath][path[0]] {
+			path = path[1:]
+		}
+		for _, c := range path {
+			if !ast.IsExported(c) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Decide using the emitted defs where possible, because they also
+	// record whether they're local. Defs in other packages can only be
+	// referred to if they're package-level.
+	exported := map[string]bool{}
+	defs := g.Defs[:0]
+	for _, def := range g.Defs {
+		if exp := (def.PkgScope || len(def.Path) == 0) && isExportedPath(def.DefKey); exp {
+			defs = append(defs, def)
+			exported[def.DefKey.String()] = true
+		} else {
+			exported[def.DefKey.String()] = false
+			if g.defs[def.DefKey.String()] == def {
+				delete(g.defs, def.DefKey.String())
+			}
+		}
+	}
+	g.Defs = defs
+	isExported := func(key *DefKey) bool {
+		if exp, present := exported[key.String()]; present {
+			return exp
+		}
+		return isExportedPath(key)
+	}
+
+	refs := g.Refs[:0]
+	for _, ref := range g.Refs {
+		if isExported(ref.Def) {
+			refs = append(refs, ref)
+		} else {
+			delete(g.refs, refKey{file: ref.File, span: ref.Span, def: ref.Def.String()})
+		}
+	}
+	g.Refs = refs
+
+	docs := g.Docs[:0]
+	for _, doc := range g.Docs {
+		if isExported(doc.DefKey) {
+			docs = append(docs, doc)
+		}
+	}
+	g.Docs = docs
+
+	impls := g.Implementations[:0]
+	for _, impl := range g.Implementations {
+		if isExported(impl.Type) && isExported(impl.Interface) {
+			impls = append(impls, impl)
+		}
+	}
+	g.Implementations = impls
+
+	examples := g.Examples[:0]
+	for _, ex := range g.Examples {
+		if isExported(ex.Subject) {
+			examples = append(examples, ex)
+		}
+	}
+	g.Examples = examples
+
+	calls := g.Calls[:0]
+	for _, call := range g.Calls {
+		if isExported(call.Caller) && isExported(call.Callee) {
+			calls = append(calls, call)
+		}
+	}
+	g.Calls = calls
+
+	flows := g.ControlFlows[:0]
+	for _, flow := range g.ControlFlows {
+		if isExported(flow.Func) && (flow.Channel == nil || isExported(flow.Channel)) {
+			flows = append(flows, flow)
+		}
+	}
+	g.ControlFlows = flows
+}
+
+// returnedUnexportedTypes returns the names of the unexported named
+// types in pkg that are returned (possibly as pointers) by pkg's
+// exported funcs, by the exported methods of its exported types, or
+// (transitively) by the exported methods of such unexported types.
+func returnedUnexportedTypes(pkg *types.Package) map[string]bool {
+	kept := map[string]bool{}
+	var queue []*types.Named
+	addResults := func(sig *types.Signature) {
+		for i := 0; i < sig.Results().Len(); i++ {
+			named, ok := derefType(sig.Results().At(i).Type()).(*types.Named)
+			if !ok || named.Obj().Pkg() != pkg || named.Obj().Exported() || kept[named.Obj().Name()] {
+				continue
+			}
+			kept[named.Obj().Name()] = true
+			queue = append(queue, named)
+		}
+	}
+	addMethods := func(named *types.Named) {
+		for i := 0; i < named.NumMethods(); i++ {
+			if m := named.Method(i); m.Exported() {
+				addResults(m.Type().(*types.Signature))
+			}
+		}
+	}
+
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		switch obj := scope.Lookup(name).(type) {
+		case *types.Func:
+			if obj.Exported() {
+				addResults(obj.Type().(*types.Signature))
+			}
+		case *types.TypeName:
+			if named, ok := obj.Type().(*types.Named); ok && obj.Exported() {
+				addMethods(named)
+			}
+		}
+	}
+	for len(queue) > 0 {
+		named := queue[0]
+		queue = queue[1:]
+		addMethods(named)
+	}
+	return kept
+}
@@ -0,0 +1,69 @@
+package gog
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOmitUnexported(t *testing.T) {
+	src := `package foo
+
+type T struct{ F, f int }
+
+func (T) M() *t { return nil }
+func (T) m()    {}
+
+type t struct{ G int }
+
+func (*t) N() u { return u{} }
+func (*t) n()   {}
+
+type u struct{}
+
+func (u) O() {}
+
+type v struct{}
+
+func (v) P() {}
+
+func New(x int) T { var y int; _ = y; return T{} }
+
+func helper() {}
+
+var _ = helper
+`
+	tests := map[bool][]string{
+		false: {"", "New", "T", "T/F", "T/M"},
+		true:  {"", "New", "T", "T/F", "T/M", "t/G", "t/N", "u/O"},
+	}
+	for keepReturnedTypes, want := range tests {
+		prog := createPkg(t, "foo", []string{src}, nil)
+		g := New(prog)
+		g.SkipDocs = true
+		if err := g.Graph(prog.Created[0]); err != nil {
+			t.Fatal(err)
+		}
+		g.OmitUnexported(keepReturnedTypes)
+
+		var defs []string
+		for _, d := range g.Defs {
+			defs = append(defs, strings.Join(d.Path, "/"))
+		}
+		sort.Strings(defs)
+		if !reflect.DeepEqual(defs, want) {
+			t.Errorf("keepReturnedTypes=%v: got defs %v, want %v", keepReturnedTypes, defs, want)
+		}
+
+		kept := map[string]bool{}
+		for _, p := range want {
+			kept[p] = true
+		}
+		for _, r := range g.Refs {
+			if p := strings.Join(r.Def.Path, "/"); r.Def.PackageImportPath == "foo" && !kept[p] {
+				t.Errorf("keepReturnedTypes=%v: got ref to omitted def %s at %v", keepReturnedTypes, p, r.Span)
+			}
+		}
+	}
+}
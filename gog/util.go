@@ -6,6 +6,7 @@ import (
 	"go/printer"
 	"go/token"
 	"sort"
+	"strings"
 
 	"golang.org/x/tools/go/types"
 )
@@ -21,6 +22,11 @@ func prettyPrint(n ast.Node) string {
 	}
 }
 
+// isTestFile reports whether filename is a Go test file.
+func isTestFile(filename string) bool {
+	return strings.HasSuffix(filename, "_test.go")
+}
+
 // Sort AST package files so that the result does not depend on map iteration order.
 func sortedFiles(m map[string]*ast.File) []*ast.File {
 	keylist := make([]string, len(m))
@@ -58,7 +64,33 @@ func derefType(t types.Type) types.Type {
 	return t
 }
 
+// methodRecvTypeName returns the name of the receiver base type in the
+// receiver type expression recvType, such as T in *T, (T), or *List[T]
+// (with type parameters), or "" if recvType is malformed.
 func methodRecvTypeName(recvType ast.Expr) string {
-	recvType = derefNode(recvType)
-	return recvType.(*ast.Ident).Name
+	if ident := methodRecvTypeIdent(recvType); ident != nil {
+		return ident.Name
+	}
+	return ""
+}
+
+// methodRecvTypeIdent is like methodRecvTypeName but returns the ident
+// of the receiver base type.
+func methodRecvTypeIdent(recvType ast.Expr) *ast.Ident {
+	for {
+		switch e := recvType.(type) {
+		case *ast.Ident:
+			return e
+		case *ast.StarExpr:
+			recvType = e.X
+		case *ast.ParenExpr:
+			recvType = e.X
+		case *ast.IndexExpr:
+			recvType = e.X
+		case *ast.IndexListExpr:
+			recvType = e.X
+		default:
+			return nil
+		}
+	}
 }
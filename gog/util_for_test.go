@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"go/ast"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	"golang.org/x/tools/go/loader"
@@ -59,3 +62,26 @@ func createPkg(t *testing.T, path string, sources []string, names []string) *loa
 
 	return prog
 }
+
+// createPkgInTempDir writes the sources (a map of file names to
+// contents) to a temporary directory and creates a package from the
+// written files. Use this instead of createPkg when the grapher must
+// read the files from disk (e.g., to emit docs). The returned func
+// removes the temporary directory.
+func createPkgInTempDir(t *testing.T, path string, sources map[string]string) (*loader.Program, func()) {
+	dir, err := ioutil.TempDir("", "gog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filenames []string
+	for name, src := range sources {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(src), 0600); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return createPkgFromFiles(t, path, filenames), func() { os.RemoveAll(dir) }
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metaImport is a <meta name="go-import"> tag, which maps an import
+// path prefix to the VCS repository that provides it.
+type metaImport struct {
+	Prefix, VCS, RepoRoot string
+}
+
+var (
+	// metaImportCache maps import path prefixes to the (successfully
+	// discovered) meta import for each.
+	metaImportCache   = map[string]*metaImport{}
+	metaImportCacheMu sync.Mutex
+
+	// metaImportFailures maps hosts that couldn't be reached (such as
+	// because requests to them timed out) to the error, so that they
+	// aren't requested again for other import paths.
+	metaImportFailures = map[string]error{}
+
+	// goGetClient makes the HTTP requests to resolve imports. Its
+	// timeout bounds how long a resolution that is under way can take
+	// once the --timeout elapses (see DepResolveCmd).
+	goGetClient = &http.Client{Timeout: 15 * time.Second}
+)
+
+// discoverMetaImport performs go-get meta discovery for importPath:
+// it fetches https://<importPath>?go-get=1 and finds the go-import meta
+// tag whose prefix matches importPath. Results are cached by prefix, so
+// other packages in the same repository don't require another lookup,
+// and network failures are cached by host.
+func discoverMetaImport(importPath string) (*metaImport, error) {
+	host := importPath
+	if i := strings.Index(host, "/"); i != -1 {
+		host = host[:i]
+	}
+
+	metaImportCacheMu.Lock()
+	for prefix, mi := range metaImportCache {
+		if pathHasPrefix(importPath, prefix) {
+			metaImportCacheMu.Unlock()
+			return mi, nil
+		}
+	}
+	if err, failed := metaImportFailures[host]; failed {
+		metaImportCacheMu.Unlock()
+		return nil, err
+	}
+	metaImportCacheMu.Unlock()
+
+	start := time.Now()
+	resp, err := goGetClient.Get("https://" + importPath + "?go-get=1")
+	std.withDuration(start).debugf("Discovered go-import meta tag for %s", importPath)
+	if err != nil {
+		metaImportCacheMu.Lock()
+		metaImportFailures[host] = err
+		metaImportCacheMu.Unlock()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("go-get meta discovery for %q: HTTP %s", importPath, resp.Status)
+	}
+
+	imports, err := parseMetaGoImports(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("go-get meta discovery for %q: %s", importPath, err)
+	}
+	mi := matchMetaImport(imports, importPath)
+	if mi == nil {
+		return nil, fmt.Errorf("go-get meta discovery for %q: no matching go-import meta tag", importPath)
+	}
+
+	metaImportCacheMu.Lock()
+	metaImportCache[mi.Prefix] = mi
+	metaImportCacheMu.Unlock()
+	return mi, nil
+}
+
+// parseMetaGoImports returns the go-import meta tags in the HTML
+// document r. It stops reading at the end of the document's head.
+func parseMetaGoImports(r io.Reader) ([]metaImport, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var imports []metaImport
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			return imports, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return imports, nil
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return imports, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") || xmlAttr(e, "name") != "go-import" {
+			continue
+		}
+		if f := strings.Fields(xmlAttr(e, "content")); len(f) == 3 {
+			imports = append(imports, metaImport{Prefix: f[0], VCS: f[1], RepoRoot: f[2]})
+		}
+	}
+}
+
+// matchMetaImport returns the meta import in imports whose prefix
+// matches importPath. Meta imports for the "mod" pseudo-VCS (which
+// refer to module proxies, not repositories) are ignored.
+func matchMetaImport(imports []metaImport, importPath string) *metaImport {
+	var match *metaImport
+	for i, mi := range imports {
+		if mi.VCS == "mod" || !pathHasPrefix(importPath, mi.Prefix) {
+			continue
+		}
+		if match == nil || len(mi.Prefix) > len(match.Prefix) {
+			match = &imports[i]
+		}
+	}
+	return match
+}
+
+func xmlAttr(e xml.StartElement, name string) string {
+	for _, attr := range e.Attr {
+		if strings.EqualFold(attr.Name.Local, name) {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// isPrivateImportPath reports whether importPath matches one of the
+// comma-separated glob patterns in the GOPRIVATE, GONOPROXY, or
+// GONOSUMDB environment variables. Information about private import
+// paths must not be requested from public services (such as the
+// GitHub API).
+func isPrivateImportPath(importPath string) bool {
+	for _, env := range []string{"GOPRIVATE", "GONOPROXY", "GONOSUMDB"} {
+		if matchPathPrefixPatterns(os.Getenv(env), importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathPrefixPatterns reports whether any of the comma-separated
+// glob patterns matches a prefix of importPath. A pattern with N
+// path elements matches the first N path elements of importPath.
+func matchPathPrefixPatterns(patterns, importPath string) bool {
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSuffix(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		n := strings.Count(pattern, "/") + 1
+		prefix := importPath
+		if elems := strings.SplitN(importPath, "/", n+1); len(elems) > n {
+			prefix = strings.Join(elems[:n], "/")
+		}
+		if ok, _ := path.Match(pattern, prefix); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// guessRepoCloneURL guesses the clone URL of the repository that
+// contains importPath, assuming that its first 3 path components (host,
+// owner, and repository name) are the repository root. It is used when
+// the repository can't be discovered over the network.
+func guessRepoCloneURL(importPath string) string {
+	parts := strings.SplitN(importPath, "/", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	return "https://" + strings.Join(parts, "/")
+}
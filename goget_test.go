@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMetaGoImports(t *testing.T) {
+	tests := []struct {
+		html string
+		want []metaImport
+	}{
+		{
+			html: `<html><head><meta name="go-import" content="example.com/a git https://example.com/a.git"></head></html>`,
+			want: []metaImport{{Prefix: "example.com/a", VCS: "git", RepoRoot: "https://example.com/a.git"}},
+		},
+		{
+			// Multiple tags, unquoted and differently cased attributes,
+			// and tags that aren't go-import tags.
+			html: `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="go-source" content="example.com/a _ _ _">
+<META NAME="go-import" CONTENT="example.com/a git https://example.com/a.git">
+<meta name=go-import content="example.com/a/b hg https://example.com/b">
+<meta name="go-import" content="example.com/a mod https://proxy.example.com">
+</head>
+</html>`,
+			want: []metaImport{
+				{Prefix: "example.com/a", VCS: "git", RepoRoot: "https://example.com/a.git"},
+				{Prefix: "example.com/a/b", VCS: "hg", RepoRoot: "https://example.com/b"},
+				{Prefix: "example.com/a", VCS: "mod", RepoRoot: "https://proxy.example.com"},
+			},
+		},
+		{
+			// Only the head is read.
+			html: `<html><head><meta name="go-import" content="example.com/a git https://example.com/a"></head>
+<meta name="go-import" content="example.com/b git https://example.com/b">
+<body><meta name="go-import" content="example.com/c git https://example.com/c"></body></html>`,
+			want: []metaImport{{Prefix: "example.com/a", VCS: "git", RepoRoot: "https://example.com/a"}},
+		},
+		{
+			html: `<html><body><meta name="go-import" content="example.com/a git https://example.com/a"></body></html>`,
+			want: nil,
+		},
+		{
+			// Tags without exactly 3 fields are ignored.
+			html: `<meta name="go-import" content="example.com/a git"><meta name="go-import" content="example.com/b git https://example.com/b extra">`,
+			want: nil,
+		},
+		{
+			html: `<meta name="go-import" content="example.com/a git https://example.com/a?x=1&amp;y=2">`,
+			want: []metaImport{{Prefix: "example.com/a", VCS: "git", RepoRoot: "https://example.com/a?x=1&y=2"}},
+		},
+		{html: ``, want: nil},
+	}
+	for _, test := range tests {
+		got, err := parseMetaGoImports(strings.NewReader(test.html))
+		if err != nil {
+			t.Errorf("%q: %s", test.html, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.html, got, test.want)
+		}
+	}
+}
+
+func TestMatchMetaImport(t *testing.T) {
+	imports := []metaImport{
+		{Prefix: "example.com/a", VCS: "git", RepoRoot: "https://example.com/a"},
+		{Prefix: "example.com/a/b", VCS: "git", RepoRoot: "https://example.com/b"},
+		{Prefix: "example.com/m", VCS: "mod", RepoRoot: "https://proxy.example.com"},
+		{Prefix: "other.com/x", VCS: "git", RepoRoot: "https://other.com/x"},
+	}
+	tests := []struct {
+		importPath string
+		want       string // RepoRoot, or "" if there's no match
+	}{
+		{"example.com/a", "https://example.com/a"},
+		{"example.com/a/c", "https://example.com/a"},
+		{"example.com/a/b/c", "https://example.com/b"}, // the longest prefix
+		{"example.com/ab", ""},                         // not a path prefix
+		{"example.com/m/x", ""},                        // mod tags are ignored
+		{"example.com", ""},
+	}
+	for _, test := range tests {
+		var got string
+		if mi := matchMetaImport(imports, test.importPath); mi != nil {
+			got = mi.RepoRoot
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.importPath, got, test.want)
+		}
+	}
+}
+
+func TestMatchPathPrefixPatterns(t *testing.T) {
+	tests := []struct {
+		patterns, importPath string
+		want                 bool
+	}{
+		{"", "example.com/a", false},
+		{"example.com", "example.com/a/b", true},
+		{"example.com", "example.com", true},
+		{"example.com", "example.community/a", false},
+		{"example.com/a", "example.com/a/b", true},
+		{"example.com/a", "example.com/ab", false},
+		{"example.com/a/", "example.com/a/b", true},
+		{"*.example.com", "git.example.com/a", true},
+		{"*.example.com", "example.com/a", false},
+		{"example.com/*/b", "example.com/x/b/c", true},
+		{"example.com/*/b", "example.com/x/c", false},
+		{"example.com/*", "example.com", false},
+		{"other.com, example.com/a ,", "example.com/a/b", true},
+		{"other.com,example.com/b", "example.com/a/b", false},
+		{"example.com/[", "example.com/a", false}, // malformed patterns don't match
+	}
+	for _, test := range tests {
+		if got := matchPathPrefixPatterns(test.patterns, test.importPath); got != test.want {
+			t.Errorf("patterns %q, import path %q: got %v, want %v", test.patterns, test.importPath, got, test.want)
+		}
+	}
+}
+
+func TestIsPrivateImportPath(t *testing.T) {
+	defer setenv("GOPRIVATE", "private.example.com")()
+	defer setenv("GONOPROXY", "noproxy.example.com/a")()
+	defer setenv("GONOSUMDB", "")()
+	tests := map[string]bool{
+		"private.example.com/x":   true,
+		"noproxy.example.com/a/b": true,
+		"noproxy.example.com/b":   false,
+		"example.com/x":           false,
+	}
+	for importPath, want := range tests {
+		if got := isPrivateImportPath(importPath); got != want {
+			t.Errorf("%s: got %v, want %v", importPath, got, want)
+		}
+	}
+}
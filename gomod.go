@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+)
+
+// goMod is the parsed contents of a go.mod file.
+type goMod struct {
+	// Module is the module path of the main module.
+	Module string
+
+	// Go is the Go language version from the go directive, if any.
+	Go string
+
+	Require []modVersion
+	Exclude []modVersion
+	Replace []modReplace
+}
+
+// modVersion is a module path and (possibly empty) version.
+type modVersion struct {
+	Path    string
+	Version string
+}
+
+// modReplace is a replace directive. If Old.Version is empty, all
+// versions of Old.Path are replaced. If New.Version is empty, New.Path
+// is a filesystem path.
+type modReplace struct {
+	Old modVersion
+	New modVersion
+}
+
+var (
+	goModCache     *goMod
+	goModCacheErr  error
+	goModCacheOnce sync.Once
+)
+
+// goModule returns the parsed go.mod file at the root of the
+// repository, or nil if there is none.
+func goModule() (*goMod, error) {
+	goModCacheOnce.Do(func() {
+		data, err := ioutil.ReadFile(filepath.Join(cwd, "go.mod"))
+		if os.IsNotExist(err) {
+			return
+		} else if err != nil {
+			goModCacheErr = err
+			return
+		}
+		goModCache, goModCacheErr = parseGoMod(data)
+	})
+	return goModCache, goModCacheErr
+}
+
+// parseGoMod parses the module, go, require, exclude, and replace
+// directives in a go.mod file. Other directives are ignored.
+func parseGoMod(data []byte) (*goMod, error) {
+	var mod goMod
+	var block string // verb of the enclosing "verb (" block, if any
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			fields = append([]string{block}, fields...)
+		} else if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+
+		for i, f := range fields {
+			if uq, err := strconv.Unquote(f); err == nil {
+				fields[i] = uq
+			}
+		}
+
+		verb, args := fields[0], fields[1:]
+		errorf := func(format string, a ...interface{}) error {
+			return fmt.Errorf("go.mod:%d: %s: %s", lineno, verb, fmt.Sprintf(format, a...))
+		}
+		switch verb {
+		case "module":
+			if len(args) != 1 {
+				return nil, errorf("expected module path")
+			}
+			mod.Module = args[0]
+		case "go":
+			if len(args) != 1 {
+				return nil, errorf("expected Go version")
+			}
+			mod.Go = args[0]
+		case "require", "exclude":
+			if len(args) != 2 {
+				return nil, errorf("expected module path and version")
+			}
+			mv := modVersion{Path: args[0], Version: args[1]}
+			if verb == "require" {
+				mod.Require = append(mod.Require, mv)
+			} else {
+				mod.Exclude = append(mod.Exclude, mv)
+			}
+		case "replace":
+			var r modReplace
+			switch {
+			case len(args) == 3 && args[1] == "=>":
+				r = modReplace{Old: modVersion{Path: args[0]}, New: modVersion{Path: args[2]}}
+			case len(args) == 4 && args[1] == "=>":
+				r = modReplace{Old: modVersion{Path: args[0]}, New: modVersion{Path: args[2], Version: args[3]}}
+			case len(args) == 4 && args[2] == "=>":
+				r = modReplace{Old: modVersion{Path: args[0], Version: args[1]}, New: modVersion{Path: args[3]}}
+			case len(args) == 5 && args[2] == "=>":
+				r = modReplace{Old: modVersion{Path: args[0], Version: args[1]}, New: modVersion{Path: args[3], Version: args[4]}}
+			default:
+				return nil, errorf("expected 'old [version] => new [version]'")
+			}
+			mod.Replace = append(mod.Replace, r)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return &mod, nil
+}
+
+// requirement returns the required module that provides the package
+// importPath. If more than one required module path is a prefix of
+// importPath, the longest one is used.
+func (m *goMod) requirement(importPath string) *modVersion {
+	var req *modVersion
+	for i, r := range m.Require {
+		if pathHasPrefix(importPath, r.Path) && (req == nil || len(r.Path) > len(req.Path)) {
+			req = &m.Require[i]
+		}
+	}
+	return req
+}
+
+// replacement returns the replace directive that applies to mv, or
+// nil if there is none. A directive for the specific version takes
+// precedence over one for all versions.
+func (m *goMod) replacement(mv modVersion) *modReplace {
+	var repl *modReplace
+	for i, r := range m.Replace {
+		if r.Old.Path != mv.Path {
+			continue
+		}
+		if r.Old.Version == mv.Version {
+			return &m.Replace[i]
+		}
+		if r.Old.Version == "" {
+			repl = &m.Replace[i]
+		}
+	}
+	return repl
+}
+
+func (m *goMod) excluded(mv modVersion) bool {
+	for _, x := range m.Exclude {
+		if x == mv {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve resolves importPath using the module's requirements and
+// replacements. It returns nil if importPath is not provided by the
+// main module or any required module.
+func (m *goMod) resolve(importPath string) (*dep.ResolvedTarget, error) {
+	if m.Module != "" && pathHasPrefix(importPath, m.Module) {
+		return &dep.ResolvedTarget{
+			// empty ToRepoCloneURL to indicate it's from this repository
+			ToRepoCloneURL: "",
+			ToUnit:         importPath,
+			ToUnitType:     "GoPackage",
+		}, nil
+	}
+
+	req := m.requirement(importPath)
+	if req == nil {
+		return m.resolveUnrequired(importPath)
+	}
+
+	target := *req
+	if r := m.replacement(*req); r != nil {
+		if r.New.Version == "" {
+			return m.resolveLocalReplacement(importPath, req.Path, r.New.Path)
+		}
+		target = r.New
+	}
+	if m.excluded(target) {
+		// The go command selects the next higher version that is not
+		// excluded, which we can only determine from a module proxy.
+		if v, err := m.nextVersion(target); err == nil && v != "" {
+			target.Version = v
+		} else {
+			warnf("version %s of module %s (required for import %q) is excluded in go.mod; omitting version.", target.Version, target.Path, importPath)
+			target.Version = ""
+		}
+	}
+
+	// The import path of the package in the target module.
+	unitPath := target.Path + strings.TrimPrefix(importPath, req.Path)
+
+	cloneURL, err := moduleRepoCloneURL(target)
+	if err != nil || cloneURL == "" {
+		return nil, err
+	}
+	return &dep.ResolvedTarget{
+		ToRepoCloneURL:  cloneURL,
+		ToVersionString: target.Version,
+		ToRevSpec:       modVersionRev(target.Version),
+		ToUnit:          unitPath,
+		ToUnitType:      "GoPackage",
+	}, nil
+}
+
+// resolveLocalReplacement resolves importPath, which is provided by the
+// module modPath that is replaced by the directory dir (relative to the
+// repository root if not absolute), to the source unit in dir. It
+// returns an error if dir is outside of the repository, because the
+// replacement's source can't be referred to (and resolving importPath
+// to the original module would be wrong).
+func (m *goMod) resolveLocalReplacement(importPath, modPath, dir string) (*dep.ResolvedTarget, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cwd, dir)
+	}
+	dir = filepath.Clean(dir)
+	if !pathHasPrefix(dir, cwd) {
+		return nil, fmt.Errorf("module %s (required for import %q) is replaced by directory %s, which is outside of the repository", modPath, importPath, dir)
+	}
+
+	// The packages in dir are named using the module path in its go.mod
+	// file, which need not be the path of the module that it replaces.
+	unitModPath := modPath
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		if mod, err := parseGoMod(data); err == nil && mod.Module != "" {
+			unitModPath = mod.Module
+		}
+	}
+	return &dep.ResolvedTarget{
+		// empty ToRepoCloneURL to indicate it's from this repository
+		ToRepoCloneURL: "",
+		ToUnit:         unitModPath + strings.TrimPrefix(importPath, modPath),
+		ToUnitType:     "GoPackage",
+	}, nil
+}
+
+// resolveUnrequired resolves importPath, which is not provided by any
+// module required in go.mod, to the latest version of the module that
+// provides it (according to GOPROXY). It returns nil if there is no
+// such module or GOPROXY can't be used.
+func (m *goMod) resolveUnrequired(importPath string) (*dep.ResolvedTarget, error) {
+	modPath, info, err := proxyModuleForImport(importPath)
+	if err == errProxyOff {
+		// Fetching module information is disallowed, so don't contact
+		// the origin repository either.
+		return &dep.ResolvedTarget{
+			ToRepoCloneURL: guessRepoCloneURL(importPath),
+			ToUnit:         importPath,
+			ToUnitType:     "GoPackage",
+		}, nil
+	}
+	if err != nil {
+		if err != errProxyDirect && err != errProxyNotFound {
+			warnf("unable to find module for import %q using GOPROXY: %s.", importPath, err)
+		}
+		return nil, nil
+	}
+
+	target := modVersion{Path: modPath, Version: info.Version}
+	cloneURL, err := moduleRepoCloneURL(target)
+	if err != nil || cloneURL == "" {
+		return nil, err
+	}
+	return &dep.ResolvedTarget{
+		ToRepoCloneURL:  cloneURL,
+		ToVersionString: target.Version,
+		ToRevSpec:       modVersionRev(target.Version),
+		ToUnit:          importPath,
+		ToUnitType:      "GoPackage",
+	}, nil
+}
+
+// nextVersion returns the lowest version of mv.Path (according to
+// GOPROXY) that is higher than mv.Version and not excluded, or the
+// empty string if there is none.
+func (m *goMod) nextVersion(mv modVersion) (string, error) {
+	versions, err := proxyVersions(mv.Path)
+	if err != nil {
+		return "", err
+	}
+	var next string
+	for _, v := range versions {
+		if compareSemver(v, mv.Version) <= 0 || m.excluded(modVersion{Path: mv.Path, Version: v}) {
+			continue
+		}
+		if next == "" || compareSemver(v, next) < 0 {
+			next = v
+		}
+	}
+	return next, nil
+}
+
+// moduleRepoCloneURL returns the clone URL of the repository that
+// provides the module version mv. It uses the origin reported by
+// GOPROXY, if any, so that the origin repository need not be contacted.
+func moduleRepoCloneURL(mv modVersion) (string, error) {
+	if mv.Version != "" {
+		if info, err := proxyVersionInfo(mv.Path, mv.Version); err == nil && info.Origin != nil && info.Origin.URL != "" {
+			return info.Origin.URL, nil
+		} else if err == errProxyOff {
+			return guessRepoCloneURL(mv.Path), nil
+		}
+	}
+
+	rt, err := resolveRemoteDep(mv.Path)
+	if err != nil || rt == nil {
+		return "", err
+	}
+	return rt.ToRepoCloneURL, nil
+}
+
+// modVersionRev returns the VCS revision identified by the module
+// version v: the commit ID for pseudo-versions (such as
+// v0.0.0-20150101120000-abcdef123456), and the tag name otherwise.
+func modVersionRev(v string) string {
+	v = strings.TrimSuffix(v, "+incompatible")
+	if parts := strings.Split(v, "-"); len(parts) >= 3 {
+		if rev := parts[len(parts)-1]; len(rev) == 12 {
+			return rev
+		}
+	}
+	return v
+}
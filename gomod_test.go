@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+)
+
+func TestGoModResolve(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-gomod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(tmp, "repo")
+
+	// Don't contact GOPROXY; clone URLs are guessed from module paths.
+	origNoNetwork := resolveOpt.NoNetwork
+	resolveOpt.NoNetwork = true
+	defer func() { resolveOpt.NoNetwork = origNoNetwork }()
+
+	mod, err := parseGoMod([]byte(`module example.com/repo
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.1.0
+	example.com/c v0.1.0
+	example.com/c/v2 v2.0.0
+	example.com/local v1.0.0
+	example.com/renamed v1.0.0
+	example.com/abs v1.0.0
+	example.com/outside v1.0.0
+)
+
+replace (
+	example.com/a v1.0.0 => github.com/fork/a v1.2.0
+	example.com/a => github.com/other/a v1.3.0
+	example.com/b v1.0.0 => github.com/fork/b v1.2.0
+	example.com/c => github.com/fork/c v0.0.0-20150101120000-abcdef123456
+	example.com/local => ./third_party/local
+	example.com/renamed => ./third_party/renamed
+	example.com/abs => ` + filepath.Join(root, "third_party", "abs") + `
+	example.com/outside => ../outside
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setTestRepo(t, root, filepath.Join(tmp, "gopath"), mod, "third_party/local", "third_party/renamed/sub", "third_party/abs", "../outside")()
+
+	// The replacement's go.mod declares a module path different from
+	// the module that it replaces.
+	if err := ioutil.WriteFile(filepath.Join(root, "third_party", "renamed", "go.mod"), []byte("module example.com/fork/renamed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	local := func(unit string) *dep.ResolvedTarget {
+		return &dep.ResolvedTarget{ToUnit: unit, ToUnitType: "GoPackage"}
+	}
+	remote := func(cloneURL, version, rev, unit string) *dep.ResolvedTarget {
+		return &dep.ResolvedTarget{
+			ToRepoCloneURL:  cloneURL,
+			ToVersionString: version,
+			ToRevSpec:       rev,
+			ToUnit:          unit,
+			ToUnitType:      "GoPackage",
+		}
+	}
+	tests := []struct {
+		importPath string
+		want       *dep.ResolvedTarget
+	}{
+		// The main module.
+		{"example.com/repo/x", local("example.com/repo/x")},
+
+		// Versioned replacements. A replacement of the required version
+		// takes precedence over one of all versions, and one of another
+		// version doesn't apply.
+		{"example.com/a/sub", remote("https://github.com/fork/a", "v1.2.0", "v1.2.0", "github.com/fork/a/sub")},
+		{"example.com/b", remote("https://example.com/b", "v1.1.0", "v1.1.0", "example.com/b")},
+		{"example.com/c/x", remote("https://github.com/fork/c", "v0.0.0-20150101120000-abcdef123456", "abcdef123456", "github.com/fork/c/x")},
+
+		// The longest required module path that provides the import
+		// is used.
+		{"example.com/c/v2/x", remote("https://example.com/c/v2", "v2.0.0", "v2.0.0", "example.com/c/v2/x")},
+
+		// Replacements by directories in the repository.
+		{"example.com/local", local("example.com/local")},
+		{"example.com/local/sub", local("example.com/local/sub")},
+		{"example.com/renamed/sub", local("example.com/fork/renamed/sub")},
+		{"example.com/abs/x", local("example.com/abs/x")},
+	}
+	for _, test := range tests {
+		got, err := mod.resolve(test.importPath)
+		if err != nil {
+			t.Errorf("%s: %s", test.importPath, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.importPath, got, test.want)
+		}
+	}
+
+	// A replacement by a directory outside of the repository can't be
+	// resolved.
+	_, err = mod.resolve("example.com/outside/x")
+	want := `module example.com/outside (required for import "example.com/outside/x") is replaced by directory ` + filepath.Join(tmp, "outside") + `, which is outside of the repository`
+	if err == nil {
+		t.Errorf("got no error, want %q", want)
+	} else if err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
@@ -1,26 +1,33 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"go/ast"
 	"go/build"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"code.google.com/p/rog-go/parallel"
 	"github.com/golang/gddo/gosrc"
 
 	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
 
 	"sourcegraph.com/sourcegraph/srclib-go/gog"
 	"sourcegraph.com/sourcegraph/srclib-go/gog/definfo"
 	defpkg "sourcegraph.com/sourcegraph/srclib-go/golang_def"
 	"sourcegraph.com/sourcegraph/srclib/graph"
-	"sourcegraph.com/sourcegraph/srclib/grapher"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
@@ -50,7 +57,78 @@ func init() {
 	}
 }
 
-type GraphCmd struct{}
+type GraphCmd struct {
+	Concurrency int  `short:"j" long:"concurrency" description:"max number of imports to install or resolve concurrently (default: number of CPUs)" value-name:"N"`
+	Force       bool `long:"force" description:"graph the source unit even if its files are unchanged since it was last graphed (ignore the cached output in the --cache-dir)"`
+
+	// Format is "json" (a single JSON object with arrays of defs,
+	// refs, etc.) or "jsonl" (one JSON object per def, ref, etc., per
+	// line, written as it's produced; see graphLine).
+	Format string `long:"format" description:"output format: json (one object) or jsonl (one def, ref, doc, implementation, example, call, or control flow per line, streamed unsorted and not cached)" default:"json" value-name:"FORMAT"`
+
+	DefKeyScheme string `long:"defkey-scheme" description:"def path scheme: v1, or v2 (in which local def paths don't change when unrelated code changes; see gog.DefKeySchemeV2)" default:"v1" value-name:"SCHEME"`
+
+	Callgraph bool `long:"callgraph" description:"also emit call-graph edges from each function and method to the functions and methods that it calls (including possible edges to implementations of called interface methods)"`
+
+	// ControlFlow emits the control-flow relationships that refs don't
+	// express: fallthroughs to the next switch case, and the channels
+	// that select cases send on and receive from.
+	ControlFlow bool `long:"control-flow" description:"also emit control-flow edges from each fallthrough statement to the switch case that it transfers control to, and from each select case's send or receive to the channel var"`
+
+	// PrintfAnalysis flags calls of printf-style functions and links
+	// the verbs in their format strings to the arguments' types.
+	PrintfAnalysis bool `long:"printf-analysis" description:"mark the refs to printf-style functions at their calls, and emit refs from the verbs in literal format strings (such as %+v) to the named types of the arguments that they format"`
+
+	// HideUnexported omits unexported defs (and the refs, docs, etc.,
+	// to them) from the output, for public-API-only indexes.
+	HideUnexported    bool `long:"hide-unexported" description:"omit the defs of unexported and local identifiers, and the refs, docs, and other relationships that involve them, from the output (they are still used to resolve the package's exported defs)"`
+	KeepReturnedTypes bool `long:"keep-returned-types" description:"with --hide-unexported, keep the exported methods and fields of unexported types that exported funcs and methods return"`
+
+	// AllPlatforms graphs each package on every platform on which it
+	// has different files and merges the output.
+	AllPlatforms bool `long:"all-platforms" description:"graph each package on every known platform (GOOS/GOARCH) on which its build-constrained files differ, and merge the output; defs that don't exist on all platforms list the ones they exist on"`
+
+	// Offsets is the encoding of the offsets of the ranges (such as a
+	// ref's Start and End) in the output: gog.OffsetsUTF8 (bytes) or
+	// gog.OffsetsUTF16 (UTF-16 code units, for LSP-style consumers).
+	Offsets string `long:"offsets" description:"encoding of the offsets of the ranges in the output: utf8 (byte offsets) or utf16 (UTF-16 code units, as in the Language Server Protocol)" default:"utf8" value-name:"ENCODING"`
+
+	// RefSnippets adds the source line around each ref to the output
+	// (which roughly doubles the size of the refs).
+	RefSnippets bool `long:"ref-snippets" description:"include a snippet of source code (the line, truncated if long) around each ref in the output"`
+
+	ErrorsFormat string `long:"errors-format" description:"format of parse and type-checking errors: text (logged) or sarif (also written as a SARIF 2.1.0 log to --errors-file)" default:"text" value-name:"FORMAT"`
+	ErrorsFile   string `long:"errors-file" description:"file to write errors to (with --errors-format sarif)" value-name:"FILE"`
+
+	// FailOnError makes graphing fail if the source unit has parse or
+	// type-checking errors. By default, the parts of the source unit
+	// that could be parsed and type-checked are graphed.
+	FailOnError bool `long:"fail-on-error" description:"fail (without writing the graph, except with --format jsonl, which streams it) if there are any parse or type-checking errors, instead of emitting a partial graph"`
+
+	// Pkgs are the import paths of the packages to graph, if the
+	// source units to graph are found by scanning the repository
+	// instead of read from stdin.
+	Pkgs []string `long:"pkg" description:"graph the package with this import path (repeatable), found by scanning the repository (or in the --units file), instead of the source unit read from stdin; each package's output is written in turn" value-name:"IMPORT-PATH"`
+
+	// Units is a file of scan output whose source units are graphed
+	// (instead of the source unit read from stdin).
+	Units string `long:"units" description:"graph each source unit in this file (the JSON output of scan) in turn, instead of the source unit read from stdin; the units must have been scanned with the same Go language version" value-name:"FILE"`
+
+	// PrintSchema prints a JSON Schema describing the output instead
+	// of graphing anything.
+	PrintSchema bool `long:"print-schema" description:"print a JSON Schema describing the output (of both --format json and jsonl) and exit"`
+
+	// Manifest writes a manifest of the build data files in the graph
+	// cache after graphing.
+	Manifest bool `long:"manifest" description:"after graphing successfully, write a manifest listing each build data file in the graph cache (with its path, size, and source unit) to srclib-go-manifest.json in the --cache-dir"`
+
+	Stdin bool   `long:"stdin" description:"read the contents of --file from stdin (instead of a source unit), and graph its package with the contents overlaid on the file on disk (for unsaved editor buffers)"`
+	File  string `long:"file" description:"with --stdin, the file whose contents are read from stdin" value-name:"FILE"`
+
+	// Overlay is a JSON file mapping file paths to contents that
+	// replace the files on disk (for unsaved editor buffers).
+	Overlay string `long:"overlay" description:"JSON file mapping file paths to their contents, which are graphed instead of the files on disk (output is not cached)" value-name:"FILE"`
+}
 
 var graphCmd GraphCmd
 
@@ -59,6 +137,54 @@ var graphCmd GraphCmd
 var allowErrorsInGoGet = true
 
 func (c *GraphCmd) Execute(args []string) error {
+	if err := startCommand("graph"); err != nil {
+		return err
+	}
+
+	switch c.Format {
+	case "", "json", "jsonl":
+	default:
+		return fmt.Errorf("invalid --format %q (choices: json, jsonl)", c.Format)
+	}
+
+	switch c.DefKeyScheme {
+	case "", gog.DefKeySchemeV1, gog.DefKeySchemeV2:
+	default:
+		return fmt.Errorf("invalid --defkey-scheme %q (choices: %s, %s)", c.DefKeyScheme, gog.DefKeySchemeV1, gog.DefKeySchemeV2)
+	}
+
+	switch c.Offsets {
+	case "", gog.OffsetsUTF8, gog.OffsetsUTF16:
+	default:
+		return fmt.Errorf("invalid --offsets %q (choices: %s, %s)", c.Offsets, gog.OffsetsUTF8, gog.OffsetsUTF16)
+	}
+
+	switch c.ErrorsFormat {
+	case "", "text":
+	case "sarif":
+		if c.ErrorsFile == "" {
+			return fmt.Errorf("--errors-format sarif requires --errors-file")
+		}
+	default:
+		return fmt.Errorf("invalid --errors-format %q (choices: text, sarif)", c.ErrorsFormat)
+	}
+
+	if c.PrintSchema {
+		return printSchema()
+	}
+	if c.Stdin && c.Units != "" {
+		return fmt.Errorf("--stdin and --units can't be used together")
+	}
+	if c.Stdin {
+		return c.executeStdin()
+	}
+	if c.Units != "" {
+		return c.executeUnits()
+	}
+	if len(c.Pkgs) > 0 {
+		return c.executePkgs()
+	}
+
 	var unit *unit.SourceUnit
 	if err := json.NewDecoder(os.Stdin).Decode(&unit); err != nil {
 		return err
@@ -66,18 +192,48 @@ func (c *GraphCmd) Execute(args []string) error {
 	if err := os.Stdin.Close(); err != nil {
 		return err
 	}
+	errs, err := c.graphSourceUnit(unit)
+	if err != nil {
+		return err
+	}
+	return c.finish(errs)
+}
+
+// graphSourceUnit graphs the source unit (or reads its cached output)
+// and writes the output to stdout. It returns the parse and
+// type-checking errors encountered while graphing it. If there are any
+// and --fail-on-error is set, the output isn't written (unless it's
+// streamed, with --format jsonl).
+func (c *GraphCmd) graphSourceUnit(unit *unit.SourceUnit) ([]graphError, error) {
+	resetGraphErrors()
+	ctx, cancel := timeoutContext("graphing source unit " + unit.Name)
+	defer cancel()
+	overrideRepo(unit)
 
 	if err := unmarshalTypedConfig(unit.Config); err != nil {
-		return err
+		return nil, err
 	}
 	if err := config.apply(); err != nil {
-		return err
+		return nil, err
+	}
+
+	overlay, err := c.overlay()
+	if err != nil {
+		return nil, err
+	}
+	if len(overlay) > 0 {
+		buildPkg, err := UnitDataAsBuildPackage(unit)
+		if err != nil {
+			return nil, err
+		}
+		overlayFiles(overlay)
+		useSourceImportsForOverlay(overlay, filepath.Join(cwd, buildPkg.Dir))
 	}
 
 	if os.Getenv("IN_DOCKER_CONTAINER") != "" {
 		buildPkg, err := UnitDataAsBuildPackage(unit)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// Make a new primary GOPATH.
@@ -85,24 +241,24 @@ func (c *GraphCmd) Execute(args []string) error {
 		if buildContext.GOPATH == "" {
 			buildContext.GOPATH = mainGOPATHDir
 		} else {
-			buildContext.GOPATH = mainGOPATHDir + ":" + buildContext.GOPATH
+			buildContext.GOPATH = joinPathList([]string{mainGOPATHDir, buildContext.GOPATH})
 		}
 
 		// Set up GOPATH so it has this repo.
-		log.Printf("Setting up a new GOPATH at %s", mainGOPATHDir)
+		debugf("Setting up a new GOPATH at %s", mainGOPATHDir)
 		dir := filepath.Join(mainGOPATHDir, "src", string(unit.Repo))
 		if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
-			return err
+			return nil, err
 		}
-		log.Printf("Creating symlink to oldname %q at newname %q.", cwd, dir)
+		debugf("Creating symlink to oldname %q at newname %q.", cwd, dir)
 		if err := os.Symlink(cwd, dir); err != nil {
-			return err
+			return nil, err
 		}
 
 		// For every GOPATH that was in the Srcfile (or autodetected),
 		// move it to a writable dir. (/src is not writable.)
 		if config.GOPATH != "" {
-			dirs := strings.Split(buildContext.GOPATH, ":")
+			dirs := filepath.SplitList(buildContext.GOPATH)
 			for i, dir := range dirs {
 				if dir == mainGOPATHDir || dir == os.Getenv("GOPATH") {
 					continue
@@ -111,21 +267,21 @@ func (c *GraphCmd) Execute(args []string) error {
 				oldSrcDir := filepath.Join(dir, "src")
 				newGOPATH := filepath.Join("/tmp/gopath-" + strconv.Itoa(i) + "-" + filepath.Base(dir))
 				newSrcDir := filepath.Join(newGOPATH, "src")
-				log.Printf("Creating symlink for non-primary GOPATH to oldname %q at newname %q.", oldSrcDir, newSrcDir)
+				debugf("Creating symlink for non-primary GOPATH to oldname %q at newname %q.", oldSrcDir, newSrcDir)
 				if err := os.MkdirAll(filepath.Dir(newSrcDir), 0700); err != nil {
-					return err
+					return nil, err
 				}
 				if err := os.Symlink(oldSrcDir, newSrcDir); err != nil {
-					return err
+					return nil, err
 				}
 				dirs[i] = newGOPATH
 			}
-			buildContext.GOPATH = strings.Join(dirs, ":")
+			buildContext.GOPATH = joinPathList(dirs)
 		}
 
-		log.Printf("Changing directory to %q.", dir)
+		debugf("Changing directory to %q.", dir)
 		if err := os.Chdir(dir); err != nil {
-			return err
+			return nil, err
 		}
 		dockerCWD = cwd
 
@@ -148,51 +304,124 @@ func (c *GraphCmd) Execute(args []string) error {
 			}
 		}
 		deps := append([]string{"./" + buildPkg.Dir}, externalDeps...)
+		getStart := time.Now()
 		for _, dep := range deps {
-			cmd := exec.Command("go", "get", "-d", "-t", "-v", dep)
-			cmd.Args = append(cmd.Args)
+			cmd := exec.Command(goTool(), "get", "-d", "-t", "-v", dep)
 			cmd.Env = config.env()
 			cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
-			log.Printf("%v (env vars: %v).", cmd.Args, cmd.Env)
-			if err := cmd.Run(); err != nil {
+			debugf("%v (env vars: %v).", cmd.Args, cmd.Env)
+			if err := runCmd(ctx, cmd); err != nil {
 				if allowErrorsInGoGet {
-					log.Printf("%v failed: %s (continuing)", cmd.Args, err)
+					warnf("%v failed: %s (continuing)", cmd.Args, err)
 				} else {
-					return err
+					return nil, err
 				}
 			}
 		}
-		log.Printf("Finished downloading dependencies.")
+		std.withDuration(getStart).infof("Finished downloading dependencies.")
 	}
 
-	out, err := Graph(unit)
-	if err != nil {
-		return err
+	if c.Format == "jsonl" {
+		if err := streamGraphJSONL(ctx, os.Stdout, unit); err != nil {
+			return nil, err
+		}
+		return recordedGraphErrors(), nil
 	}
 
-	// Make paths relative to repo.
-	for _, gs := range out.Defs {
-		if gs.File == "" {
-			log.Printf("no file %+v", gs)
+	var cacheKey string
+	if len(overlay) == 0 {
+		cacheKey, err = graphCacheKey(unit)
+		if err != nil {
+			warnf("computing graph cache key for %s failed: %s (not using cache)", unit.Name, err)
 		}
-		if gs.File != "" {
-			gs.File = relPath(cwd, gs.File)
+	}
+	if cacheKey != "" && !c.Force {
+		if cached, errs := readGraphCache(unit, cacheKey); cached != nil {
+			infof("Source unit %s is unchanged since it was last graphed; using cached output.", unit.Name)
+			return errs, c.writeOutput(cached, errs)
 		}
 	}
-	for _, gr := range out.Refs {
-		if gr.File != "" {
-			gr.File = relPath(cwd, gr.File)
+
+	out, err := Graph(ctx, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	out.finish()
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+
+	errs := recordedGraphErrors()
+
+	// Don't cache partial output. (Output that's only partial because
+	// of errors is cached, along with the errors.)
+	if cacheKey != "" && !isTimedOut(ctx) {
+		if err := writeGraphCache(unit, cacheKey, data, errs); err != nil {
+			warnf("writing graph cache for %s failed: %s", unit.Name, err)
 		}
 	}
-	for _, gd := range out.Docs {
-		if gd.File != "" {
-			gd.File = relPath(cwd, gd.File)
+
+	return errs, c.writeOutput(data, errs)
+}
+
+// writeOutput writes the graph output data for a source unit to stdout,
+// unless --fail-on-error is set and there were errors.
+func (c *GraphCmd) writeOutput(data []byte, errs []graphError) error {
+	if c.FailOnError && len(errs) > 0 {
+		return nil
+	}
+	_, err := os.Stdout.Write(data)
+	return err
+}
+
+// overlay returns the --overlay files (see readOverlay), or an empty
+// map if there is no --overlay.
+func (c *GraphCmd) overlay() (map[string][]byte, error) {
+	if c.Overlay == "" {
+		return map[string][]byte{}, nil
+	}
+	return readOverlay(c.Overlay)
+}
+
+// writeErrors writes the parse and type-checking errors encountered
+// while graphing to the --errors-file, if the --errors-format calls for
+// it. (Errors are always logged as they occur.)
+func (c *GraphCmd) writeErrors(errs []graphError) error {
+	if c.ErrorsFormat != "sarif" {
+		return nil
+	}
+	return writeSARIF(c.ErrorsFile, errs)
+}
+
+// finish handles the errors encountered while graphing (see
+// handleErrors) and then, if graphing succeeded and --manifest is set,
+// writes the manifest of the build data files in the graph cache.
+func (c *GraphCmd) finish(errs []graphError) error {
+	if err := c.handleErrors(errs); err != nil {
+		return err
+	}
+	if c.Manifest {
+		if err := writeDataManifest(); err != nil {
+			return fmt.Errorf("writing --manifest failed: %s", err)
 		}
 	}
+	return nil
+}
 
-	if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+// handleErrors writes the errors encountered while graphing (see
+// writeErrors) and, if --fail-on-error is set and there are any,
+// returns an error.
+func (c *GraphCmd) handleErrors(errs []graphError) error {
+	if err := c.writeErrors(errs); err != nil {
 		return err
 	}
+	if c.FailOnError && len(errs) > 0 {
+		return fmt.Errorf("%d parse or type-checking errors (and --fail-on-error is set)", len(errs))
+	}
 	return nil
 }
 
@@ -218,52 +447,487 @@ func relPath(base, path string) string {
 	return rp
 }
 
-func Graph(unit *unit.SourceUnit) (*grapher.Output, error) {
-	pkg, err := UnitDataAsBuildPackage(unit)
+// finish makes the file paths in o relative to the repository and
+// sorts o, to prepare it for output.
+func (o *graphOutput) finish() {
+	for _, gs := range o.Defs {
+		if gs.File == "" {
+			debugf("no file %+v", gs)
+		}
+		if gs.File != "" {
+			gs.File = relPath(cwd, gs.File)
+		}
+	}
+	for _, gr := range o.Refs {
+		if gr.File != "" {
+			gr.File = relPath(cwd, gr.File)
+		}
+	}
+	for _, gd := range o.Docs {
+		if gd.File != "" {
+			gd.File = relPath(cwd, gd.File)
+		}
+	}
+	for _, ge := range o.Examples {
+		if ge.File != "" {
+			ge.File = relPath(cwd, ge.File)
+		}
+	}
+	for _, gc := range o.Calls {
+		if gc.File != "" {
+			gc.File = relPath(cwd, gc.File)
+		}
+	}
+	for _, gf := range o.ControlFlows {
+		if gf.File != "" {
+			gf.File = relPath(cwd, gf.File)
+		}
+	}
+
+	// Sort so that the output is the same on every run.
+	o.sort()
+}
+
+// graphOutput is the output of the graph command: the defs, refs, and
+// docs that all srclib graphers emit, plus Go-specific relationships
+// between defs.
+type graphOutput struct {
+	// GoVersion is the Go language version (such as "1.21") that the
+	// source unit was graphed as (see goLanguageVersion).
+	GoVersion string `json:",omitempty"`
+
+	// Offsets is gog.OffsetsUTF16 if the offsets of the ranges in the
+	// output are UTF-16 code unit offsets (with --offsets utf16). It is
+	// empty if they are byte offsets.
+	Offsets string `json:",omitempty"`
+
+	Defs []*graph.Def
+	Refs []*ref
+	Docs []*graph.Doc
+
+	Implementations []*implementation `json:",omitempty"`
+	Examples        []*example        `json:",omitempty"`
+	Calls           []*call           `json:",omitempty"`
+	ControlFlows    []*controlFlow    `json:",omitempty"`
+}
+
+// ref is a srclib ref with Go-specific information about it.
+type ref struct {
+	*graph.Ref
+
+	// MethodUse is how the method is used, if the ref is a method
+	// selector (see gog.Ref).
+	MethodUse string `json:",omitempty"`
+
+	// Test is whether the ref is in a test file (*_test.go).
+	Test bool `json:",omitempty"`
+
+	// Snippet is the source code around the ref, with --ref-snippets.
+	Snippet *gog.Snippet `json:",omitempty"`
+
+	// PrintfCall and FormatVerb are set with --printf-analysis (see
+	// gog.Ref).
+	PrintfCall bool   `json:",omitempty"`
+	FormatVerb string `json:",omitempty"`
+}
+
+// implementation records that the named type Type implements the
+// interface Interface (or that *Type does, if Pointer is true). Asserted
+// is whether the code asserts it with a blank var (such as var _ I =
+// (*T)(nil)).
+type implementation struct {
+	Type      graph.DefKey
+	Interface graph.DefKey
+	Pointer   bool `json:",omitempty"`
+	Asserted  bool `json:",omitempty"`
+}
+
+// call is a call from the function or method Caller to the function
+// or method Callee (or a possible call, if the called method is an
+// interface method that Callee implements).
+type call struct {
+	Caller   graph.DefKey
+	Callee   graph.DefKey
+	Possible bool `json:",omitempty"`
+	File     string
+	Start    int
+	End      int
+}
+
+// controlFlow is a fallthrough statement or a select case's send or
+// receive in the body of the function or method Func (see
+// gog.ControlFlow). A fallthrough's TargetStart and TargetEnd are the
+// offsets of the header of the case that it transfers control to, and a
+// send's or receive's Channel is the def of the channel var, if any.
+type controlFlow struct {
+	Kind        string
+	Func        graph.DefKey
+	File        string
+	Start       int
+	End         int
+	TargetStart int           `json:",omitempty"`
+	TargetEnd   int           `json:",omitempty"`
+	Channel     *graph.DefKey `json:",omitempty"`
+}
+
+// example is a testable example function whose def is Def and that
+// documents the def Subject.
+type example struct {
+	Def       graph.DefKey
+	Subject   graph.DefKey
+	Suffix    string `json:",omitempty"`
+	Code      string
+	Output    string `json:",omitempty"`
+	HasOutput bool   `json:",omitempty"`
+	File      string
+	Start     int
+	End       int
+}
+
+// Graph graphs the source unit, aborting (with partial output) when ctx
+// is done (see graphUnit).
+func Graph(ctx context.Context, unit *unit.SourceUnit) (*graphOutput, error) {
+	o2 := graphOutput{GoVersion: goLanguageVersion(), Offsets: graphCmd.outputOffsets()}
+	err := graphUnit(ctx, unit, func(item interface{}) error {
+		switch item := item.(type) {
+		case *graph.Def:
+			o2.Defs = append(o2.Defs, item)
+		case *ref:
+			o2.Refs = append(o2.Refs, item)
+		case *graph.Doc:
+			o2.Docs = append(o2.Docs, item)
+		case *implementation:
+			o2.Implementations = append(o2.Implementations, item)
+		case *example:
+			o2.Examples = append(o2.Examples, item)
+		case *call:
+			o2.Calls = append(o2.Calls, item)
+		case *controlFlow:
+			o2.ControlFlows = append(o2.ControlFlows, item)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &o2, nil
+}
 
-	o, err := doGraph(pkg)
+// graphUnit graphs the source unit and calls emit with each def, ref,
+// doc, implementation, example, call, and control flow (converted to
+// srclib's types, with file paths that are still absolute) in the
+// order that the grapher produced them. When ctx is done, the grapher
+// stops and the output emitted so far is partial.
+func graphUnit(ctx context.Context, unit *unit.SourceUnit, emit func(item interface{}) error) error {
+	pkg, err := UnitDataAsBuildPackage(unit)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	xtest, err := isXTestUnit(unit)
+	if err != nil {
+		return err
 	}
 
-	o2 := grapher.Output{}
+	type graphResult struct {
+		o   *gog.Output
+		err error
+	}
+	// doGraph uses global state (such as the loader config), so wait
+	// for the previous source unit's graphing to finish if it was
+	// abandoned after timing out below.
+	select {
+	case graphing <- struct{}{}:
+	default:
+		select {
+		case graphing <- struct{}{}:
+		case <-ctx.Done():
+			warnf("timed out while waiting for the previous source unit to finish loading; emitting empty output for %s.", unit.Name)
+			return nil
+		}
+	}
+	done := make(chan graphResult, 1)
+	go func() {
+		defer func() { <-graphing }()
+		graph := doGraph
+		if graphCmd.AllPlatforms {
+			graph = doGraphAllPlatforms
+		}
+		o, err := graph(ctx, pkg, xtest)
+		done <- graphResult{o, err}
+	}()
+	var r graphResult
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		// The grapher stops soon after the timeout and returns its
+		// partial output, but loading and type-checking the package
+		// can't be interrupted.
+		select {
+		case r = <-done:
+		case <-time.After(timeoutGracePeriod):
+			warnf("timed out while loading package %s; emitting empty output.", pkg.ImportPath)
+			return nil
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	o := r.o
 
 	uri := string(unit.Repo)
 
+	// Resolve each distinct package concurrently before converting
+	// (which resolves the package of every def, ref, and doc), because
+	// resolving remote packages may require network requests.
+	if err := resolvePackages(ctx, o, uri); err != nil {
+		return err
+	}
+
 	for _, gs := range o.Defs {
 		d, err := convertGoDef(gs, uri)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if d != nil {
-			o2.Defs = append(o2.Defs, d)
+			if err := emit(d); err != nil {
+				return err
+			}
 		}
 	}
 	for _, gr := range o.Refs {
 		r, err := convertGoRef(gr, uri)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if r != nil {
-			o2.Refs = append(o2.Refs, r)
+			if err := emit(r); err != nil {
+				return err
+			}
 		}
 	}
 	for _, gd := range o.Docs {
 		d, err := convertGoDoc(gd, uri)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if d != nil {
-			o2.Docs = append(o2.Docs, d)
+			if err := emit(d); err != nil {
+				return err
+			}
 		}
 	}
+	for _, gi := range o.Implementations {
+		i, err := convertGoImplementation(gi, uri)
+		if err != nil {
+			return err
+		}
+		if i != nil {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ge := range o.Examples {
+		e, err := convertGoExample(ge, uri)
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			if err := emit(e); err != nil {
+				return err
+			}
+		}
+	}
+	for _, gc := range o.Calls {
+		c, err := convertGoCall(gc, uri)
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			if err := emit(c); err != nil {
+				return err
+			}
+		}
+	}
+	for _, gf := range o.ControlFlows {
+		f, err := convertGoControlFlow(gf, uri)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			if err := emit(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-	return &o2, nil
+// resolvePackages calls ResolveDep on each package that o refers to,
+// so that the results are cached for subsequent calls. Once ctx is
+// done, the remaining packages are left to be resolved as they're
+// converted.
+func resolvePackages(ctx context.Context, o *gog.Output, repoURI string) error {
+	seen := map[string]struct{}{}
+	var importPaths []string
+	add := func(key *gog.DefKey) {
+		if _, ok := seen[key.PackageImportPath]; !ok {
+			seen[key.PackageImportPath] = struct{}{}
+			importPaths = append(importPaths, key.PackageImportPath)
+		}
+	}
+	for _, d := range o.Defs {
+		add(d.DefKey)
+	}
+	for _, r := range o.Refs {
+		add(r.Def)
+	}
+
+	run := parallel.NewRun(graphCmd.concurrency())
+	for _, importPath := range importPaths {
+		importPath := importPath
+		run.Do(func() error {
+			if isTimedOut(ctx) {
+				return nil
+			}
+			_, err := ResolveDep(importPath, repoURI)
+			return err
+		})
+	}
+	return run.Wait()
+}
+
+// outputOffsets returns the value of graphOutput.Offsets for the
+// --offsets encoding.
+func (c *GraphCmd) outputOffsets() string {
+	if c.Offsets == gog.OffsetsUTF16 {
+		return gog.OffsetsUTF16
+	}
+	return ""
+}
+
+func (c *GraphCmd) concurrency() int {
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	return runtime.NumCPU()
+}
+
+// sort sorts the defs, refs, docs, and other relationships in o.
+func (o *graphOutput) sort() {
+	sort.Sort(defsByKey(o.Defs))
+	sort.Sort(refsByPosition(o.Refs))
+	sort.Sort(docsByKey(o.Docs))
+	sort.Sort(implementationsByKey(o.Implementations))
+	sort.Sort(examplesByKey(o.Examples))
+	sort.Sort(callsByPosition(o.Calls))
+	sort.Sort(controlFlowsByPosition(o.ControlFlows))
+}
+
+func defKeyLess(a, b *graph.DefKey) bool {
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	if a.UnitType != b.UnitType {
+		return a.UnitType < b.UnitType
+	}
+	if a.Unit != b.Unit {
+		return a.Unit < b.Unit
+	}
+	return a.Path < b.Path
 }
 
+type defsByKey []*graph.Def
+
+func (v defsByKey) Len() int           { return len(v) }
+func (v defsByKey) Less(i, j int) bool { return defKeyLess(&v[i].DefKey, &v[j].DefKey) }
+func (v defsByKey) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+type refsByPosition []*ref
+
+func (v refsByPosition) Len() int { return len(v) }
+func (v refsByPosition) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Start != b.Start {
+		return a.Start < b.Start
+	}
+	if a.End != b.End {
+		return a.End < b.End
+	}
+	if a.Def != b.Def {
+		return a.Def
+	}
+	return defKeyLess(
+		&graph.DefKey{Repo: a.DefRepo, UnitType: a.DefUnitType, Unit: a.DefUnit, Path: a.DefPath},
+		&graph.DefKey{Repo: b.DefRepo, UnitType: b.DefUnitType, Unit: b.DefUnit, Path: b.DefPath},
+	)
+}
+func (v refsByPosition) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+
+type docsByKey []*graph.Doc
+
+func (v docsByKey) Len() int { return len(v) }
+func (v docsByKey) Less(i, j int) bool {
+	if v[i].DefKey != v[j].DefKey {
+		return defKeyLess(&v[i].DefKey, &v[j].DefKey)
+	}
+	return v[i].Format < v[j].Format
+}
+func (v docsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+
+type implementationsByKey []*implementation
+
+func (v implementationsByKey) Len() int { return len(v) }
+func (v implementationsByKey) Less(i, j int) bool {
+	if v[i].Type != v[j].Type {
+		return defKeyLess(&v[i].Type, &v[j].Type)
+	}
+	return defKeyLess(&v[i].Interface, &v[j].Interface)
+}
+func (v implementationsByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+
+type examplesByKey []*example
+
+func (v examplesByKey) Len() int           { return len(v) }
+func (v examplesByKey) Less(i, j int) bool { return defKeyLess(&v[i].Def, &v[j].Def) }
+func (v examplesByKey) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+type callsByPosition []*call
+
+func (v callsByPosition) Len() int { return len(v) }
+func (v callsByPosition) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Start != b.Start {
+		return a.Start < b.Start
+	}
+	if a.Possible != b.Possible {
+		return !a.Possible
+	}
+	return defKeyLess(&a.Callee, &b.Callee)
+}
+func (v callsByPosition) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+
+type controlFlowsByPosition []*controlFlow
+
+func (v controlFlowsByPosition) Len() int { return len(v) }
+func (v controlFlowsByPosition) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Start != b.Start {
+		return a.Start < b.Start
+	}
+	return a.Kind < b.Kind
+}
+func (v controlFlowsByPosition) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+
 func convertGoDef(gs *gog.Def, repoURI string) (*graph.Def, error) {
 	resolvedTarget, err := ResolveDep(gs.DefKey.PackageImportPath, repoURI)
 	if err != nil {
@@ -291,7 +955,7 @@ func convertGoDef(gs *gog.Def, repoURI string) (*graph.Def, error) {
 		DefEnd:   gs.DeclSpan[1],
 
 		Exported: gs.DefInfo.Exported,
-		Test:     strings.HasSuffix(gs.File, "_test.go"),
+		Test:     gs.Test,
 	}
 
 	d := defpkg.DefData{
@@ -311,7 +975,7 @@ func convertGoDef(gs *gog.Def, repoURI string) (*graph.Def, error) {
 	return def, nil
 }
 
-func convertGoRef(gr *gog.Ref, repoURI string) (*graph.Ref, error) {
+func convertGoRef(gr *gog.Ref, repoURI string) (*ref, error) {
 	resolvedTarget, err := ResolveDep(gr.Def.PackageImportPath, repoURI)
 	if err != nil {
 		return nil, err
@@ -320,15 +984,22 @@ func convertGoRef(gr *gog.Ref, repoURI string) (*graph.Ref, error) {
 		return nil, nil
 	}
 
-	return &graph.Ref{
-		DefRepo:     uriOrEmpty(resolvedTarget.ToRepoCloneURL),
-		DefPath:     graph.DefPath(pathOrDot(strings.Join(gr.Def.Path, "/"))),
-		DefUnit:     resolvedTarget.ToUnit,
-		DefUnitType: resolvedTarget.ToUnitType,
-		Def:         gr.IsDef,
-		File:        gr.File,
-		Start:       gr.Span[0],
-		End:         gr.Span[1],
+	return &ref{
+		Ref: &graph.Ref{
+			DefRepo:     uriOrEmpty(resolvedTarget.ToRepoCloneURL),
+			DefPath:     graph.DefPath(pathOrDot(strings.Join(gr.Def.Path, "/"))),
+			DefUnit:     resolvedTarget.ToUnit,
+			DefUnitType: resolvedTarget.ToUnitType,
+			Def:         gr.IsDef,
+			File:        gr.File,
+			Start:       gr.Span[0],
+			End:         gr.Span[1],
+		},
+		MethodUse:  gr.MethodUse,
+		Test:       gr.Test,
+		Snippet:    gr.Snippet,
+		PrintfCall: gr.PrintfCall,
+		FormatVerb: gr.FormatVerb,
 	}, nil
 }
 
@@ -351,6 +1022,102 @@ func convertGoDoc(gd *gog.Doc, repoURI string) (*graph.Doc, error) {
 	}, nil
 }
 
+func convertGoImplementation(gi *gog.Implementation, repoURI string) (*implementation, error) {
+	typeKey, err := convertGoDefKey(gi.Type, repoURI)
+	if err != nil || typeKey == nil {
+		return nil, err
+	}
+	ifaceKey, err := convertGoDefKey(gi.Interface, repoURI)
+	if err != nil || ifaceKey == nil {
+		return nil, err
+	}
+	return &implementation{Type: *typeKey, Interface: *ifaceKey, Pointer: gi.Pointer, Asserted: gi.Asserted}, nil
+}
+
+func convertGoExample(ge *gog.Example, repoURI string) (*example, error) {
+	defKey, err := convertGoDefKey(ge.Def, repoURI)
+	if err != nil || defKey == nil {
+		return nil, err
+	}
+	subjectKey, err := convertGoDefKey(ge.Subject, repoURI)
+	if err != nil || subjectKey == nil {
+		return nil, err
+	}
+	return &example{
+		Def:       *defKey,
+		Subject:   *subjectKey,
+		Suffix:    ge.Suffix,
+		Code:      ge.Code,
+		Output:    ge.Output,
+		HasOutput: ge.HasOutput,
+		File:      ge.File,
+		Start:     ge.Span[0],
+		End:       ge.Span[1],
+	}, nil
+}
+
+func convertGoCall(gc *gog.Call, repoURI string) (*call, error) {
+	callerKey, err := convertGoDefKey(gc.Caller, repoURI)
+	if err != nil || callerKey == nil {
+		return nil, err
+	}
+	calleeKey, err := convertGoDefKey(gc.Callee, repoURI)
+	if err != nil || calleeKey == nil {
+		return nil, err
+	}
+	return &call{
+		Caller:   *callerKey,
+		Callee:   *calleeKey,
+		Possible: gc.Possible,
+		File:     gc.File,
+		Start:    gc.Span[0],
+		End:      gc.Span[1],
+	}, nil
+}
+
+func convertGoControlFlow(gf *gog.ControlFlow, repoURI string) (*controlFlow, error) {
+	funcKey, err := convertGoDefKey(gf.Func, repoURI)
+	if err != nil || funcKey == nil {
+		return nil, err
+	}
+	f := &controlFlow{
+		Kind:  gf.Kind,
+		Func:  *funcKey,
+		File:  gf.File,
+		Start: gf.Span[0],
+		End:   gf.Span[1],
+	}
+	if gf.TargetSpan != nil {
+		f.TargetStart, f.TargetEnd = gf.TargetSpan[0], gf.TargetSpan[1]
+	}
+	if gf.Channel != nil {
+		// Omit the channel (but not the select case) if its repository
+		// can't be resolved.
+		if f.Channel, err = convertGoDefKey(gf.Channel, repoURI); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// convertGoDefKey converts a def key to a srclib def key, which
+// includes the repository for defs that are not in this repository.
+func convertGoDefKey(key *gog.DefKey, repoURI string) (*graph.DefKey, error) {
+	resolvedTarget, err := ResolveDep(key.PackageImportPath, repoURI)
+	if err != nil {
+		return nil, err
+	}
+	if resolvedTarget == nil {
+		return nil, nil
+	}
+	return &graph.DefKey{
+		Repo:     uriOrEmpty(resolvedTarget.ToRepoCloneURL),
+		Unit:     resolvedTarget.ToUnit,
+		UnitType: resolvedTarget.ToUnitType,
+		Path:     graph.DefPath(pathOrDot(strings.Join(key.Path, "/"))),
+	}, nil
+}
+
 func uriOrEmpty(cloneURL string) string {
 	if cloneURL == "" {
 		return ""
@@ -376,8 +1143,27 @@ func treePath(path string) graph.TreePath {
 // encountering "reasonably common" errors (such as compile errors).
 var allowErrorsInGraph = true
 
-func doGraph(pkg *build.Package) (*gog.Output, error) {
+// graphing holds a token while a source unit's package is being graphed
+// (see graphUnit).
+var graphing = make(chan struct{}, 1)
+
+// doGraph graphs pkg, or its external test package (package foo_test)
+// if xtest is true.
+func doGraph(ctx context.Context, pkg *build.Package, xtest bool) (*gog.Output, error) {
 	importPath := pkg.ImportPath
+	graphPath := importPath // of the package to graph
+	if xtest {
+		graphPath += "_test"
+	}
+
+	// Clear the loader state left over from graphing the previous
+	// source unit, if any (see GraphCmd.Pkgs), except for the standard
+	// library packages it imported from export data.
+	loaderConfig.Fset = nil
+	loaderConfig.ImportPkgs = nil
+	loaderConfig.CreatePkgs = nil
+	loaderConfig.TypeChecker.Packages = exportDataPkgs.get(importPath)
+	loaderConfig.TypeChecker.Import = nil
 
 	if !loaderConfig.SourceImports {
 		imports := map[string]struct{}{}
@@ -391,10 +1177,20 @@ func doGraph(pkg *build.Package) (*gog.Output, error) {
 			imports[imp] = struct{}{}
 		}
 
+		run := parallel.NewRun(graphCmd.concurrency())
 		for imp, _ := range imports {
 			if imp == "C" {
 				continue
 			}
+			if isLocalImport(imp) {
+				absImp, err := absImportPath(imp, pkg.Dir)
+				if err != nil {
+					warnf("%s.", err)
+					continue
+				}
+				imp = absImp
+				loaderConfig.TypeChecker.Import = localImporter(filepath.Join(cwd, pkg.Dir))
+			}
 			if gosrc.IsGoRepoPath(imp) {
 				// Optimization: don't bother installing builtin
 				// packages because they're already installed. (But if
@@ -402,28 +1198,52 @@ func doGraph(pkg *build.Package) (*gog.Output, error) {
 				// to cause any problems.)
 				continue
 			}
-			cmd := exec.Command("go", "install", "-v", imp)
-			cmd.Env = config.env()
-			cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
-			if err := cmd.Run(); err != nil {
-				if allowErrorsInGraph {
-					log.Printf("Warning: failed to install package %q (command %v, env vars %v): %s. Continuing...", imp, cmd.Args, cmd.Env)
-				} else {
-					return nil, err
+			imp := imp
+			run.Do(func() error {
+				cmd := exec.Command(goTool(), "install", "-v", imp)
+				cmd.Env = config.env()
+				cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+				if err := runCmd(ctx, cmd); err != nil {
+					if allowErrorsInGraph {
+						warnf("failed to install package %q (command %v, env vars %v): %s. Continuing...", imp, cmd.Args, cmd.Env, err)
+					} else {
+						return err
+					}
 				}
-			}
+				return nil
+			})
+		}
+		if err := run.Wait(); err != nil {
+			return nil, err
 		}
 	}
 
+	// Omit the files that are matched by a .srclibignore file. The
+	// loader would import them along with the rest of the package's
+	// files, so load the package from its remaining files instead.
+	ignoredFiles := newSrclibIgnore(cwd).removeIgnoredFiles(pkg)
+
 	importUnsafe := importPath == "unsafe"
 
+	// parseErrs are the parse errors in the files of a package that we
+	// parse ourselves (instead of the loader).
+	var parseErrs []error
+
 	// Special-case: if this is a Cgo package, treat the CgoFiles as GoFiles or
 	// else the character offsets will be junk.
 	//
 	// See https://codereview.appspot.com/86140043.
 	loaderConfig.Build.CgoEnabled = false
 	build.Default = *loaderConfig.Build
-	if len(pkg.CgoFiles) > 0 {
+	if (len(pkg.CgoFiles) > 0 || ignoredFiles) && xtest {
+		// The external test package imports the package (built by the
+		// go tool) instead.
+		var xtestFiles []string
+		for _, f := range pkg.XTestGoFiles {
+			xtestFiles = append(xtestFiles, filepath.Join(cwd, pkg.Dir, f))
+		}
+		parseErrs = createFromFilenames(graphPath, xtestFiles)
+	} else if len(pkg.CgoFiles) > 0 || ignoredFiles {
 		var allGoFiles []string
 		allGoFiles = append(allGoFiles, pkg.GoFiles...)
 		allGoFiles = append(allGoFiles, pkg.CgoFiles...)
@@ -431,13 +1251,29 @@ func doGraph(pkg *build.Package) (*gog.Output, error) {
 		for i, f := range allGoFiles {
 			allGoFiles[i] = filepath.Join(cwd, pkg.Dir, f)
 		}
-		if err := loaderConfig.CreateFromFilenames(pkg.ImportPath, allGoFiles...); err != nil {
-			return nil, err
-		}
+		parseErrs = createFromFilenames(pkg.ImportPath, allGoFiles)
 	} else {
 		// Normal import
 		if err := loaderConfig.ImportWithTests(importPath); err != nil {
-			return nil, err
+			// go/build (and therefore the loader) rejects a package
+			// if one of its files has a syntax error. Type-check as
+			// much of the files as can be parsed instead, so that the
+			// rest of the package is still graphed.
+			var goFiles []string
+			if xtest {
+				goFiles = append(goFiles, pkg.XTestGoFiles...)
+			} else {
+				goFiles = append(goFiles, pkg.GoFiles...)
+				goFiles = append(goFiles, pkg.TestGoFiles...)
+			}
+			if len(goFiles) == 0 {
+				return nil, err
+			}
+			for i, f := range goFiles {
+				goFiles[i] = filepath.Join(cwd, pkg.Dir, f)
+			}
+			delete(loaderConfig.ImportPkgs, importPath)
+			parseErrs = createFromFilenames(graphPath, goFiles)
 		}
 	}
 
@@ -454,26 +1290,153 @@ func doGraph(pkg *build.Package) (*gog.Output, error) {
 	if err != nil {
 		return nil, err
 	}
+	exportDataPkgs.keep(prog)
 
 	g := gog.New(prog)
+	g.Callgraph = graphCmd.Callgraph
+	g.ControlFlow = graphCmd.ControlFlow
+	g.PrintfAnalysis = graphCmd.PrintfAnalysis
+	g.DefKeyScheme = graphCmd.DefKeyScheme
 
+	// The external test package is its own source unit (see
+	// splitXTestUnits), so graph either it or the package.
 	var pkgs []*loader.PackageInfo
 	for _, pkg := range prog.Created {
-		if strings.HasSuffix(pkg.Pkg.Name(), "_test") {
-			// ignore xtest packages
-			continue
+		if strings.HasSuffix(pkg.Pkg.Name(), "_test") == xtest {
+			pkgs = append(pkgs, pkg)
 		}
-		pkgs = append(pkgs, pkg)
 	}
-	for _, pkg := range prog.Imported {
-		pkgs = append(pkgs, pkg)
+	if !xtest {
+		for _, pkg := range prog.Imported {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	for _, pkg := range prog.Created {
+		if pkg.Pkg.Path() == graphPath {
+			pkg.Errors = append(parseErrs, pkg.Errors...)
+		}
 	}
+	recordGraphErrors(pkgs)
 
+	g.Cancel = ctx.Done()
 	for _, pkg := range pkgs {
-		if err := g.Graph(pkg); err != nil {
+		if err := g.Graph(pkg); err == gog.ErrCanceled {
+			warnf("timed out while graphing package %s; emitting partial output.", pkg.Pkg.Path())
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if graphCmd.HideUnexported {
+		g.OmitUnexported(graphCmd.KeepReturnedTypes)
+	}
+	if graphCmd.RefSnippets {
+		g.AddRefSnippets()
+	}
+	if graphCmd.Offsets == gog.OffsetsUTF16 {
+		if err := g.ConvertOffsetsToUTF16(); err != nil {
 			return nil, err
 		}
 	}
 
 	return &g.Output, nil
 }
+
+// exportDataPkgs holds the standard library packages that the loader
+// imported from export data while graphing the previous source unit,
+// so that graphing several source units in one process (see
+// GraphCmd.Pkgs and GraphCmd.Units) imports each of them only once.
+//
+// Other packages are imported again for each source unit: their export
+// data may refer to a package that a later source unit type-checks
+// from source, and the two packages would have different types.
+var exportDataPkgs exportDataCache
+
+type exportDataCache struct {
+	build exportDataBuild // the build context they were imported with
+	pkgs  map[string]*types.Package
+}
+
+// exportDataBuild holds the parts of the build context that determine
+// which export data the loader imports.
+type exportDataBuild struct {
+	GOROOT, GOOS, GOARCH, InstallSuffix string
+}
+
+func currentExportDataBuild() exportDataBuild {
+	b := loaderConfig.Build
+	return exportDataBuild{b.GOROOT, b.GOOS, b.GOARCH, b.InstallSuffix}
+}
+
+// get returns a copy of the kept packages for the loader to use as its
+// package map (which it adds to) to graph the package importPath. It
+// returns nil if they were imported with a different build context, or
+// if one of them is importPath (whose package is type-checked from
+// source).
+func (c *exportDataCache) get(importPath string) map[string]*types.Package {
+	if c.build != currentExportDataBuild() || c.pkgs[importPath] != nil {
+		c.pkgs = nil
+	}
+	if c.pkgs == nil {
+		return nil
+	}
+	pkgs := make(map[string]*types.Package, len(c.pkgs))
+	for path, pkg := range c.pkgs {
+		pkgs[path] = pkg
+	}
+	return pkgs
+}
+
+// keep records the standard library packages in prog's package map,
+// unless one of them was type-checked from source.
+func (c *exportDataCache) keep(prog *loader.Program) {
+	c.build, c.pkgs = currentExportDataBuild(), map[string]*types.Package{}
+	for path, pkg := range prog.ImportMap {
+		if !gosrc.IsGoRepoPath(path) {
+			continue
+		}
+		if info := prog.AllPackages[pkg]; info != nil && len(info.Files) > 0 {
+			c.pkgs = nil
+			return
+		}
+		c.pkgs[path] = pkg
+	}
+}
+
+// createFromFilenames is like loaderConfig.CreateFromFilenames, except
+// that a file with parse errors doesn't prevent the package from being
+// created: the partial syntax tree that the parser returns is used
+// instead. It logs and returns the parse errors.
+func createFromFilenames(path string, filenames []string) []error {
+	var files []*ast.File
+	var errs []error
+	for _, filename := range filenames {
+		var src io.ReadCloser
+		if loaderConfig.Build.OpenFile != nil {
+			var err error
+			if src, err = loaderConfig.Build.OpenFile(filename); err != nil {
+				warnf("%s", err)
+				errs = append(errs, err)
+				continue
+			}
+		}
+		var file *ast.File
+		var err error
+		if src != nil {
+			file, err = loaderConfig.ParseFile(filename, src)
+			src.Close()
+		} else {
+			file, err = loaderConfig.ParseFile(filename, nil)
+		}
+		if err != nil {
+			warnf("%s", err)
+			errs = append(errs, err)
+		}
+		if file != nil {
+			files = append(files, file)
+		}
+	}
+	loaderConfig.CreateFromFiles(path, files...)
+	return errs
+}
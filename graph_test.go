@@ -0,0 +1,91 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+func TestExportDataPkgs(t *testing.T) {
+	origBuildContext, origExportDataPkgs := buildContext, exportDataPkgs
+	defer func() {
+		buildContext, exportDataPkgs = origBuildContext, origExportDataPkgs
+		loaderConfig.Build = &buildContext
+	}()
+	buildContext = build.Default
+	buildContext.GOOS = "linux"
+	loaderConfig.Build = &buildContext
+	exportDataPkgs = exportDataCache{}
+
+	// newProg returns a program whose package map has packages with
+	// the given paths, which were imported from export data unless
+	// they're in fromSource.
+	newProg := func(paths []string, fromSource ...string) *loader.Program {
+		prog := &loader.Program{ImportMap: map[string]*types.Package{}, AllPackages: map[*types.Package]*loader.PackageInfo{}}
+		for _, path := range paths {
+			pkg := types.NewPackage(path, "p")
+			prog.ImportMap[path] = pkg
+			info := &loader.PackageInfo{Pkg: pkg, Importable: true}
+			for _, s := range fromSource {
+				if s == path {
+					info.Files = []*ast.File{{}}
+				}
+			}
+			prog.AllPackages[pkg] = info
+		}
+		return prog
+	}
+	paths := func(pkgs map[string]*types.Package) []string {
+		var paths []string
+		for path := range pkgs {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	if pkgs := exportDataPkgs.get("example.com/a"); pkgs != nil {
+		t.Errorf("got %q before any packages were kept, want none", paths(pkgs))
+	}
+
+	// Only standard library packages are kept.
+	prog := newProg([]string{"fmt", "io", "example.com/b", "./c"}, "example.com/b")
+	exportDataPkgs.keep(prog)
+	pkgs := exportDataPkgs.get("example.com/a")
+	if want := []string{"fmt", "io"}; !reflect.DeepEqual(paths(pkgs), want) {
+		t.Errorf("got %q, want %q", paths(pkgs), want)
+	}
+	if pkgs["fmt"] != prog.ImportMap["fmt"] {
+		t.Error("got a different fmt package, want the one that was kept")
+	}
+
+	// The loader adds to its copy of the packages.
+	pkgs["os"] = types.NewPackage("os", "os")
+	if got, want := paths(exportDataPkgs.get("example.com/a")), []string{"fmt", "io"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q after the loader added a package, want %q", got, want)
+	}
+
+	// None are kept if a standard library package is type-checked
+	// from source (because the others may refer to it).
+	exportDataPkgs.keep(newProg([]string{"fmt", "io"}, "io"))
+	if pkgs := exportDataPkgs.get("example.com/a"); pkgs != nil {
+		t.Errorf("got %q after io was type-checked from source, want none", paths(pkgs))
+	}
+
+	// None are used to graph one of them, or with another build
+	// context.
+	exportDataPkgs.keep(newProg([]string{"fmt", "io"}))
+	if pkgs := exportDataPkgs.get("io"); pkgs != nil {
+		t.Errorf("got %q to graph io, want none", paths(pkgs))
+	}
+	exportDataPkgs.keep(newProg([]string{"fmt", "io"}))
+	buildContext.GOOS = "windows"
+	if pkgs := exportDataPkgs.get("example.com/a"); pkgs != nil {
+		t.Errorf("got %q for another GOOS, want none", paths(pkgs))
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// defaultCacheDir is the directory (relative to the repository root)
+// where cached output is stored, unless --cache-dir is set.
+const defaultCacheDir = ".srclib-cache"
+
+// graphCacheDir is the directory (relative to the cache dir) where the
+// graph command stores the output for each source unit, so that units
+// whose inputs have not changed aren't graphed again.
+const graphCacheDir = "srclib-go/graph"
+
+// cacheDir returns the directory where cached output is stored: the
+// --cache-dir (made absolute by prepareCacheDir), or else
+// defaultCacheDir in the repository root.
+func cacheDir() string {
+	if globalOpt.CacheDir != "" {
+		return globalOpt.CacheDir
+	}
+	return filepath.Join(cwd, defaultCacheDir)
+}
+
+// prepareCacheDir makes the --cache-dir absolute (relative to the
+// repository root), so that it doesn't change when the graph command
+// changes directories, and creates it if it's missing. It returns an
+// error if the directory can't be written to.
+func prepareCacheDir() error {
+	dir := globalOpt.CacheDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cwd, dir)
+	}
+	globalOpt.CacheDir = filepath.Clean(dir)
+
+	if err := os.MkdirAll(globalOpt.CacheDir, 0755); err != nil {
+		return fmt.Errorf("creating --cache-dir failed: %s", err)
+	}
+	f, err := ioutil.TempFile(globalOpt.CacheDir, ".write-test")
+	if err != nil {
+		return fmt.Errorf("--cache-dir %s is not writable: %s", globalOpt.CacheDir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// graphCacheManifest describes a cached graph output.
+type graphCacheManifest struct {
+	// Unit is the name of the source unit.
+	Unit string
+
+	// Key is the hash of all of the inputs to graphing the unit (see
+	// graphCacheKey).
+	Key string
+
+	// Errors are the parse and type-checking errors encountered while
+	// graphing the unit.
+	Errors []graphError `json:",omitempty"`
+}
+
+// graphCachePaths returns the paths of the manifest and the graph output
+// files for the source unit u.
+func graphCachePaths(u *unit.SourceUnit) (manifest, output string) {
+	name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(u.Name)
+	dir := filepath.Join(cacheDir(), graphCacheDir, name)
+	return filepath.Join(dir, "manifest.json"), filepath.Join(dir, "graph.json")
+}
+
+// readGraphCache returns the cached graph output for u and the errors
+// encountered while computing it, or nil if there is none or if it was
+// computed with different inputs (as identified by key).
+func readGraphCache(u *unit.SourceUnit, key string) ([]byte, []graphError) {
+	manifestFile, outputFile := graphCachePaths(u)
+	data, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return nil, nil
+	}
+	var m graphCacheManifest
+	if err := json.Unmarshal(data, &m); err != nil || m.Unit != u.Name || m.Key != key {
+		return nil, nil
+	}
+	output, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		return nil, nil
+	}
+	return output, m.Errors
+}
+
+// writeGraphCache stores the graph output for u (and the errors
+// encountered while computing it), computed from inputs identified by
+// key.
+func writeGraphCache(u *unit.SourceUnit, key string, output []byte, errs []graphError) error {
+	manifestFile, outputFile := graphCachePaths(u)
+	if err := os.MkdirAll(filepath.Dir(manifestFile), 0755); err != nil {
+		return err
+	}
+	manifest, err := json.MarshalIndent(graphCacheManifest{Unit: u.Name, Key: key, Errors: errs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write the output first so that a manifest never refers to a
+	// partially written output.
+	if err := ioutil.WriteFile(outputFile, output, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifestFile, manifest, 0644)
+}
+
+// dataManifestFile is the file (relative to the cache dir) that `graph
+// --manifest` writes the dataManifest to.
+const dataManifestFile = "srclib-go-manifest.json"
+
+// A dataManifest lists the build data files in the graph cache, so that
+// they can be found (for example, by push) without walking the cache
+// dir.
+type dataManifest struct {
+	Files []*dataManifestEntry
+}
+
+// A dataManifestEntry describes a build data file in the graph cache.
+type dataManifestEntry struct {
+	// Path is the path of the file, relative to the cache dir and
+	// slash-separated.
+	Path string
+
+	// Size is the size of the file in bytes.
+	Size int64
+
+	// Unit is the name of the source unit that the file belongs to.
+	Unit string
+}
+
+// writeDataManifest writes a dataManifest listing the graph output and
+// manifest files of each source unit in the graph cache to
+// dataManifestFile, replacing it atomically.
+func writeDataManifest() error {
+	dir := filepath.Join(cacheDir(), graphCacheDir)
+	unitDirs, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	m := dataManifest{Files: []*dataManifestEntry{}}
+	for _, fi := range unitDirs {
+		if !fi.IsDir() {
+			continue
+		}
+		manifestFile := filepath.Join(dir, fi.Name(), "manifest.json")
+		data, err := ioutil.ReadFile(manifestFile)
+		if err != nil {
+			continue
+		}
+		var cm graphCacheManifest
+		if err := json.Unmarshal(data, &cm); err != nil {
+			warnf("reading graph cache manifest %s failed: %s (omitting it from the --manifest)", manifestFile, err)
+			continue
+		}
+		for _, name := range []string{"graph.json", "manifest.json"} {
+			file := filepath.Join(dir, fi.Name(), name)
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			rel, err := filepath.Rel(cacheDir(), file)
+			if err != nil {
+				return err
+			}
+			m.Files = append(m.Files, &dataManifestEntry{Path: filepath.ToSlash(rel), Size: info.Size(), Unit: cm.Unit})
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(cacheDir(), dataManifestFile+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err == nil {
+		err = f.Chmod(0644)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(cacheDir(), dataManifestFile))
+}
+
+// graphCacheKey returns a hash of all of the inputs to graphing the
+// source unit u: the srclib-go executable and the Go toolchain version,
+// the source unit (including its config, such as build tags), the build
+// context and import resolution options, and the contents of the unit's
+// files and of the files of all (non-stdlib) packages that it
+// transitively imports.
+func graphCacheKey(u *unit.SourceUnit) (string, error) {
+	h := sha256.New()
+
+	exe, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return "", err
+	}
+	if err := hashFile(h, exe); err != nil {
+		return "", err
+	}
+	fmt.Fprintln(h, runtime.Version())
+	goVersionCmd := exec.Command(goTool(), "version")
+	goVersionCmd.Env = config.env()
+	goVersion, err := goVersionCmd.Output()
+	if err != nil {
+		return "", err
+	}
+	h.Write(goVersion)
+
+	unitJSON, err := json.Marshal(u)
+	if err != nil {
+		return "", err
+	}
+	h.Write(unitJSON)
+	fmt.Fprintln(h, buildContext.GOOS, buildContext.GOARCH, buildContext.GOROOT, buildContext.GOPATH, buildContext.BuildTags)
+	fmt.Fprintf(h, "%+v\n", resolveOpt)
+	repos, err := readRepoMap()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "repo-map %v\n", repos)
+	fmt.Fprintln(h, "go-language-version", goLanguageVersion())
+	fmt.Fprintln(h, "callgraph", graphCmd.Callgraph, "control-flow", graphCmd.ControlFlow, "defkey-scheme", graphCmd.DefKeyScheme)
+	fmt.Fprintln(h, "hide-unexported", graphCmd.HideUnexported, "keep-returned-types", graphCmd.KeepReturnedTypes)
+	fmt.Fprintln(h, "ref-snippets", graphCmd.RefSnippets, "all-platforms", graphCmd.AllPlatforms, "printf-analysis", graphCmd.PrintfAnalysis, "offsets", graphCmd.Offsets)
+
+	files := append([]string{}, u.Files...)
+	sort.Strings(files)
+	for _, f := range files {
+		if err := hashFile(h, filepath.Join(cwd, f)); err != nil {
+			return "", err
+		}
+	}
+
+	pkg, err := UnitDataAsBuildPackage(u)
+	if err != nil {
+		return "", err
+	}
+	if xtest, err := isXTestUnit(u); err != nil {
+		return "", err
+	} else if xtest {
+		// The external test package is type-checked against the
+		// package and its in-package tests, whose files are in the
+		// package's unit.
+		for _, f := range append(append(append([]string{}, pkg.GoFiles...), pkg.CgoFiles...), pkg.TestGoFiles...) {
+			if err := hashFile(h, filepath.Join(cwd, pkg.Dir, f)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	var hashImports func(dir string, imports []string) error
+	hashImports = func(dir string, imports []string) error {
+		sorted := append([]string{}, imports...)
+		sort.Strings(sorted)
+		for _, imp := range sorted {
+			if imp == "C" || seen[imp] {
+				continue
+			}
+			seen[imp] = true
+			dep, err := buildContext.Import(imp, dir, 0)
+			if err != nil {
+				// Hash the failure, so that the key changes when
+				// the import becomes resolvable.
+				fmt.Fprintln(h, imp, "not found")
+				continue
+			}
+			if dep.Goroot {
+				// covered by runtime.Version above
+				continue
+			}
+			fmt.Fprintln(h, imp, dep.Dir)
+			for _, f := range append(append([]string{}, dep.GoFiles...), dep.CgoFiles...) {
+				if err := hashFile(h, filepath.Join(dep.Dir, f)); err != nil {
+					return err
+				}
+			}
+			if err := hashImports(dep.Dir, dep.Imports); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	imports := append(append(append([]string{}, pkg.Imports...), pkg.TestImports...), pkg.XTestImports...)
+	if err := hashImports(filepath.Join(cwd, pkg.Dir), imports); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(h hash.Hash, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Fprintln(h, filename)
+	_, err = io.Copy(h, f)
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// graphLine is a line of `graph --format jsonl` output. Exactly one of
+// its fields is set, except in the first line, which only has the
+// GoVersion and Offsets (see graphOutput).
+type graphLine struct {
+	GoVersion      string          `json:",omitempty"`
+	Offsets        string          `json:",omitempty"`
+	Def            *graph.Def      `json:",omitempty"`
+	Ref            *ref            `json:",omitempty"`
+	Doc            *graph.Doc      `json:",omitempty"`
+	Implementation *implementation `json:",omitempty"`
+	Example        *example        `json:",omitempty"`
+	Call           *call           `json:",omitempty"`
+	ControlFlow    *controlFlow    `json:",omitempty"`
+}
+
+// newGraphLine returns the graphLine for a def, ref, doc,
+// implementation, example, call, or control flow (as emitted by
+// graphUnit), after making its file path relative to the repository.
+func newGraphLine(item interface{}) *graphLine {
+	switch item := item.(type) {
+	case *graph.Def:
+		if item.File != "" {
+			item.File = relPath(cwd, item.File)
+		}
+		return &graphLine{Def: item}
+	case *ref:
+		if item.File != "" {
+			item.File = relPath(cwd, item.File)
+		}
+		return &graphLine{Ref: item}
+	case *graph.Doc:
+		if item.File != "" {
+			item.File = relPath(cwd, item.File)
+		}
+		return &graphLine{Doc: item}
+	case *implementation:
+		return &graphLine{Implementation: item}
+	case *example:
+		if item.File != "" {
+			item.File = relPath(cwd, item.File)
+		}
+		return &graphLine{Example: item}
+	case *call:
+		if item.File != "" {
+			item.File = relPath(cwd, item.File)
+		}
+		return &graphLine{Call: item}
+	case *controlFlow:
+		if item.File != "" {
+			item.File = relPath(cwd, item.File)
+		}
+		return &graphLine{ControlFlow: item}
+	}
+	panic("unexpected graph output item")
+}
+
+// streamGraphJSONL graphs the source unit and writes each def, ref,
+// doc, implementation, example, call, and control flow to w as a JSON
+// object on its own line as soon as it's converted, instead of
+// collecting and sorting the whole output first. The lines are in the
+// order that the grapher produced them, which may differ between runs.
+func streamGraphJSONL(ctx context.Context, w io.Writer, u *unit.SourceUnit) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if v, offsets := goLanguageVersion(), graphCmd.outputOffsets(); v != "" || offsets != "" {
+		if err := enc.Encode(&graphLine{GoVersion: v, Offsets: offsets}); err != nil {
+			return err
+		}
+	}
+	if err := graphUnit(ctx, u, func(item interface{}) error {
+		return enc.Encode(newGraphLine(item))
+	}); err != nil {
+		bw.Flush()
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeJSONL writes o (which must already be finished) to w in the
+// `graph --format jsonl` format.
+func (o *graphOutput) writeJSONL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if o.GoVersion != "" || o.Offsets != "" {
+		if err := enc.Encode(&graphLine{GoVersion: o.GoVersion, Offsets: o.Offsets}); err != nil {
+			return err
+		}
+	}
+	for _, d := range o.Defs {
+		if err := enc.Encode(&graphLine{Def: d}); err != nil {
+			return err
+		}
+	}
+	for _, r := range o.Refs {
+		if err := enc.Encode(&graphLine{Ref: r}); err != nil {
+			return err
+		}
+	}
+	for _, d := range o.Docs {
+		if err := enc.Encode(&graphLine{Doc: d}); err != nil {
+			return err
+		}
+	}
+	for _, i := range o.Implementations {
+		if err := enc.Encode(&graphLine{Implementation: i}); err != nil {
+			return err
+		}
+	}
+	for _, e := range o.Examples {
+		if err := enc.Encode(&graphLine{Example: e}); err != nil {
+			return err
+		}
+	}
+	for _, c := range o.Calls {
+		if err := enc.Encode(&graphLine{Call: c}); err != nil {
+			return err
+		}
+	}
+	for _, f := range o.ControlFlows {
+		if err := enc.Encode(&graphLine{ControlFlow: f}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// executePkgs implements `graph --pkg IMPORT-PATH...`: it scans the
+// repository (as `scan` does, with the default Srcfile config) for the
+// named packages and graphs each of them in turn (still loading their
+// imports for type information), writing each one's output to stdout.
+func (c *GraphCmd) executePkgs() error {
+	if os.Getenv("IN_DOCKER_CONTAINER") != "" {
+		return fmt.Errorf("graph --pkg is not supported in a Docker container (graph each source unit separately instead)")
+	}
+
+	config = &srcfileConfig{}
+	units, err := (&ScanCmd{Repo: globalOpt.RepoURI}).scanUnits()
+	if err != nil {
+		return err
+	}
+	if units, err = selectPkgs(units, c.Pkgs); err != nil {
+		return err
+	}
+
+	var errs []graphError
+	for _, u := range units {
+		unitErrs, err := c.graphSourceUnit(u)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, unitErrs...)
+	}
+	return c.finish(errs)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// executeStdin implements `graph --stdin --file FILE`: it graphs the
+// package containing FILE, with FILE's contents read from stdin instead
+// of from disk, and writes the defs, refs, docs, and examples in FILE.
+// The output isn't cached.
+func (c *GraphCmd) executeStdin() error {
+	if c.File == "" {
+		return fmt.Errorf("--stdin requires --file")
+	}
+	filename := c.File
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(cwd, filename)
+	}
+	filename = filepath.Clean(filename)
+
+	contents, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if err := os.Stdin.Close(); err != nil {
+		return err
+	}
+
+	config = &srcfileConfig{}
+	if err := config.apply(); err != nil {
+		return err
+	}
+	overlay, err := c.overlay()
+	if err != nil {
+		return err
+	}
+	overlay[filename] = contents
+	overlayFiles(overlay)
+
+	pkg, err := buildContext.ImportDir(filepath.Dir(filename), 0)
+	if err != nil {
+		return err
+	}
+	if pkg.ImportPath == "." || !pathHasPrefix(pkg.Dir, cwd) {
+		return fmt.Errorf("graph --stdin: %s is not in a package in the current directory tree (and in the GOPATH)", c.File)
+	}
+	if pkg.Dir, err = filepath.Rel(cwd, pkg.Dir); err != nil {
+		return err
+	}
+	u := &unit.SourceUnit{Name: pkg.ImportPath, Type: "GoPackage", Dir: pkg.Dir, Data: pkg}
+	overrideRepo(u)
+	useSourceImportsForOverlay(overlay, filepath.Dir(filename))
+
+	ctx, cancel := timeoutContext("graphing " + c.File)
+	defer cancel()
+	out, err := Graph(ctx, u)
+	if err != nil {
+		return err
+	}
+	out.finish()
+	out.onlyFile(relPath(cwd, filename))
+
+	if err := c.handleErrors(recordedGraphErrors()); err != nil {
+		return err
+	}
+	if c.Format == "jsonl" {
+		return out.writeJSONL(os.Stdout)
+	}
+	return json.NewEncoder(os.Stdout).Encode(out)
+}
+
+// onlyFile removes the defs, refs, docs, examples, calls, and control
+// flows in o that aren't in file.
+func (o *graphOutput) onlyFile(file string) {
+	defs := o.Defs[:0]
+	for _, d := range o.Defs {
+		if d.File == file {
+			defs = append(defs, d)
+		}
+	}
+	o.Defs = defs
+
+	refs := o.Refs[:0]
+	for _, r := range o.Refs {
+		if r.File == file {
+			refs = append(refs, r)
+		}
+	}
+	o.Refs = refs
+
+	docs := o.Docs[:0]
+	for _, d := range o.Docs {
+		if d.File == file {
+			docs = append(docs, d)
+		}
+	}
+	o.Docs = docs
+
+	examples := o.Examples[:0]
+	for _, e := range o.Examples {
+		if e.File == file {
+			examples = append(examples, e)
+		}
+	}
+	o.Examples = examples
+
+	calls := o.Calls[:0]
+	for _, c := range o.Calls {
+		if c.File == file {
+			calls = append(calls, c)
+		}
+	}
+	o.Calls = calls
+
+	flows := o.ControlFlows[:0]
+	for _, f := range o.ControlFlows {
+		if f.File == file {
+			flows = append(flows, f)
+		}
+	}
+	o.ControlFlows = flows
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// executeUnits implements `graph --units FILE`: it graphs each of the
+// source units in FILE (the output of a previous scan) in turn, writing
+// each one's output to stdout, so that scan and graph agree on the set
+// of source units without discovering them again. With --pkg, only the
+// named units in FILE are graphed.
+func (c *GraphCmd) executeUnits() error {
+	if os.Getenv("IN_DOCKER_CONTAINER") != "" {
+		return fmt.Errorf("graph --units is not supported in a Docker container (graph each source unit separately instead)")
+	}
+
+	units, err := readScanUnits(c.Units)
+	if err != nil {
+		return err
+	}
+	if len(c.Pkgs) > 0 {
+		if units, err = selectPkgs(units, c.Pkgs); err != nil {
+			return err
+		}
+	}
+
+	var errs []graphError
+	for _, u := range units {
+		unitErrs, err := c.graphSourceUnit(u)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, unitErrs...)
+	}
+	return c.finish(errs)
+}
+
+// readScanUnits reads the source units in file (a JSON array, as
+// written by scan) and checks that srclib-go scanned them, with the
+// same Go language version that they would now be graphed with.
+func readScanUnits(file string) ([]*unit.SourceUnit, error) {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(cwd, file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading --units failed: %s", err)
+	}
+	var units []*unit.SourceUnit
+	if err := json.Unmarshal(data, &units); err != nil {
+		return nil, fmt.Errorf("parsing --units %s failed: %s (it should be the output of scan)", file, err)
+	}
+	if len(units) == 0 {
+		return nil, fmt.Errorf("--units %s has no source units", file)
+	}
+
+	goVersion := goLanguageVersion()
+	for _, u := range units {
+		if u == nil || u.Type != "GoPackage" {
+			var name, typ string
+			if u != nil {
+				name, typ = u.Name, u.Type
+			}
+			return nil, fmt.Errorf("--units %s has a source unit %q of type %q, not GoPackage (it should be the output of srclib-go scan)", file, name, typ)
+		}
+		var d struct{ GoVersion string }
+		if data, err := json.Marshal(u.Data); err != nil {
+			return nil, err
+		} else if err := json.Unmarshal(data, &d); err != nil {
+			return nil, fmt.Errorf("--units %s has a source unit %s with invalid data: %s", file, u.Name, err)
+		}
+		if d.GoVersion != goVersion {
+			return nil, fmt.Errorf("source unit %s in --units %s was scanned as Go %q, but would be graphed as Go %q (scan again, or set the same --go-version)", u.Name, file, d.GoVersion, goVersion)
+		}
+	}
+	return units, nil
+}
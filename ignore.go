@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// srclibIgnoreFile is the name of the file that lists (using
+// gitignore-style patterns) the files and dirs that the scanner should
+// skip. It may appear at the repository root and in any subdirectory;
+// patterns are evaluated relative to the dir containing the file.
+const srclibIgnoreFile = ".srclibignore"
+
+// ignoreRule is a single pattern from a .srclibignore file.
+type ignoreRule struct {
+	dir      string // dir containing the .srclibignore file (slash-separated, relative to the root)
+	pattern  string
+	negate   bool // pattern was prefixed with "!"
+	dirOnly  bool // pattern was suffixed with "/"
+	anchored bool // pattern contains a "/" and is matched against the path relative to dir
+}
+
+// srclibIgnore matches paths against the .srclibignore files in a
+// directory tree. It reads each dir's .srclibignore file at most once.
+type srclibIgnore struct {
+	root  string
+	rules map[string][]ignoreRule
+}
+
+func newSrclibIgnore(root string) *srclibIgnore {
+	return &srclibIgnore{root: root, rules: map[string][]ignoreRule{}}
+}
+
+// Ignored returns whether the slash-separated path p (relative to the
+// root) is ignored. As with gitignore, a path underneath an ignored
+// dir is ignored even if a later pattern would re-include it.
+func (ig *srclibIgnore) Ignored(p string, isDir bool) bool {
+	p = path.Clean(p)
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return false
+	}
+	elems := strings.Split(p, "/")
+	for i := 1; i < len(elems); i++ {
+		if ig.matches(strings.Join(elems[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.matches(p, isDir)
+}
+
+// matches evaluates all rules that apply to p, from the root's
+// .srclibignore down to the one in p's parent dir. The last matching
+// rule wins.
+func (ig *srclibIgnore) matches(p string, isDir bool) bool {
+	var ignored bool
+	dir := "."
+	elems := strings.Split(p, "/")
+	for i := 0; i < len(elems); i++ {
+		if i > 0 {
+			dir = strings.Join(elems[:i], "/")
+		}
+		for _, r := range ig.rulesIn(dir) {
+			if r.match(p, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// removeIgnoredFiles removes the files that are ignored from pkg's file
+// lists (GoFiles, TestGoFiles, and the other fields named *Files), and
+// returns whether there were any. pkg.Dir must be relative to the root.
+func (ig *srclibIgnore) removeIgnoredFiles(pkg *build.Package) bool {
+	dir := filepath.ToSlash(pkg.Dir)
+	var removed bool
+	pv, pt := reflect.ValueOf(pkg).Elem(), reflect.TypeOf(*pkg)
+	for i := 0; i < pt.NumField(); i++ {
+		if !strings.HasSuffix(pt.Field(i).Name, "Files") {
+			continue
+		}
+		fv := pv.Field(i)
+		var files []string
+		for _, f := range fv.Interface().([]string) {
+			if ig.Ignored(path.Join(dir, f), false) {
+				removed = true
+			} else {
+				files = append(files, f)
+			}
+		}
+		fv.Set(reflect.ValueOf(files))
+	}
+	return removed
+}
+
+func (ig *srclibIgnore) rulesIn(dir string) []ignoreRule {
+	if rules, present := ig.rules[dir]; present {
+		return rules
+	}
+	rules, _ := readIgnoreRules(filepath.Join(ig.root, filepath.FromSlash(dir), srclibIgnoreFile), dir)
+	ig.rules[dir] = rules
+	return rules
+}
+
+// readIgnoreRules parses the .srclibignore file at filename, whose
+// patterns are relative to dir.
+func readIgnoreRules(filename, dir string) ([]ignoreRule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules, s.Err()
+}
+
+func (r *ignoreRule) match(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.dir != "." {
+		if !strings.HasPrefix(p, r.dir+"/") {
+			return false
+		}
+		p = p[len(r.dir)+1:]
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, path.Base(p))
+		return ok
+	}
+	return matchGlobPath(strings.Split(r.pattern, "/"), strings.Split(p, "/"))
+}
+
+// matchGlobPath matches path elements against pattern elements, where
+// each pattern element uses path.Match syntax and a "**" element
+// matches zero or more path elements.
+func matchGlobPath(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elems); i++ {
+			if matchGlobPath(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+		return false
+	}
+	return matchGlobPath(pattern[1:], elems[1:])
+}
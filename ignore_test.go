@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeTestFiles writes files (whose paths are slash-separated and
+// relative to dir) with the given contents.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSrclibIgnore(t *testing.T) {
+	root, err := ioutil.TempDir("", "srclib-go-ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFiles(t, root, map[string]string{
+		".srclibignore": `# comment
+*.pb.go
+!keep.pb.go
+gen/
+/top.go
+docs/**/*.go
+`,
+		// Patterns in a subdirectory's file are relative to it.
+		"sub/.srclibignore": `local.go
+/anch.go
+!sub.pb.go
+`,
+	})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		// unanchored, and negated
+		{path: "a.pb.go", want: true},
+		{path: "x/y/a.pb.go", want: true},
+		{path: "keep.pb.go", want: false},
+		{path: "x/keep.pb.go", want: false},
+
+		// dir-only
+		{path: "gen", isDir: true, want: true},
+		{path: "x/gen", isDir: true, want: true},
+		{path: "gen", want: false},
+		{path: "x/gen/a.go", want: true},
+		{path: "gen/keep.pb.go", want: true}, // can't be re-included from an ignored dir
+
+		// anchored
+		{path: "top.go", want: true},
+		{path: "x/top.go", want: false},
+
+		// **
+		{path: "docs/a.go", want: true},
+		{path: "docs/a/b/c.go", want: true},
+		{path: "docs/a/b/c.txt", want: false},
+		{path: "x/docs/a.go", want: false},
+
+		// sub/.srclibignore
+		{path: "sub/local.go", want: true},
+		{path: "sub/x/local.go", want: true},
+		{path: "local.go", want: false},
+		{path: "sub/anch.go", want: true},
+		{path: "sub/x/anch.go", want: false},
+		{path: "anch.go", want: false},
+		{path: "sub/sub.pb.go", want: false},
+		{path: "sub.pb.go", want: true},
+
+		// outside of the root
+		{path: ".", isDir: true, want: false},
+		{path: "../a.pb.go", want: false},
+	}
+	ig := newSrclibIgnore(root)
+	for _, test := range tests {
+		if got := ig.Ignored(test.path, test.isDir); got != test.want {
+			t.Errorf("%s (dir: %v): got ignored %v, want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+}
+
+func TestRemoveIgnoredFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "srclib-go-ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFiles(t, root, map[string]string{".srclibignore": "*.pb.go\n*_gen_test.go\n"})
+
+	pkg := &build.Package{
+		Dir:            "p",
+		GoFiles:        []string{"p.go", "p.pb.go"},
+		CgoFiles:       []string{"c.pb.go"},
+		TestGoFiles:    []string{"p_test.go", "p_gen_test.go"},
+		XTestGoFiles:   []string{"x_gen_test.go"},
+		IgnoredGoFiles: []string{"p_windows.go", "w.pb.go"},
+	}
+	if !newSrclibIgnore(root).removeIgnoredFiles(pkg) {
+		t.Error("got no files removed, want some")
+	}
+	want := &build.Package{
+		Dir:            "p",
+		GoFiles:        []string{"p.go"},
+		TestGoFiles:    []string{"p_test.go"},
+		IgnoredGoFiles: []string{"p_windows.go"},
+	}
+	if !reflect.DeepEqual(pkg, want) {
+		t.Errorf("got %+v, want %+v", pkg, want)
+	}
+	if newSrclibIgnore(root).removeIgnoredFiles(pkg) {
+		t.Error("got files removed again, want none")
+	}
+}
+
+// TestGraphIgnoredFiles tests that the files matched by .srclibignore
+// contribute no defs or refs (even though the loader would otherwise
+// load all of the files in the package's dir).
+func TestGraphIgnoredFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tmp, "gopath")
+	root := filepath.Join(gopath, "src", "example.com", "repo")
+	writeTestFiles(t, root, map[string]string{
+		".srclibignore":          "zz_*.go\n",
+		"p/p.go":                 "package p\n\nfunc A() {}\n",
+		"p/p_test.go":            "package p\n\nvar T = A\n",
+		"p/zz_generated.go":      "package p\n\nvar Generated = A\n",
+		"p/zz_generated_test.go": "package p\n\nvar GeneratedTest = A\n",
+	})
+
+	origBuildContext, origBuildDefault := buildContext, build.Default
+	defer func() {
+		buildContext, build.Default = origBuildContext, origBuildDefault
+		loaderConfig.Build = &buildContext
+	}()
+	defer setTestRepo(t, root, gopath, nil)()
+
+	pkg, err := buildContext.ImportDir(filepath.Join(root, "p"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg.Dir = "p"
+
+	o, err := doGraph(context.Background(), pkg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var defs []string
+	for _, def := range o.Defs {
+		if def.Name != "p" {
+			defs = append(defs, def.Name)
+		}
+		if f := filepath.Base(def.File); f != "p.go" && f != "p_test.go" {
+			t.Errorf("got def %s in ignored file %s", def.Name, f)
+		}
+	}
+	for _, ref := range o.Refs {
+		if f := filepath.Base(ref.File); f != "p.go" && f != "p_test.go" {
+			t.Errorf("got ref in ignored file %s", f)
+		}
+	}
+	sort.Strings(defs)
+	if want := []string{"A", "T"}; !reflect.DeepEqual(defs, want) {
+		t.Errorf("got defs %q, want %q", defs, want)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// ignoredFile is a file in a package's dir that the build constraints
+// exclude from the package (see ScanCmd.ReportIgnored).
+type ignoredFile struct {
+	File string // relative to the repository root
+
+	// Reason is why the file is excluded: "ignore" (it has an
+	// "ignore" build constraint), "goos/goarch" (its file name has a
+	// GOOS or GOARCH suffix that doesn't match), "cgo" (it imports "C"
+	// but cgo is disabled), "build-constraint" (its build constraint
+	// isn't satisfied by the GOOS, GOARCH, and build tags), or
+	// "unknown".
+	Reason string
+
+	// Constraint is the file's build constraint, if any (in //go:build
+	// syntax if the file has a //go:build line, or else the // +build
+	// lines joined by newlines).
+	Constraint string `json:",omitempty"`
+}
+
+// ignoredFiles returns the files that build constraints exclude from
+// pkg (whose Dir is relative to the current dir), with the reason that
+// each is excluded. The returned files are relative to subdir.
+func ignoredFiles(pkg *build.Package, subdir string) []*ignoredFile {
+	var ignored []*ignoredFile
+	for _, name := range pkg.IgnoredGoFiles {
+		f := &ignoredFile{File: filepath.Join(subdir, pkg.Dir, name)}
+		f.Reason, f.Constraint = ignoredReason(filepath.Join(cwd, pkg.Dir), name)
+		ignored = append(ignored, f)
+	}
+	return ignored
+}
+
+// ignoredReason determines why the build context excludes the file
+// name in dir (see ignoredFile for the reasons).
+func ignoredReason(dir, name string) (reason, constraint string) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "unknown", ""
+	}
+	constraint, ignoreTag := buildConstraint(data)
+	if ignoreTag {
+		return "ignore", constraint
+	}
+
+	// Check the file name alone, with the file's contents replaced by
+	// a bare package clause.
+	nameOnly := buildContext
+	nameOnly.OpenFile = func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("package p\n")), nil
+	}
+	if ok, err := nameOnly.MatchFile(dir, name); err == nil && !ok {
+		return "goos/goarch", constraint
+	}
+
+	if constraint != "" {
+		noConstraint := buildContext
+		noConstraint.OpenFile = func(string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(stripBuildConstraints(data))), nil
+		}
+		if ok, err := noConstraint.MatchFile(dir, name); err == nil && ok {
+			return "build-constraint", constraint
+		}
+	}
+
+	if !buildContext.CgoEnabled && bytes.Contains(data, []byte(`"C"`)) {
+		return "cgo", constraint
+	}
+	return "unknown", constraint
+}
+
+// buildConstraint returns the build constraint in the header (the
+// comments before the package clause) of the Go source file data, and
+// whether the constraint mentions the "ignore" tag.
+func buildConstraint(data []byte) (constraint string, ignoreTag bool) {
+	var goBuild string
+	var plusBuild []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if strings.HasPrefix(line, "//go:build ") {
+			goBuild = strings.TrimSpace(strings.TrimPrefix(line, "//go:build"))
+		} else if strings.HasPrefix(line, "// +build ") {
+			plusBuild = append(plusBuild, strings.TrimSpace(strings.TrimPrefix(line, "// +build")))
+		}
+	}
+	if goBuild != "" {
+		constraint = goBuild
+	} else {
+		constraint = strings.Join(plusBuild, "\n")
+	}
+	for _, term := range strings.FieldsFunc(constraint, func(r rune) bool {
+		return !(r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	}) {
+		if term == "ignore" {
+			ignoreTag = true
+		}
+	}
+	return constraint, ignoreTag
+}
+
+// stripBuildConstraints returns data with its build constraint lines
+// blanked out.
+func stripBuildConstraints(data []byte) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		trimmed := bytes.TrimSpace(line)
+		if bytes.HasPrefix(trimmed, []byte("package ")) {
+			break
+		}
+		if bytes.HasPrefix(trimmed, []byte("//go:build ")) || bytes.HasPrefix(trimmed, []byte("// +build ")) {
+			lines[i] = []byte("\n")
+		}
+	}
+	return bytes.Join(lines, nil)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/gcimporter"
+	"golang.org/x/tools/go/types"
+)
+
+// isLocalImport reports whether imp is a local (relative) import path,
+// such as "./subpkg" or "../other", or the pseudo import path that the
+// go tool reports for one ("_" followed by the absolute directory).
+func isLocalImport(imp string) bool {
+	return build.IsLocalImport(imp) || strings.HasPrefix(imp, "_/")
+}
+
+// absImportPath converts the local import path imp (see
+// isLocalImport), which appears in a file in dir, to the import path of
+// the package that it refers to. Other import paths are returned
+// unchanged.
+//
+// The package's import path is determined by the GOPATH workspace that
+// contains it or, failing that, by the module path in the
+// repository's go.mod file.
+func absImportPath(imp, dir string) (string, error) {
+	if !isLocalImport(imp) {
+		return imp, nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cwd, dir)
+	}
+	var pkgDir string
+	if strings.HasPrefix(imp, "_/") {
+		pkgDir = filepath.FromSlash(imp[1:])
+	} else {
+		pkgDir = filepath.Join(dir, filepath.FromSlash(imp))
+	}
+
+	if pkg, err := buildContext.ImportDir(pkgDir, build.FindOnly); err == nil && pkg.ImportPath != "." && !strings.HasPrefix(pkg.ImportPath, "_") {
+		return pkg.ImportPath, nil
+	}
+
+	if mod, err := goModule(); err != nil {
+		return "", err
+	} else if mod != nil && mod.Module != "" && pathHasPrefix(pkgDir, cwd) {
+		rel, err := filepath.Rel(cwd, pkgDir)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(mod.Module, filepath.ToSlash(rel)), nil
+	}
+
+	return "", fmt.Errorf("can't resolve local import %q in %s: %s is not in a GOPATH workspace (GOPATH=%s) or in the repository's go.mod module", imp, relPath(cwd, dir), relPath(cwd, pkgDir), buildContext.GOPATH)
+}
+
+// localImporter returns a types.Importer (for packages installed by
+// `go install`) that converts local imports in files in dir to the
+// packages' import paths (with absImportPath), so that refs to them
+// link to the packages' defs.
+//
+// The loader can't import local imports from source, so they remain
+// unresolved when the SourceImports config option is set.
+func localImporter(dir string) types.Importer {
+	return func(imports map[string]*types.Package, imp string) (*types.Package, error) {
+		absImp, err := absImportPath(imp, dir)
+		if err != nil {
+			return nil, err
+		}
+		pkg, err := gcimporter.Import(imports, absImp)
+		if err != nil {
+			return nil, err
+		}
+		imports[imp] = pkg
+		return pkg, nil
+	}
+}
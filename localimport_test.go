@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setTestRepo makes root the repository root (cwd), gopath the GOPATH,
+// and mod the repository's go.mod, and creates dirs (relative to
+// root). The returned func undoes it.
+func setTestRepo(t *testing.T, root, gopath string, mod *goMod, dirs ...string) func() {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	goModCacheOnce.Do(func() {})
+	origCWD, origGOPATH, origMod := cwd, buildContext.GOPATH, goModCache
+	cwd, buildContext.GOPATH, goModCache = root, gopath, mod
+	return func() {
+		cwd, buildContext.GOPATH, goModCache = origCWD, origGOPATH, origMod
+	}
+}
+
+func TestAbsImportPath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-localimport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tmp, "gopath")
+
+	type test struct {
+		imp, dir string
+		want     string
+	}
+	run := func(label string, tests []test) {
+		for _, test := range tests {
+			got, err := absImportPath(test.imp, test.dir)
+			if err != nil {
+				t.Errorf("%s: %q in %s: %s", label, test.imp, test.dir, err)
+				continue
+			}
+			if got != test.want {
+				t.Errorf("%s: %q in %s: got %q, want %q", label, test.imp, test.dir, got, test.want)
+			}
+		}
+	}
+
+	// A repository in the GOPATH.
+	root := filepath.Join(gopath, "src", "example.com", "repo")
+	undo := setTestRepo(t, root, gopath, nil, "cmd/x", "cmd/x/sub", "lib", "cmd/lib")
+	run("GOPATH", []test{
+		{"./sub", "cmd/x", "example.com/repo/cmd/x/sub"},
+		{"../lib", "cmd/x", "example.com/repo/cmd/lib"},
+		{"../../lib", "cmd/x", "example.com/repo/lib"},
+		{"./cmd/x", ".", "example.com/repo/cmd/x"},
+		{"../lib", filepath.Join(root, "cmd/x"), "example.com/repo/cmd/lib"},
+		{"_" + filepath.ToSlash(filepath.Join(root, "lib")), "cmd/x", "example.com/repo/lib"},
+		{"example.com/other", "cmd/x", "example.com/other"},
+	})
+	undo()
+
+	// A module outside of the GOPATH.
+	root = filepath.Join(tmp, "mod")
+	undo = setTestRepo(t, root, gopath, &goMod{Module: "example.com/mod"}, "a", "b/c")
+	run("module", []test{
+		{"./a", ".", "example.com/mod/a"},
+		{"../b/c", "a", "example.com/mod/b/c"},
+		{"./c", "b", "example.com/mod/b/c"},
+		{"_" + filepath.ToSlash(filepath.Join(root, "b")), "a", "example.com/mod/b"},
+	})
+	undo()
+}
+
+func TestAbsImportPath_unresolvable(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-localimport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tmp, "gopath")
+
+	tests := []struct {
+		label string
+		mod   *goMod
+		imp   string
+		want  string
+	}{
+		{
+			label: "outside GOPATH, no go.mod",
+			imp:   "./b",
+			want:  `can't resolve local import "./b" in a: a/b is not in a GOPATH workspace (GOPATH=` + gopath + `) or in the repository's go.mod module`,
+		},
+		{
+			label: "go.mod without a module path",
+			mod:   &goMod{},
+			imp:   "../b",
+			want:  `can't resolve local import "../b" in a: b is not in a GOPATH workspace (GOPATH=` + gopath + `) or in the repository's go.mod module`,
+		},
+		{
+			label: "outside the module root",
+			mod:   &goMod{Module: "example.com/mod"},
+			imp:   "../../other",
+			want:  `can't resolve local import "../../other" in a: ../other is not in a GOPATH workspace (GOPATH=` + gopath + `) or in the repository's go.mod module`,
+		},
+	}
+	for _, test := range tests {
+		undo := setTestRepo(t, filepath.Join(tmp, "repo"), gopath, test.mod, "a/b", "b")
+		_, err := absImportPath(test.imp, "a")
+		undo()
+		if err == nil {
+			t.Errorf("%s: got no error, want %q", test.label, test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("%s: got error %q, want %q", test.label, err, test.want)
+		}
+	}
+}
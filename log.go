@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// logLevel is the severity of a log message.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+var (
+	// command is the name of the command being run (such as "graph"),
+	// for inclusion in log messages.
+	command string
+
+	// commandStart is when the command started running.
+	commandStart time.Time
+)
+
+// startCommand records the name and start time of the command being
+// run, starts the --timeout timer, and applies and validates the
+// options that all commands share. Commands call it at the start of
+// their Execute method.
+func startCommand(name string) error {
+	switch globalOpt.LogFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid --log-format %q (choices: text, json)", globalOpt.LogFormat)
+	}
+	command, commandStart = name, time.Now()
+	if err := applyRepoOpt(); err != nil {
+		return err
+	}
+	// Report an invalid --repo-map once, instead of as the failure to
+	// resolve each import.
+	_, err := readRepoMap()
+	return err
+}
+
+// logger writes leveled log messages, optionally annotated with the
+// file and the duration of the operation that they describe. Debug
+// messages are only written if --verbose is set.
+type logger struct {
+	file     string
+	duration time.Duration
+}
+
+// withFile returns a logger that annotates messages with file.
+func (l logger) withFile(file string) logger {
+	l.file = file
+	return l
+}
+
+// withDuration returns a logger that annotates messages with the
+// duration of the operation since start.
+func (l logger) withDuration(start time.Time) logger {
+	l.duration = time.Since(start)
+	return l
+}
+
+func (l logger) debugf(format string, a ...interface{}) { l.logf(levelDebug, format, a...) }
+func (l logger) infof(format string, a ...interface{})  { l.logf(levelInfo, format, a...) }
+func (l logger) warnf(format string, a ...interface{})  { l.logf(levelWarn, format, a...) }
+func (l logger) errorf(format string, a ...interface{}) { l.logf(levelError, format, a...) }
+
+func (l logger) logf(level logLevel, format string, a ...interface{}) {
+	if level == levelDebug && !globalOpt.Verbose {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+
+	if globalOpt.LogFormat == "json" {
+		rec := struct {
+			Time     time.Time `json:"time"`
+			Level    string    `json:"level"`
+			Command  string    `json:"command,omitempty"`
+			Msg      string    `json:"msg"`
+			File     string    `json:"file,omitempty"`
+			Duration float64   `json:"duration,omitempty"` // seconds
+			Elapsed  float64   `json:"elapsed,omitempty"`  // seconds since the command started
+		}{
+			Time:     time.Now().UTC(),
+			Level:    levelNames[level],
+			Command:  command,
+			Msg:      msg,
+			File:     l.file,
+			Duration: l.duration.Seconds(),
+		}
+		if !commandStart.IsZero() {
+			rec.Elapsed = time.Since(commandStart).Seconds()
+		}
+		b, err := json.Marshal(rec)
+		if err != nil {
+			panic(err)
+		}
+		log.Print(string(b))
+		return
+	}
+
+	switch level {
+	case levelWarn:
+		msg = "Warning: " + msg
+	case levelError:
+		msg = "Error: " + msg
+	}
+	var annotations []string
+	if l.file != "" {
+		annotations = append(annotations, l.file)
+	}
+	if l.duration != 0 {
+		annotations = append(annotations, l.duration.String())
+	}
+	if len(annotations) > 0 {
+		msg += " (" + strings.Join(annotations, ", ") + ")"
+	}
+	log.Print(msg)
+}
+
+// std is the logger for messages that aren't about a specific file or
+// operation.
+var std logger
+
+func debugf(format string, a ...interface{}) { std.debugf(format, a...) }
+func infof(format string, a ...interface{})  { std.infof(format, a...) }
+func warnf(format string, a ...interface{})  { std.warnf(format, a...) }
+func errorf(format string, a ...interface{}) { std.errorf(format, a...) }
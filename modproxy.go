@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	// errProxyDirect indicates that module information should be
+	// fetched directly from the origin repository (GOPROXY=direct, or
+	// the module is private).
+	errProxyDirect = errors.New("GOPROXY: direct")
+
+	// errProxyOff indicates that fetching module information is
+	// disallowed (GOPROXY=off).
+	errProxyOff = errors.New("GOPROXY: off")
+
+	// errProxyNotFound indicates that no proxy has the requested module
+	// or version.
+	errProxyNotFound = errors.New("GOPROXY: not found")
+)
+
+// proxyInfo is the JSON response of the $GOPROXY/<module>/@v/<version>.info
+// and $GOPROXY/<module>/@latest endpoints.
+type proxyInfo struct {
+	Version string
+
+	// Origin is the repository that the module version came from. Not
+	// all proxies report it.
+	Origin *struct {
+		VCS, URL string
+	}
+}
+
+// goProxy is an entry in the GOPROXY list.
+type goProxy struct {
+	// URL is the proxy base URL, or "direct" or "off".
+	URL string
+
+	// fallThrough is whether to try the next proxy after any error
+	// (entries separated by "|") or only after a "not found" response
+	// (entries separated by ",").
+	fallThrough bool
+}
+
+// goProxies returns the parsed GOPROXY list (defaulting to the same
+// value as the go command).
+func goProxies() []goProxy {
+	env := os.Getenv("GOPROXY")
+	if env == "" {
+		env = "https://proxy.golang.org,direct"
+	}
+	var proxies []goProxy
+	for env != "" {
+		i := strings.IndexAny(env, ",|")
+		var entry string
+		fallThrough := false
+		if i == -1 {
+			entry, env = env, ""
+		} else {
+			entry, fallThrough, env = env[:i], env[i] == '|', env[i+1:]
+		}
+		if entry = strings.TrimSpace(entry); entry != "" {
+			proxies = append(proxies, goProxy{URL: strings.TrimSuffix(entry, "/"), fallThrough: fallThrough})
+		}
+	}
+	return proxies
+}
+
+var (
+	proxyCache   = map[string]proxyResponse{}
+	proxyCacheMu sync.Mutex
+)
+
+type proxyResponse struct {
+	data []byte
+	err  error
+}
+
+// proxyGet fetches $GOPROXY/<escaped modPath>/<file>, trying each proxy
+// in the GOPROXY list in turn. It returns errProxyDirect or errProxyOff
+// if the list reaches a "direct" or "off" entry (or the module is
+// private), and errProxyNotFound if no proxy has the file.
+func proxyGet(modPath, file string) ([]byte, error) {
+	if resolveOpt.NoNetwork {
+		return nil, errProxyOff
+	}
+	if isPrivateImportPath(modPath) {
+		return nil, errProxyDirect
+	}
+
+	escPath, err := escapeModulePath(modPath)
+	if err != nil {
+		return nil, err
+	}
+	key := escPath + "/" + file
+
+	proxyCacheMu.Lock()
+	resp, cached := proxyCache[key]
+	proxyCacheMu.Unlock()
+	if cached {
+		return resp.data, resp.err
+	}
+
+	resp.err = errProxyNotFound
+	for _, p := range goProxies() {
+		if p.URL == "direct" {
+			resp.err = errProxyDirect
+			break
+		}
+		if p.URL == "off" {
+			resp.err = errProxyOff
+			break
+		}
+		resp.data, resp.err = proxyFetch(p.URL + "/" + key)
+		if resp.err == nil {
+			break
+		}
+		if resp.err != errProxyNotFound && !p.fallThrough {
+			warnf("%s.", resp.err)
+			break
+		}
+	}
+
+	proxyCacheMu.Lock()
+	proxyCache[key] = resp
+	proxyCacheMu.Unlock()
+	return resp.data, resp.err
+}
+
+func proxyFetch(url string) ([]byte, error) {
+	start := time.Now()
+	resp, err := goGetClient.Get(url)
+	std.withDuration(start).debugf("Fetched module information from %s", url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound, http.StatusGone:
+		return nil, errProxyNotFound
+	default:
+		return nil, fmt.Errorf("fetching %s: HTTP %s", url, resp.Status)
+	}
+}
+
+// proxyVersions returns the list of known versions of the module
+// modPath from $GOPROXY/<module>/@v/list.
+func proxyVersions(modPath string) ([]string, error) {
+	data, err := proxyGet(modPath, "@v/list")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// proxyLatest returns the latest version of the module modPath from
+// $GOPROXY/<module>/@latest.
+func proxyLatest(modPath string) (*proxyInfo, error) {
+	data, err := proxyGet(modPath, "@latest")
+	if err != nil {
+		return nil, err
+	}
+	return parseProxyInfo(data)
+}
+
+// proxyVersionInfo returns information about a version of the module
+// modPath from $GOPROXY/<module>/@v/<version>.info.
+func proxyVersionInfo(modPath, version string) (*proxyInfo, error) {
+	escVersion, err := escapeModulePath(version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := proxyGet(modPath, "@v/"+escVersion+".info")
+	if err != nil {
+		return nil, err
+	}
+	return parseProxyInfo(data)
+}
+
+func parseProxyInfo(data []byte) (*proxyInfo, error) {
+	var info proxyInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// proxyModuleForImport finds the module that provides importPath by
+// querying the latest version of each of importPath's prefixes, longest
+// first, as the go command does for imports that no required module
+// provides.
+func proxyModuleForImport(importPath string) (modPath string, info *proxyInfo, err error) {
+	for modPath = importPath; modPath != "."; modPath = pathDir(modPath) {
+		info, err = proxyLatest(modPath)
+		if err != errProxyNotFound {
+			return modPath, info, err
+		}
+	}
+	return "", nil, errProxyNotFound
+}
+
+func pathDir(p string) string {
+	if i := strings.LastIndex(p, "/"); i != -1 {
+		return p[:i]
+	}
+	return "."
+}
+
+// escapeModulePath escapes a module path or version for use in a proxy
+// URL by replacing each uppercase letter with "!" followed by its
+// lowercase form.
+func escapeModulePath(s string) (string, error) {
+	var buf []rune
+	for _, r := range s {
+		if r == '!' || r >= unicode.MaxASCII {
+			return "", fmt.Errorf("invalid module path or version %q", s)
+		}
+		if unicode.IsUpper(r) {
+			buf = append(buf, '!', unicode.ToLower(r))
+		} else {
+			buf = append(buf, r)
+		}
+	}
+	return string(buf), nil
+}
+
+// compareSemver compares the semantic versions v and w (such as
+// v1.2.3-pre), returning -1, 0, or +1. Build metadata is ignored.
+func compareSemver(v, w string) int {
+	vmain, vpre := splitSemver(v)
+	wmain, wpre := splitSemver(w)
+	for i := 0; i < 3; i++ {
+		if c := compareInts(vmain[i], wmain[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case vpre == wpre:
+		return 0
+	case vpre == "":
+		return 1
+	case wpre == "":
+		return -1
+	}
+	vparts, wparts := strings.Split(vpre, "."), strings.Split(wpre, ".")
+	for i := 0; i < len(vparts) && i < len(wparts); i++ {
+		if vparts[i] == wparts[i] {
+			continue
+		}
+		vn, verr := strconv.Atoi(vparts[i])
+		wn, werr := strconv.Atoi(wparts[i])
+		switch {
+		case verr == nil && werr == nil:
+			return compareInts(vn, wn)
+		case verr == nil:
+			return -1 // numeric identifiers sort first
+		case werr == nil:
+			return 1
+		case vparts[i] < wparts[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareInts(len(vparts), len(wparts))
+}
+
+func splitSemver(v string) (main [3]int, pre string) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.Index(v, "+"); i != -1 {
+		v = v[:i]
+	}
+	if i := strings.Index(v, "-"); i != -1 {
+		v, pre = v[:i], v[i+1:]
+	}
+	for i, part := range strings.SplitN(v, ".", 3) {
+		main[i], _ = strconv.Atoi(part)
+	}
+	return main, pre
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
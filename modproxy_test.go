@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// setenv sets the environment variable key to value. The returned func
+// restores its previous value.
+func setenv(key, value string) func() {
+	orig, present := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if present {
+			os.Setenv(key, orig)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestGoProxies(t *testing.T) {
+	tests := []struct {
+		env  string
+		want []goProxy
+	}{
+		{env: "", want: []goProxy{{URL: "https://proxy.golang.org"}, {URL: "direct"}}},
+		{env: "off", want: []goProxy{{URL: "off"}}},
+		{env: "https://a.example.com/,direct", want: []goProxy{{URL: "https://a.example.com"}, {URL: "direct"}}},
+		{
+			// Entries separated by "|" fall through after any error.
+			env:  "https://a.example.com|https://b.example.com,https://c.example.com|off",
+			want: []goProxy{{URL: "https://a.example.com", fallThrough: true}, {URL: "https://b.example.com"}, {URL: "https://c.example.com", fallThrough: true}, {URL: "off"}},
+		},
+		{env: " https://a.example.com ,, direct,", want: []goProxy{{URL: "https://a.example.com"}, {URL: "direct"}}},
+	}
+	for _, test := range tests {
+		restore := setenv("GOPROXY", test.env)
+		got := goProxies()
+		restore()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GOPROXY=%q: got %+v, want %+v", test.env, got, test.want)
+		}
+	}
+}
+
+func TestProxyGet(t *testing.T) {
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/example.com/!mod/@v/list" {
+				t.Errorf("got request for %s, want /example.com/!mod/@v/list", r.URL.Path)
+			}
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				w.Write([]byte("v1.0.0\n"))
+			}
+		}
+	}
+	ok := httptest.NewServer(handler(http.StatusOK))
+	defer ok.Close()
+	notFound := httptest.NewServer(handler(http.StatusNotFound))
+	defer notFound.Close()
+	gone := httptest.NewServer(handler(http.StatusGone))
+	defer gone.Close()
+	failing := httptest.NewServer(handler(http.StatusInternalServerError))
+	defer failing.Close()
+
+	defer setenv("GOPRIVATE", "")()
+	defer setenv("GONOPROXY", "")()
+	defer setenv("GONOSUMDB", "")()
+
+	tests := []struct {
+		proxies  string
+		wantData string
+		wantErr  string
+	}{
+		{proxies: ok.URL, wantData: "v1.0.0\n"},
+
+		// After a "not found" response, the next proxy is tried.
+		{proxies: notFound.URL + "," + ok.URL, wantData: "v1.0.0\n"},
+		{proxies: gone.URL + "," + ok.URL, wantData: "v1.0.0\n"},
+		{proxies: notFound.URL + "|" + ok.URL, wantData: "v1.0.0\n"},
+		{proxies: notFound.URL, wantErr: errProxyNotFound.Error()},
+		{proxies: notFound.URL + ",direct", wantErr: errProxyDirect.Error()},
+		{proxies: gone.URL + ",off", wantErr: errProxyOff.Error()},
+
+		// After any other error, the next proxy is only tried if it's
+		// separated by "|".
+		{proxies: failing.URL + "," + ok.URL, wantErr: "fetching " + failing.URL + "/example.com/!mod/@v/list: HTTP 500 Internal Server Error"},
+		{proxies: failing.URL + "|" + ok.URL, wantData: "v1.0.0\n"},
+		{proxies: failing.URL + "|direct", wantErr: errProxyDirect.Error()},
+
+		{proxies: "direct," + ok.URL, wantErr: errProxyDirect.Error()},
+		{proxies: "off", wantErr: errProxyOff.Error()},
+	}
+	for _, test := range tests {
+		proxyCache = map[string]proxyResponse{}
+		restore := setenv("GOPROXY", test.proxies)
+		data, err := proxyGet("example.com/Mod", "@v/list")
+		restore()
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("GOPROXY=%q: got error %v, want %q", test.proxies, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("GOPROXY=%q: %s", test.proxies, err)
+		} else if string(data) != test.wantData {
+			t.Errorf("GOPROXY=%q: got %q, want %q", test.proxies, data, test.wantData)
+		}
+	}
+	proxyCache = map[string]proxyResponse{}
+
+	// Private modules are never fetched from a proxy.
+	defer setenv("GOPROXY", ok.URL)()
+	restore := setenv("GOPRIVATE", "example.com/Mod")
+	_, err := proxyGet("example.com/Mod", "@v/list")
+	restore()
+	if err != errProxyDirect {
+		t.Errorf("got error %v for a private module, want %v", err, errProxyDirect)
+	}
+}
+
+func TestEscapeModulePath(t *testing.T) {
+	tests := []struct {
+		s, want string
+		wantErr bool
+	}{
+		{s: "github.com/user/repo", want: "github.com/user/repo"},
+		{s: "github.com/Azure/azure-sdk-for-go", want: "github.com/!azure/azure-sdk-for-go"},
+		{s: "github.com/BurntSushi/TOML", want: "github.com/!burnt!sushi/!t!o!m!l"},
+		{s: "v1.0.0-RC1", want: "v1.0.0-!r!c1"},
+		{s: "example.com/a!b", wantErr: true},
+		{s: "example.com/é", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := escapeModulePath(test.s)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", test.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.s, err)
+		} else if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	// Each version is lower than the next.
+	ordered := []string{
+		"v0.0.0-20150101120000-abcdef123456",
+		"v0.0.1",
+		"v0.1.0",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.2.0",
+		"v1.10.0",
+		"v2.0.0+incompatible",
+	}
+	for i, v := range ordered {
+		for j, w := range ordered {
+			want := compareInts(i, j)
+			if got := compareSemver(v, w); got != want {
+				t.Errorf("compareSemver(%q, %q): got %d, want %d", v, w, got, want)
+			}
+		}
+	}
+
+	// Build metadata is ignored.
+	for _, vs := range [][2]string{{"v1.0.0+meta", "v1.0.0"}, {"v1.0.0-rc.1+a", "v1.0.0-rc.1+b"}} {
+		if got := compareSemver(vs[0], vs[1]); got != 0 {
+			t.Errorf("compareSemver(%q, %q): got %d, want 0", vs[0], vs[1], got)
+		}
+	}
+}
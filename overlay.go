@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// readOverlay reads an overlay file (see GraphCmd.Overlay): a JSON
+// object mapping file paths (absolute, or relative to the current dir)
+// to their contents. It returns a map of absolute, clean file paths to
+// contents.
+func readOverlay(filename string) (map[string][]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var files map[string]string
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, err
+	}
+	overlay := make(map[string][]byte, len(files))
+	for path, contents := range files {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd, path)
+		}
+		overlay[filepath.Clean(path)] = []byte(contents)
+	}
+	return overlay, nil
+}
+
+// overlayFiles makes the build context (and therefore the grapher) read
+// the files in overlay (a map of absolute, clean file paths to their
+// contents) from overlay instead of from disk. The files need not exist
+// on disk.
+func overlayFiles(overlay map[string][]byte) {
+	if len(overlay) == 0 {
+		return
+	}
+	openFile, readDir := buildContext.OpenFile, buildContext.ReadDir
+	buildContext.OpenFile = func(path string) (io.ReadCloser, error) {
+		if contents, ok := overlay[filepath.Clean(path)]; ok {
+			return ioutil.NopCloser(bytes.NewReader(contents)), nil
+		}
+		if openFile != nil {
+			return openFile(path)
+		}
+		return os.Open(path)
+	}
+	buildContext.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		var fis []os.FileInfo
+		var err error
+		if readDir != nil {
+			fis, err = readDir(dir)
+		} else {
+			fis, err = ioutil.ReadDir(dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Clean(dir)
+		seen := make(map[string]int, len(fis))
+		for i, fi := range fis {
+			seen[fi.Name()] = i
+		}
+		for path, contents := range overlay {
+			if filepath.Dir(path) != dir {
+				continue
+			}
+			fi := overlayFileInfo{filepath.Base(path), int64(len(contents))}
+			if i, ok := seen[fi.name]; ok {
+				fis[i] = fi
+			} else {
+				fis = append(fis, fi)
+			}
+		}
+		return fis, nil
+	}
+	loaderConfig.Build = &buildContext
+}
+
+// useSourceImportsForOverlay makes the grapher type-check imported
+// packages from source (instead of from their installed, compiled
+// forms, which don't reflect the overlay) if overlay has files outside
+// of pkgDir.
+func useSourceImportsForOverlay(overlay map[string][]byte, pkgDir string) {
+	for path := range overlay {
+		if filepath.Dir(path) != filepath.Clean(pkgDir) {
+			loaderConfig.SourceImports = true
+			return
+		}
+	}
+}
+
+// overlayFileInfo describes an overlaid file (see overlayFiles).
+type overlayFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi overlayFileInfo) Name() string       { return fi.name }
+func (fi overlayFileInfo) Size() int64        { return fi.size }
+func (fi overlayFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi overlayFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi overlayFileInfo) IsDir() bool        { return false }
+func (fi overlayFileInfo) Sys() interface{}   { return nil }
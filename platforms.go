@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"go/build"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib-go/gog"
+)
+
+// knownPlatforms are the platforms (GOOS/GOARCH) that `graph
+// --all-platforms` graphs each package on, in addition to the
+// configured platform. A package is only graphed again on a platform if
+// its set of files there differs from those on the platforms that it
+// was already graphed on.
+var knownPlatforms = []string{
+	"linux/amd64", "linux/386", "linux/arm", "linux/arm64",
+	"darwin/amd64", "darwin/arm64",
+	"windows/amd64", "windows/386", "windows/arm64",
+	"freebsd/amd64", "netbsd/amd64", "openbsd/amd64", "dragonfly/amd64",
+	"solaris/amd64", "illumos/amd64", "aix/ppc64",
+	"plan9/386", "plan9/amd64",
+	"android/arm64", "ios/arm64",
+	"js/wasm", "wasip1/wasm",
+}
+
+// doGraphAllPlatforms graphs pkg on the configured platform and on each
+// of the knownPlatforms on which it has a different set of files, and
+// merges the outputs (see gog.MergePlatformOutputs).
+func doGraphAllPlatforms(ctx context.Context, pkg *build.Package, xtest bool) (*gog.Output, error) {
+	origGOOS, origGOARCH := buildContext.GOOS, buildContext.GOARCH
+	defer func() {
+		buildContext.GOOS, buildContext.GOARCH = origGOOS, origGOARCH
+	}()
+
+	// Group the platforms by the package's files on them, and graph the
+	// package once per group.
+	platforms := [][]string{{origGOOS + "/" + origGOARCH}}
+	pkgs := []*build.Package{pkg}
+	groups := map[string]int{pkgFilesKey(pkg): 0}
+	dir := filepath.Join(cwd, pkg.Dir)
+	for _, platform := range knownPlatforms {
+		if platform == platforms[0][0] {
+			continue
+		}
+		ctx := buildContext
+		ctx.GOOS, ctx.GOARCH = splitPlatform(platform)
+		bp, err := ctx.ImportDir(dir, 0)
+		if err != nil {
+			// The package has no files on this platform.
+			continue
+		}
+		key := pkgFilesKey(bp)
+		if i, present := groups[key]; present {
+			platforms[i] = append(platforms[i], platform)
+			continue
+		}
+		groups[key] = len(pkgs)
+		platforms = append(platforms, []string{platform})
+		pkgs = append(pkgs, platformPkg(pkg, bp))
+	}
+	debugf("Graphing package %s on platforms %v.", pkg.ImportPath, platforms)
+
+	var outs []*gog.Output
+	for i, p := range pkgs {
+		buildContext.GOOS, buildContext.GOARCH = splitPlatform(platforms[i][0])
+		o, err := doGraph(ctx, p, xtest)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			warnf("graphing package %s on %s failed: %s (omitting the defs that only exist there)", pkg.ImportPath, strings.Join(platforms[i], ", "), err)
+			continue
+		}
+		outs = append(outs, o)
+		if isTimedOut(ctx) {
+			break
+		}
+	}
+	return gog.MergePlatformOutputs(platforms[:len(outs)], outs), nil
+}
+
+// splitPlatform splits a platform such as "linux/amd64" into its GOOS
+// and GOARCH.
+func splitPlatform(platform string) (goos, goarch string) {
+	i := strings.Index(platform, "/")
+	return platform[:i], platform[i+1:]
+}
+
+// pkgFilesKey returns a string that identifies the set of Go files
+// (including test and cgo files) of pkg.
+func pkgFilesKey(pkg *build.Package) string {
+	var files []string
+	for _, fs := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles, pkg.XTestGoFiles} {
+		files = append(files, fs...)
+	}
+	sort.Strings(files)
+	return strings.Join(files, "\n")
+}
+
+// platformPkg returns a copy of pkg (as scanned on the configured
+// platform) with the files and imports of bp (the same package as
+// imported on another platform).
+func platformPkg(pkg, bp *build.Package) *build.Package {
+	p := *pkg
+	p.GoFiles, p.CgoFiles, p.TestGoFiles, p.XTestGoFiles = bp.GoFiles, bp.CgoFiles, bp.TestGoFiles, bp.XTestGoFiles
+	p.IgnoredGoFiles = bp.IgnoredGoFiles
+	p.Imports, p.TestImports, p.XTestImports = bp.Imports, bp.TestImports, bp.XTestImports
+	return &p
+}
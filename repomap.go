@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+)
+
+// A repoMap maps import path prefixes (such as github.com/alice/foo) to
+// the clone URLs of the repositories that host the packages under them,
+// overriding the repositories that imports would otherwise resolve to.
+// It is read from the --repo-map file, for dependencies that are forks
+// hosted somewhere other than their import paths suggest.
+type repoMap map[string]string
+
+var (
+	repoMapCache     repoMap
+	repoMapCacheErr  error
+	repoMapCacheOnce sync.Once
+)
+
+// readRepoMap returns the --repo-map (a JSON object mapping import path
+// prefixes to clone URLs), or nil if there is none.
+func readRepoMap() (repoMap, error) {
+	repoMapCacheOnce.Do(func() {
+		if resolveOpt.RepoMap == "" {
+			return
+		}
+		file := resolveOpt.RepoMap
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(cwd, file)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			repoMapCacheErr = fmt.Errorf("reading --repo-map failed: %s", err)
+			return
+		}
+		if err := json.Unmarshal(data, &repoMapCache); err != nil {
+			repoMapCacheErr = fmt.Errorf("parsing --repo-map %s failed: %s", file, err)
+			return
+		}
+		for prefix, cloneURL := range repoMapCache {
+			if prefix == "" || cloneURL == "" {
+				repoMapCacheErr = fmt.Errorf("--repo-map %s has an empty import path or clone URL (%q: %q)", file, prefix, cloneURL)
+				return
+			}
+		}
+	})
+	return repoMapCache, repoMapCacheErr
+}
+
+// lookup returns the clone URL of the repository that hosts the package
+// importPath, according to the longest import path prefix of importPath
+// in m, or "" if there is none.
+func (m repoMap) lookup(importPath string) string {
+	var prefix string
+	for p := range m {
+		if pathHasPrefix(importPath, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	return m[prefix]
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"go/scanner"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types"
+)
+
+// graphError is an error (such as a parse or type-checking error) in a
+// package that the grapher analyzed. The grapher continues after such
+// errors, but the defs and refs in the erroneous code may be missing.
+type graphError struct {
+	File         string `json:",omitempty"` // relative to the repository root, if it's underneath it
+	Line, Column int    `json:",omitempty"`
+	Msg          string
+
+	// Kind is "parse" or "typecheck".
+	Kind string
+
+	// Soft is whether the error doesn't prevent the package from
+	// being analyzed (such as an unused variable).
+	Soft bool `json:",omitempty"`
+}
+
+var (
+	graphErrors   []graphError
+	graphErrorsMu sync.Mutex
+)
+
+// recordGraphErrors records the errors in pkgs (for writing with
+// writeSARIF).
+func recordGraphErrors(pkgs []*loader.PackageInfo) {
+	var errs []graphError
+	for _, pkg := range pkgs {
+		for _, err := range pkg.Errors {
+			switch err := err.(type) {
+			case types.Error:
+				pos := err.Fset.Position(err.Pos)
+				errs = append(errs, graphError{File: pos.Filename, Line: pos.Line, Column: pos.Column, Msg: err.Msg, Kind: "typecheck", Soft: err.Soft})
+			case scanner.ErrorList:
+				for _, e := range err {
+					errs = append(errs, graphError{File: e.Pos.Filename, Line: e.Pos.Line, Column: e.Pos.Column, Msg: e.Msg, Kind: "parse"})
+				}
+			case *scanner.Error:
+				errs = append(errs, graphError{File: err.Pos.Filename, Line: err.Pos.Line, Column: err.Pos.Column, Msg: err.Msg, Kind: "parse"})
+			default:
+				errs = append(errs, graphError{Msg: err.Error(), Kind: "typecheck"})
+			}
+		}
+	}
+	for i := range errs {
+		if errs[i].File == "" {
+			continue
+		}
+		// Leave paths outside of the repository absolute.
+		if rel := relPath(cwd, errs[i].File); !strings.HasPrefix(rel, "..") {
+			errs[i].File = rel
+		}
+	}
+
+	graphErrorsMu.Lock()
+	defer graphErrorsMu.Unlock()
+	graphErrors = append(graphErrors, errs...)
+}
+
+// resetGraphErrors forgets the errors recorded by recordGraphErrors (for
+// the previous source unit).
+func resetGraphErrors() {
+	graphErrorsMu.Lock()
+	defer graphErrorsMu.Unlock()
+	graphErrors = nil
+}
+
+// recordedGraphErrors returns the errors recorded by recordGraphErrors.
+func recordedGraphErrors() []graphError {
+	graphErrorsMu.Lock()
+	defer graphErrorsMu.Unlock()
+	return append([]graphError{}, graphErrors...)
+}
+
+// writeSARIF writes errs to filename as a SARIF 2.1.0 log, which CI
+// systems use to annotate source code with the errors.
+func writeSARIF(filename string, errs []graphError) error {
+	data, err := json.MarshalIndent(sarifLog(errs), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// SARIF 2.1.0 log format (only the parts that we use). See
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+type (
+	sarifLogFile struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool       sarifTool     `json:"tool"`
+		ColumnKind string        `json:"columnKind"`
+		Results    []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			InformationURI string      `json:"informationUri"`
+			Rules          []sarifRule `json:"rules"`
+		} `json:"driver"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI       string `json:"uri"`
+				URIBaseID string `json:"uriBaseId,omitempty"`
+			} `json:"artifactLocation"`
+			Region *sarifRegion `json:"region,omitempty"`
+		} `json:"physicalLocation"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+)
+
+func sarifLog(errs []graphError) *sarifLogFile {
+	run := sarifRun{
+		// go/token columns count bytes, which is the same as counting
+		// code points for ASCII source code.
+		ColumnKind: "unicodeCodePoints",
+		Results:    []sarifResult{},
+	}
+	run.Tool.Driver.Name = "srclib-go"
+	run.Tool.Driver.InformationURI = "https://sourcegraph.com/sourcegraph/srclib-go"
+	run.Tool.Driver.Rules = []sarifRule{
+		{ID: "parse", ShortDescription: sarifMessage{"Go syntax error"}},
+		{ID: "typecheck", ShortDescription: sarifMessage{"Go type-checking error"}},
+	}
+
+	for _, e := range errs {
+		res := sarifResult{RuleID: e.Kind, Level: "error", Message: sarifMessage{e.Msg}}
+		if e.Soft {
+			res.Level = "warning"
+		}
+		if e.File != "" {
+			var loc sarifLocation
+			if filepath.IsAbs(e.File) {
+				loc.PhysicalLocation.ArtifactLocation.URI = "file://" + filepath.ToSlash(e.File)
+			} else {
+				loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(e.File)
+				loc.PhysicalLocation.ArtifactLocation.URIBaseID = "%SRCROOT%"
+			}
+			if e.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: e.Line, StartColumn: e.Column}
+			}
+			res.Locations = []sarifLocation{loc}
+		}
+		run.Results = append(run.Results, res)
+	}
+
+	return &sarifLogFile{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
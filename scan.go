@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"go/ast"
 	"go/build"
+	goparser "go/parser"
+	"go/token"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"reflect"
-
 	"sort"
+	"strconv"
 	"strings"
 
 	"sourcegraph.com/sourcegraph/srclib/toolchain"
@@ -31,13 +37,40 @@ func init() {
 type ScanCmd struct {
 	Repo   string `long:"repo" description:"repository URI" value-name:"URI"`
 	Subdir string `long:"subdir" description:"subdirectory in repository" value-name:"DIR"`
+
+	IncludeVendor bool `long:"include-vendor" description:"emit source units for packages underneath vendor/ dirs"`
+
+	ReportIgnored bool `long:"report-ignored" description:"list the files that build constraints exclude from each source unit (and why) in the unit's data and the log"`
+
+	Pkgs []string `long:"pkg" description:"only emit the source unit of the package with this import path (repeatable)" value-name:"IMPORT-PATH"`
+
+	// FailOnParseError makes scanning fail if any file has a syntax
+	// error. By default, syntax errors are recorded in the Diagnostics
+	// of each source unit's data.
+	FailOnParseError bool `long:"fail-on-parse-error" description:"fail if any Go file has a syntax error, instead of recording the errors in the source units' diagnostics and continuing"`
+
+	// ListOnly only lists the import paths of the packages, which is
+	// much faster than scanning them (see listPkgs).
+	ListOnly bool `long:"list-only" description:"only list the import paths of the packages (found by walking the tree for dirs with .go files, without running go list or reading the files), one per line"`
+	JSON     bool `long:"json" description:"with --list-only, write the import paths as a JSON array"`
+
+	// FollowSymlinks is "true" (or empty) or "false". It's not a bool
+	// option so that it can be set to false.
+	FollowSymlinks string `long:"follow-symlinks" description:"emit the packages in symlinked dirs once each (in their real dirs if those are in the repository) and record the import paths through the symlinks as their aliases; if false, symlinked dirs are skipped" default:"true" value-name:"BOOL"`
 }
 
 var scanCmd ScanCmd
 
 func (c *ScanCmd) Execute(args []string) error {
+	if err := startCommand("scan"); err != nil {
+		return err
+	}
+	if c.Repo == "" {
+		c.Repo = globalOpt.RepoURI
+	}
+
 	if c.Repo == "" && os.Getenv("IN_DOCKER_CONTAINER") != "" {
-		log.Println("Warning: no --repo specified, and tool is running in a Docker container (i.e., without awareness of host's GOPATH). Go import paths in source units produced by the scanner may be inaccurate. To fix this, ensure that the --repo URI is specified. Report this issue if you are seeing it unexpectedly.")
+		warnf("no --repo specified, and tool is running in a Docker container (i.e., without awareness of host's GOPATH). Go import paths in source units produced by the scanner may be inaccurate. To fix this, ensure that the --repo URI is specified. Report this issue if you are seeing it unexpectedly.")
 	}
 
 	if err := json.NewDecoder(os.Stdin).Decode(&config); err != nil {
@@ -47,6 +80,41 @@ func (c *ScanCmd) Execute(args []string) error {
 		return err
 	}
 
+	if c.JSON && !c.ListOnly {
+		return fmt.Errorf("--json requires --list-only")
+	}
+	if c.ListOnly {
+		return c.listPkgs()
+	}
+
+	units, err := c.scanUnits()
+	if err != nil {
+		return err
+	}
+	if len(c.Pkgs) > 0 {
+		if units, err = selectPkgs(units, c.Pkgs); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(units, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stdout.Write(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// scanUnits scans the current directory tree for Go packages, using
+// the Srcfile config (which must be set), and returns their source
+// units.
+func (c *ScanCmd) scanUnits() ([]*unit.SourceUnit, error) {
+	if c.IncludeVendor {
+		config.IncludeVendor = true
+	}
+
 	// Automatically detect vendored dirs (check for vendor/src and
 	// Godeps/_workspace/src) and set up GOPATH pointing to them if
 	// they exist.
@@ -58,14 +126,22 @@ func (c *ScanCmd) Execute(args []string) error {
 			if fi, err := os.Stat(filepath.Join(cwd, vdir, "src")); err == nil && fi.Mode().IsDir() {
 				foundGOPATHs = append(foundGOPATHs, vdir)
 				setAutoGOPATH = true
-				log.Printf("Adding %s to GOPATH (auto-detected Go vendored dependencies source dir %s). If you don't want this, make a Srcfile with a GOPATH property set to something other than the empty string.", vdir, filepath.Join(vdir, "src"))
+				infof("Adding %s to GOPATH (auto-detected Go vendored dependencies source dir %s). If you don't want this, make a Srcfile with a GOPATH property set to something other than the empty string.", vdir, filepath.Join(vdir, "src"))
 			}
 		}
-		config.GOPATH = strings.Join(foundGOPATHs, ":")
+		config.GOPATH = joinPathList(foundGOPATHs)
+	}
+
+	followSymlinks := true
+	if c.FollowSymlinks != "" {
+		var err error
+		if followSymlinks, err = strconv.ParseBool(c.FollowSymlinks); err != nil {
+			return nil, fmt.Errorf("invalid --follow-symlinks %q (choices: true, false)", c.FollowSymlinks)
+		}
 	}
 
 	if err := config.apply(); err != nil {
-		return err
+		return nil, err
 	}
 
 	var pkgPatterns []string
@@ -74,9 +150,32 @@ func (c *ScanCmd) Execute(args []string) error {
 	} else {
 		pkgPatterns = []string{"./..."}
 	}
-	units, err := scan(pkgPatterns)
+	ctx, cancel := timeoutContext("scanning")
+	defer cancel()
+	units, err := scan(ctx, pkgPatterns)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// The go tool skips symlinked dirs, so add the packages that are
+	// only reachable through symlinks to dirs outside of the tree
+	// (unless the packages to scan were configured).
+	var symlinks *symlinkedDirs
+	if followSymlinks {
+		if symlinks, err = findSymlinkedDirs(); err != nil {
+			return nil, err
+		}
+		if config.PkgPatterns == nil && len(symlinks.extraDirs) > 0 {
+			var patterns []string
+			for _, dir := range symlinks.extraDirs {
+				patterns = append(patterns, "./"+dir)
+			}
+			extra, err := scan(ctx, patterns)
+			if err != nil {
+				return nil, err
+			}
+			units = append(units, extra...)
+		}
 	}
 
 	// Fix up import paths to be consistent when running as a program and as
@@ -94,11 +193,11 @@ func (c *ScanCmd) Execute(args []string) error {
 	// Make vendored dep unit names (package import paths) relative to
 	// vendored src dir, not to top-level dir.
 	if config.GOPATH != "" {
-		dirs := strings.Split(config.GOPATH, ":")
+		dirs := filepath.SplitList(config.GOPATH)
 		for _, dir := range dirs {
 			relDir, err := filepath.Rel(cwd, dir)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			srcDir := filepath.Join(relDir, "src")
 			for _, u := range units {
@@ -106,7 +205,7 @@ func (c *ScanCmd) Execute(args []string) error {
 				if strings.HasPrefix(pkg.Dir, srcDir) {
 					relImport, err := filepath.Rel(srcDir, pkg.Dir)
 					if err != nil {
-						return err
+						return nil, err
 					}
 					pkg.ImportPath = relImport
 					u.Name = pkg.ImportPath
@@ -115,6 +214,65 @@ func (c *ScanCmd) Execute(args []string) error {
 		}
 	}
 
+	// Omit packages underneath vendor/ dirs. They are still resolved
+	// (to their unvendored import paths) when they are imported.
+	if !config.IncludeVendor {
+		var nonVendored []*unit.SourceUnit
+		for _, u := range units {
+			if !isVendored(u.Name) {
+				nonVendored = append(nonVendored, u)
+			}
+		}
+		units = nonVendored
+	}
+
+	// Omit dirs and files that are matched by a .srclibignore file.
+	// They're also removed from the package's file lists, which the
+	// grapher uses (after checking .srclibignore again, since the
+	// loader reads all of the files in the package's dir).
+	ig := newSrclibIgnore(cwd)
+	var notIgnored []*unit.SourceUnit
+	for _, u := range units {
+		dir := filepath.ToSlash(u.Dir)
+		if ig.Ignored(dir, true) {
+			continue
+		}
+		var files []string
+		for _, f := range u.Files {
+			if !ig.Ignored(path.Join(dir, f), false) {
+				files = append(files, f)
+			}
+		}
+		u.Files = files
+		ig.removeIgnoredFiles(u.Data.(*build.Package))
+		notIgnored = append(notIgnored, u)
+	}
+	units = notIgnored
+
+	// Record the import paths of packages through symlinks (in each
+	// package's data, below), and pass them to the units so that
+	// imports through symlinks resolve to the packages' source units.
+	var aliases map[string][]string
+	if symlinks != nil {
+		aliases = symlinks.unitAliases(units)
+	}
+	if len(aliases) > 0 {
+		unitOf := map[string]string{}
+		for name, as := range aliases {
+			for _, a := range as {
+				unitOf[a] = name
+			}
+		}
+		for _, u := range units {
+			if u.Config == nil {
+				u.Config = map[string]interface{}{}
+			}
+			u.Config["SymlinkAliases"] = unitOf
+		}
+	}
+
+	units = splitXTestUnits(units)
+
 	// make files relative to repository root
 	for _, u := range units {
 		pkgSubdir := filepath.Join(c.Subdir, u.Data.(*build.Package).Dir)
@@ -133,33 +291,254 @@ func (c *ScanCmd) Execute(args []string) error {
 				u.Config = map[string]interface{}{}
 			}
 
-			dirs := strings.Split(config.GOPATH, ":")
+			dirs := filepath.SplitList(config.GOPATH)
 			for i, dir := range dirs {
 				relDir, err := filepath.Rel(cwd, dir)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				dirs[i] = relDir
 			}
-			u.Config["GOPATH"] = strings.Join(dirs, ":")
+			u.Config["GOPATH"] = joinPathList(dirs)
 		}
 	}
 
-	b, err := json.MarshalIndent(units, "", "  ")
-	if err != nil {
-		return err
+	// Likewise, pass build options specified on the command line to
+	// the units so that the grapher considers the same files.
+	if buildCfg := buildOpt.unitConfig(); len(buildCfg) > 0 {
+		for _, u := range units {
+			if u.Config == nil {
+				u.Config = map[string]interface{}{}
+			}
+			for k, v := range buildCfg {
+				u.Config[k] = v
+			}
+		}
 	}
-	if _, err := os.Stdout.Write(b); err != nil {
-		return err
+
+	// Pass IncludeVendor to the units for the same reason, so that
+	// depresolve and graph agree with the scanner about which
+	// vendored packages are part of this repository.
+	if c.IncludeVendor {
+		for _, u := range units {
+			if u.Config == nil {
+				u.Config = map[string]interface{}{}
+			}
+			u.Config["IncludeVendor"] = true
+		}
+	}
+
+	for _, u := range units {
+		overrideRepo(u)
+	}
+
+	// Record which packages are commands, and where their entrypoints
+	// are.
+	goVersion := goLanguageVersion()
+	var numDiags int
+	for _, u := range units {
+		pkg := u.Data.(*build.Package)
+		xtest := u.Name == pkg.ImportPath+"_test"
+		entrypoints := findEntrypoints(pkg, xtest)
+		data := &goPackageData{Package: pkg, XTest: xtest, Entrypoints: entrypoints, Inputs: newUnitInputs(u, pkg, c.Subdir), Aliases: aliases[u.Name], GoVersion: goVersion}
+		data.Diagnostics = parseDiagnostics(u, pkg, c.Subdir)
+		for _, d := range data.Diagnostics {
+			std.withFile(d.File).warnf("Syntax error in package %s at line %d, column %d: %s.", pkg.ImportPath, d.Line, d.Column, d.Message)
+		}
+		numDiags += len(data.Diagnostics)
+		if c.ReportIgnored && !xtest {
+			data.Ignored = ignoredFiles(pkg, c.Subdir)
+			for _, f := range data.Ignored {
+				msg := f.Reason
+				if f.Constraint != "" {
+					msg += fmt.Sprintf(" (%q)", f.Constraint)
+				}
+				std.withFile(f.File).infof("Excluded from package %s by build constraints: %s.", pkg.ImportPath, msg)
+			}
+		}
+		for _, e := range entrypoints {
+			e.File = filepath.Join(c.Subdir, pkg.Dir, e.File)
+			if e.Name == "main" {
+				data.IsMain = true
+			}
+		}
+		u.Data = data
+	}
+	if c.FailOnParseError && numDiags > 0 {
+		return nil, fmt.Errorf("%d syntax errors in Go files (and --fail-on-parse-error is set)", numDiags)
+	}
+
+	return units, nil
+}
+
+// selectPkgs returns the source units of the packages with the given
+// import paths (in the order of pkgs). It returns an error if any of
+// them isn't among the units.
+func selectPkgs(units []*unit.SourceUnit, pkgs []string) ([]*unit.SourceUnit, error) {
+	byName := make(map[string]*unit.SourceUnit, len(units))
+	for _, u := range units {
+		byName[u.Name] = u
+	}
+	var selected []*unit.SourceUnit
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		u, present := byName[pkg]
+		if !present {
+			return nil, fmt.Errorf("no package %q among the %d packages scanned in the repository (run scan to list them)", pkg, len(units))
+		}
+		if !seen[pkg] {
+			seen[pkg] = true
+			selected = append(selected, u)
+		}
+	}
+	return selected, nil
+}
+
+// goPackageData is the Data of a GoPackage source unit. It is the
+// build.Package (from `go list`) plus information that the scanner
+// derives from the package's source files. It may be unmarshaled as a
+// build.Package.
+type goPackageData struct {
+	*build.Package
+
+	// XTest is whether the source unit is the external test package
+	// (package foo_test, in the package's XTestGoFiles) of the package,
+	// which is its own source unit (see splitXTestUnits).
+	XTest bool `json:",omitempty"`
+
+	// IsMain is whether this package is a command (i.e., it is package
+	// main and defines func main).
+	IsMain bool `json:",omitempty"`
+
+	// Entrypoints lists the main and TestMain funcs in this package.
+	Entrypoints []*entrypoint `json:",omitempty"`
+
+	// Inputs lists the files and imports of this package, for tools
+	// that need to know exactly what goes into analyzing it.
+	Inputs *unitInputs `json:",omitempty"`
+
+	// Ignored lists the files that build constraints exclude from this
+	// package, if the scanner was run with --report-ignored.
+	Ignored []*ignoredFile `json:",omitempty"`
+
+	// Aliases lists the import paths of this package through symlinks
+	// to its dir (or to one of its parent dirs).
+	Aliases []string `json:",omitempty"`
+
+	// GoVersion is the Go language version (such as "1.21") that the
+	// package is written for (see goLanguageVersion).
+	GoVersion string `json:",omitempty"`
+
+	// Diagnostics lists the syntax errors in the package's files.
+	Diagnostics []*scanDiagnostic `json:",omitempty"`
+}
+
+// unitInputs lists the files (sorted and relative to the repository
+// root) and imports (sorted) of a source unit, by kind. Files that are
+// excluded by build constraints or .srclibignore are omitted.
+type unitInputs struct {
+	GoFiles     []string // non-test .go files, including cgo files
+	TestGoFiles []string // _test.go files, including xtest files
+	CFiles      []string // .c and .h files
+	Imports     []string // imports of all of the package's .go files
+}
+
+// newUnitInputs returns the inputs of the source unit u, whose Data is
+// pkg. The unit's files must already be relative to the repository root.
+func newUnitInputs(u *unit.SourceUnit, pkg *build.Package, subdir string) *unitInputs {
+	included := make(map[string]bool, len(u.Files))
+	for _, f := range u.Files {
+		included[f] = true
+	}
+	files := func(lists ...[]string) []string {
+		files := []string{}
+		for _, list := range lists {
+			for _, f := range list {
+				if f := filepath.Join(subdir, pkg.Dir, f); included[f] {
+					files = append(files, f)
+				}
+			}
+		}
+		sort.Strings(files)
+		return files
+	}
+
+	imports := []string{}
+	for _, dep := range u.Dependencies {
+		imports = append(imports, dep.(string))
+	}
+	sort.Strings(imports)
+
+	return &unitInputs{
+		GoFiles:     files(pkg.GoFiles, pkg.CgoFiles),
+		TestGoFiles: files(pkg.TestGoFiles, pkg.XTestGoFiles),
+		CFiles:      files(pkg.CFiles, pkg.HFiles),
+		Imports:     imports,
 	}
-	return nil
 }
 
-func scan(pkgPatterns []string) ([]*unit.SourceUnit, error) {
-	// TODO(sqs): include xtest, but we'll have to make them have a distinctly
-	// namespaced def path from the non-xtest pkg.
+// entrypoint is the location of a main or TestMain func.
+type entrypoint struct {
+	Name  string // "main" or "TestMain"
+	File  string // file path, relative to the repository root
+	Start int    // byte offset of the func declaration
+	End   int
+}
 
-	cmd := exec.Command("go", "list", "-e", "-json")
+// findEntrypoints parses pkg's files to find func main (in non-test
+// files of package main) and func TestMain (in test files), or only
+// func TestMain in its external test files if xtest is true. Files that
+// fail to parse are skipped. The returned entrypoints' File fields are
+// relative to pkg.Dir.
+func findEntrypoints(pkg *build.Package, xtest bool) []*entrypoint {
+	var files []string
+	if pkg.Name == "main" && !xtest {
+		files = append(files, pkg.GoFiles...)
+		files = append(files, pkg.CgoFiles...)
+	}
+	testFiles := pkg.TestGoFiles
+	if xtest {
+		testFiles = pkg.XTestGoFiles
+	}
+
+	var entrypoints []*entrypoint
+	fset := token.NewFileSet()
+	find := func(filename, funcName string) {
+		f, err := goparser.ParseFile(fset, filepath.Join(cwd, pkg.Dir, filename), nil, 0)
+		if err != nil {
+			return
+		}
+		for _, decl := range f.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv != nil || fd.Name.Name != funcName {
+				continue
+			}
+			entrypoints = append(entrypoints, &entrypoint{
+				Name:  funcName,
+				File:  filename,
+				Start: fset.Position(fd.Pos()).Offset,
+				End:   fset.Position(fd.End()).Offset,
+			})
+		}
+	}
+	for _, f := range files {
+		find(f, "main")
+	}
+	for _, f := range testFiles {
+		find(f, "TestMain")
+	}
+	return entrypoints
+}
+
+// scan returns the source units of the packages that match
+// pkgPatterns. Each unit includes the package's external test files
+// (see splitXTestUnits). It kills go list and fails if ctx is done
+// first.
+func scan(ctx context.Context, pkgPatterns []string) ([]*unit.SourceUnit, error) {
+	cmd := exec.Command(goTool(), "list", "-e", "-json")
+	if len(buildContext.BuildTags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(buildContext.BuildTags, " "))
+	}
 	cmd.Args = append(cmd.Args, pkgPatterns...)
 	cmd.Env = config.env()
 	cmd.Stderr = os.Stderr
@@ -170,6 +549,7 @@ func scan(pkgPatterns []string) ([]*unit.SourceUnit, error) {
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
+	defer killOnTimeout(ctx, cmd)()
 
 	dec := json.NewDecoder(stdout)
 	var units []*unit.SourceUnit
@@ -178,6 +558,9 @@ func scan(pkgPatterns []string) ([]*unit.SourceUnit, error) {
 		if err := dec.Decode(&pkg); err == io.EOF {
 			break
 		} else if err != nil {
+			if isTimedOut(ctx) {
+				return nil, fmt.Errorf("%v: %s", cmd.Args, errTimedOut)
+			}
 			return nil, err
 		}
 
@@ -192,30 +575,19 @@ func scan(pkgPatterns []string) ([]*unit.SourceUnit, error) {
 				files = append(files, fv.([]string)...)
 			}
 		}
+		// InvalidGoFiles (with syntax errors) are also in GoFiles or
+		// another list.
+		files = uniq(files)
 
 		// collect all imports
-		depsMap := map[string]struct{}{}
+		var imports [][]string
 		for i := 0; i < pt.NumField(); i++ {
 			f := pt.Field(i)
 			if strings.HasSuffix(f.Name, "Imports") {
-				fv := pv.Field(i).Interface()
-				imports := fv.([]string)
-				for _, imp := range imports {
-					depsMap[imp] = struct{}{}
-				}
+				imports = append(imports, pv.Field(i).Interface().([]string))
 			}
 		}
-		deps0 := make([]string, len(depsMap))
-		i := 0
-		for imp := range depsMap {
-			deps0[i] = imp
-			i++
-		}
-		sort.Strings(deps0)
-		deps := make([]interface{}, len(deps0))
-		for i, imp := range deps0 {
-			deps[i] = imp
-		}
+		deps := unitDependencies(pkg.Dir, imports...)
 
 		// make all dirs relative to the current dir
 		for i := 0; i < pt.NumField(); i++ {
@@ -249,6 +621,9 @@ func scan(pkgPatterns []string) ([]*unit.SourceUnit, error) {
 		})
 	}
 	if err := cmd.Wait(); err != nil {
+		if isTimedOut(ctx) {
+			return nil, fmt.Errorf("%v: %s", cmd.Args, errTimedOut)
+		}
 		return nil, err
 	}
 
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// listPkgs implements `scan --list-only`: it walks the directory tree
+// for dirs that contain .go files and writes their import paths to
+// stdout, one per line (or as a JSON array, with --json), without
+// running go list or reading the files. Like the go tool, it skips
+// dirs whose names begin with "." or "_" and testdata dirs. It also
+// skips vendor dirs (unless vendored packages are included) and the
+// dirs and files that .srclibignore files match.
+func (c *ScanCmd) listPkgs() error {
+	if len(c.Pkgs) > 0 {
+		return fmt.Errorf("--pkg can't be used with --list-only")
+	}
+	if c.IncludeVendor {
+		config.IncludeVendor = true
+	}
+	if err := config.apply(); err != nil {
+		return err
+	}
+	mod, err := goModule()
+	if err != nil {
+		return err
+	}
+
+	ig := newSrclibIgnore(cwd)
+	pkgDirs := map[string]struct{}{}
+	err = filepath.Walk(cwd, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			warnf("%s (skipping it).", err)
+			if fi != nil && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(cwd, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			if name == "testdata" || (name == "vendor" && !config.IncludeVendor) || ig.Ignored(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() && strings.HasSuffix(name, ".go") && !ig.Ignored(rel, false) {
+			pkgDirs[path.Dir(rel)] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	importPaths := []string{}
+	for dir := range pkgDirs {
+		importPaths = append(importPaths, c.listImportPath(mod, dir))
+	}
+	sort.Strings(importPaths)
+
+	if c.JSON {
+		b, err := json.MarshalIndent(importPaths, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", b)
+		return err
+	}
+	for _, p := range importPaths {
+		if _, err := fmt.Println(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listImportPath returns the import path of the package in dir (which
+// is slash-separated and relative to the repository root), as scan
+// would name it: under the go.mod module path, if there is one, or
+// else from the dir's location in the GOPATH, or else (for dirs
+// outside of the GOPATH) as go list names them.
+func (c *ScanCmd) listImportPath(mod *goMod, dir string) string {
+	if os.Getenv("IN_DOCKER_CONTAINER") != "" && config.GOROOT == "" {
+		return path.Join(c.Repo, filepath.ToSlash(c.Subdir), dir)
+	}
+	if mod != nil && mod.Module != "" {
+		return path.Join(mod.Module, dir)
+	}
+	abs := filepath.Join(cwd, filepath.FromSlash(dir))
+	if pkg, err := buildContext.ImportDir(abs, build.FindOnly); err == nil && pkg.ImportPath != "." {
+		return pkg.ImportPath
+	}
+	return "_" + filepath.ToSlash(abs)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/sourcegraph/go-nnz/nnz"
+
+	defpkg "sourcegraph.com/sourcegraph/srclib-go/golang_def"
+)
+
+// printSchema implements `graph --print-schema`: it writes a JSON
+// Schema (draft-07) describing the `graph` output. The schema is
+// generated from the output types, so it describes exactly what this
+// version writes. The root schema describes the `--format json` output,
+// and the "GraphLine" definition describes each line of the `--format
+// jsonl` output.
+func printSchema() error {
+	s := newSchemaGenerator()
+	root := s.schema(reflect.TypeOf(graphOutput{}))
+	s.schema(reflect.TypeOf(graphLine{}))
+
+	out := map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "srclib-go graph output",
+		"definitions": s.defs,
+	}
+	for k, v := range root {
+		out[k] = v
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", data)
+	return err
+}
+
+// schemaGenerator generates JSON Schemas for Go types, following the
+// encoding/json rules for struct fields. Each struct type is described
+// once, in defs, and referred to by name.
+type schemaGenerator struct {
+	defs  map[string]map[string]interface{}
+	names map[reflect.Type]string
+}
+
+func newSchemaGenerator() *schemaGenerator {
+	return &schemaGenerator{
+		defs:  map[string]map[string]interface{}{},
+		names: map[reflect.Type]string{},
+	}
+}
+
+var (
+	nnzBoolType  = reflect.TypeOf(nnz.Bool(false))
+	jsonTextType = reflect.TypeOf(types.JsonText{})
+)
+
+// schema returns the JSON Schema for values of type t.
+func (s *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case nnzBoolType:
+		// A false nnz.Bool is encoded as null.
+		return map[string]interface{}{"type": []string{"boolean", "null"}}
+	case jsonTextType:
+		// The only raw JSON in the output is the Go-specific data of
+		// defs (see convertGoDef).
+		return s.schema(reflect.TypeOf(defpkg.DefData{}))
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Ptr:
+		return nullable(s.schema(t.Elem()))
+	case reflect.Slice:
+		return nullable(map[string]interface{}{"type": "array", "items": s.schema(t.Elem())})
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return nullable(map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())})
+	case reflect.Struct:
+		return map[string]interface{}{"$ref": "#/definitions/" + s.structDef(t)}
+	}
+	// Interfaces (and other kinds, which the output doesn't contain) can
+	// be any JSON value.
+	return map[string]interface{}{}
+}
+
+// nullable returns a schema that allows null in addition to the values
+// allowed by schema (for nil pointers, slices, and maps).
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}}}
+}
+
+// structDef adds the definition of the struct type t to s.defs (if it's
+// not already there) and returns its name.
+func (s *schemaGenerator) structDef(t reflect.Type) string {
+	if name, present := s.names[t]; present {
+		return name
+	}
+	name := schemaName(t)
+	if _, present := s.defs[name]; present {
+		name = strings.Replace(t.String(), ".", "_", -1)
+	}
+	s.names[t] = name
+
+	def := map[string]interface{}{"type": "object"}
+	s.defs[name] = def // before describing fields, for recursive types
+	props := map[string]interface{}{}
+	var required []string
+	s.addFields(t, props, &required, map[string]bool{})
+	def["properties"] = props
+	if len(required) > 0 {
+		def["required"] = required
+	}
+	return name
+}
+
+// addFields adds the JSON properties of the struct type t's fields to
+// props (and the names of those that are always present to required).
+// As in encoding/json, the fields of embedded structs are promoted, and
+// a field hides the promoted fields with the same name. Names in seen
+// were already added by an enclosing struct.
+func (s *schemaGenerator) addFields(t reflect.Type, props map[string]interface{}, required *[]string, seen map[string]bool) {
+	type field struct {
+		name      string
+		t         reflect.Type
+		omitEmpty bool
+	}
+	var fields []field
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i != -1 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, field{name: name, t: f.Type, omitEmpty: strings.Contains(","+opts+",", ",omitempty,")})
+	}
+
+	inner := map[string]bool{}
+	for name := range seen {
+		inner[name] = true
+	}
+	for _, f := range fields {
+		inner[f.name] = true
+	}
+	for _, f := range fields {
+		if seen[f.name] {
+			continue
+		}
+		props[f.name] = s.schema(f.t)
+		if !f.omitEmpty {
+			*required = append(*required, f.name)
+		}
+	}
+	for _, et := range embedded {
+		s.addFields(et, props, required, inner)
+	}
+}
+
+// schemaName returns the name of the definition of the struct type t,
+// which is its Go name with the first letter upper-cased.
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return "Struct"
+	}
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[n:]
+}
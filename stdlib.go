@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// defaultStdlibRepo is the repository that standard library imports
+// are resolved to, unless --stdlib-repo is set.
+const defaultStdlibRepo = "https://github.com/golang/go"
+
+var (
+	stdlibVersionOnce sync.Once
+	stdlibVersion     string
+)
+
+// goVersion returns the version of Go (such as "go1.4.2") whose
+// standard library is being analyzed: the --go-version, if set, or else
+// the version of the Go toolchain in the GOROOT.
+func goVersion() string {
+	if resolveOpt.GoVersion != "" {
+		return resolveOpt.GoVersion
+	}
+	stdlibVersionOnce.Do(func() {
+		stdlibVersion = detectGoVersion()
+	})
+	return stdlibVersion
+}
+
+// goLanguageVersion returns the version of the Go language (such as
+// "1.21") that the repository's code is written for: the language
+// version of --go-version, if set, or else the go directive in the
+// repository's go.mod, or else the language version of the Go
+// toolchain. It returns "" if none of those is a release (such as a
+// "devel +abcdef" toolchain).
+func goLanguageVersion() string {
+	if resolveOpt.GoVersion != "" {
+		return languageVersion(resolveOpt.GoVersion)
+	}
+	if mod, err := goModule(); err != nil {
+		warnf("reading go.mod failed: %s (assuming the Go toolchain's language version).", err)
+	} else if mod != nil && mod.Go != "" {
+		return mod.Go
+	}
+	return languageVersion(goVersion())
+}
+
+// languageVersion returns the language version (such as "1.4") of the
+// Go release v (such as "go1.4.2" or "go1.21rc1"), or "" if v isn't a
+// release.
+func languageVersion(v string) string {
+	m := goReleaseVersion.FindStringSubmatch(v)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+var goReleaseVersion = regexp.MustCompile(`^go(1(?:\.[0-9]+)?)(?:\.[0-9]+|(?:beta|rc)[0-9]+)?$`)
+
+var goVersionOutput = regexp.MustCompile(`^go version (\S+)`)
+
+// detectGoVersion returns the version of the Go toolchain in the
+// GOROOT, from the GOROOT's VERSION file (which source checkouts don't
+// have) or `go version`. If neither works, it returns the version of Go
+// that srclib-go was built with.
+func detectGoVersion() string {
+	if data, err := ioutil.ReadFile(filepath.Join(buildContext.GOROOT, "VERSION")); err == nil {
+		// The first line is the version; later lines (in newer
+		// releases) are metadata.
+		if v := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0]); v != "" {
+			return v
+		}
+	}
+
+	cmd := exec.Command(goTool(), "version")
+	if config != nil {
+		cmd.Env = config.env()
+	}
+	if out, err := cmd.Output(); err == nil {
+		if m := goVersionOutput.FindSubmatch(out); m != nil {
+			return string(m[1])
+		}
+	}
+
+	warnf("could not determine the version of the Go toolchain in %s; assuming %s.", buildContext.GOROOT, runtime.Version())
+	return runtime.Version()
+}
+
+// goVersionRevSpec returns the revision of the Go repository that
+// corresponds to the Go version v (its release tag), or "" if v isn't a
+// release (such as "devel +abcdef").
+func goVersionRevSpec(v string) string {
+	if !strings.HasPrefix(v, "go") || strings.ContainsAny(v, " +") {
+		return ""
+	}
+	return v
+}
+
+// stdlibRepo returns the clone URL of the repository that standard
+// library imports are resolved to.
+func stdlibRepo() string {
+	if resolveOpt.StdlibRepo != "" {
+		return resolveOpt.StdlibRepo
+	}
+	return defaultStdlibRepo
+}
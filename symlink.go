@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// symlinkedDirs describes the symlinks to directories in the directory
+// tree rooted at the current directory. The go tool doesn't follow
+// symlinks when matching "./...", so the packages in symlinked
+// directories are otherwise either omitted or (when the go tool
+// follows them on some systems) emitted once per symlink.
+//
+// Each package is emitted once, in its real directory if that's in the
+// repository, or else in the directory of the first symlink (in lexical
+// order) to it. Its other directories are its aliases.
+type symlinkedDirs struct {
+	// aliases maps the directory (relative to the current directory
+	// and slash-separated) of each symlink to the directory whose
+	// packages are emitted instead of those in the symlink.
+	aliases map[string]string
+
+	// extraDirs are the directories (relative to the current directory)
+	// containing .go files that are only reachable from the current
+	// directory through a symlink to a directory outside of it.
+	extraDirs []string
+}
+
+// findSymlinkedDirs walks the directory tree rooted at the current
+// directory (following symlinks to directories) to find the symlinks to
+// directories and the directories outside of the tree that they make
+// reachable. Like the go tool, it skips directories whose names begin
+// with "." or "_" and testdata directories. Symlinks to their own
+// ancestor directories (cycles) are ignored, and each real directory
+// is walked only once.
+func findSymlinkedDirs() (*symlinkedDirs, error) {
+	realCWD, err := filepath.EvalSymlinks(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &symlinkedDirs{aliases: map[string]string{}}
+	walked := map[string]string{} // real dir -> first dir reaching it
+	var walk func(dir, realDir string, outside bool) error
+	walk = func(dir, realDir string, outside bool) error {
+		walked[realDir] = dir
+		fis, err := ioutil.ReadDir(filepath.Join(cwd, dir))
+		if err != nil {
+			return err
+		}
+		var hasGoFiles bool
+		for _, fi := range fis {
+			name := fi.Name()
+			if fi.Mode()&os.ModeSymlink == 0 {
+				if fi.IsDir() && !skipDir(name) {
+					// A plain dir outside of the tree may already have
+					// been walked through a symlink to it (or to one of
+					// its ancestors) that was reached first.
+					sub, subReal := path.Join(dir, name), filepath.Join(realDir, name)
+					if first, present := walked[subReal]; present {
+						if pathHasPrefix(sub, first) {
+							warnf("ignoring dir %s, which is its own ancestor dir %s through a symlink (a cycle).", sub, first)
+						} else {
+							s.aliases[sub] = first
+						}
+					} else if err := walk(sub, subReal, outside); err != nil {
+						return err
+					}
+				} else if fi.Mode().IsRegular() && strings.HasSuffix(name, ".go") {
+					hasGoFiles = true
+				}
+				continue
+			}
+
+			link := path.Join(dir, name)
+			real, err := filepath.EvalSymlinks(filepath.Join(cwd, link))
+			if err != nil {
+				warnf("ignoring broken symlink %s: %s.", link, err)
+				continue
+			}
+			rfi, err := os.Stat(real)
+			if err != nil {
+				return err
+			}
+			if !rfi.IsDir() {
+				if rfi.Mode().IsRegular() && strings.HasSuffix(name, ".go") {
+					hasGoFiles = true
+				}
+				continue
+			}
+			if skipDir(name) {
+				continue
+			}
+
+			var target string
+			if pathHasPrefix(real, realCWD) {
+				// The real dir is walked (if it's not skipped) without
+				// following the symlink.
+				rel, err := filepath.Rel(realCWD, real)
+				if err != nil {
+					return err
+				}
+				target = filepath.ToSlash(rel)
+			} else if first, present := walked[real]; present {
+				target = first
+			}
+			if target != "" {
+				if pathHasPrefix(link, target) {
+					warnf("ignoring symlink %s to its own ancestor dir %s (a cycle).", link, target)
+					continue
+				}
+				s.aliases[link] = target
+			} else if err := walk(link, real, true); err != nil {
+				return err
+			}
+		}
+		if outside && hasGoFiles {
+			s.extraDirs = append(s.extraDirs, dir)
+		}
+		return nil
+	}
+	if err := walk(".", realCWD, false); err != nil {
+		return nil, err
+	}
+	sort.Strings(s.extraDirs)
+	return s, nil
+}
+
+// skipDir reports whether the go tool skips the directory name when
+// matching "./...".
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata"
+}
+
+// unitAliases returns the import paths through symlinks of the
+// packages whose source units are given, keyed by package (unit) name.
+func (s *symlinkedDirs) unitAliases(units []*unit.SourceUnit) map[string][]string {
+	aliases := map[string][]string{}
+	for _, u := range units {
+		dir := filepath.ToSlash(u.Dir)
+
+		// The import path of the current directory, if the package's
+		// import path ends with its dir.
+		var root string
+		if dir == "." {
+			root = u.Name
+		} else if strings.HasSuffix(u.Name, "/"+dir) {
+			root = strings.TrimSuffix(u.Name, "/"+dir)
+		} else {
+			continue
+		}
+
+		// Add the aliases of the package's dir and of its aliases, in
+		// case a symlink points into another symlink's dir.
+		dirs := []string{dir}
+		seen := map[string]bool{dir: true}
+		for i := 0; i < len(dirs); i++ {
+			for link, target := range s.aliases {
+				if !pathHasPrefix(dirs[i], target) {
+					continue
+				}
+				alias := path.Join(link, strings.TrimPrefix(dirs[i], target))
+				if !seen[alias] {
+					seen[alias] = true
+					dirs = append(dirs, alias)
+				}
+			}
+		}
+		for _, alias := range dirs[1:] {
+			aliases[u.Name] = append(aliases[u.Name], path.Join(root, alias))
+		}
+		sort.Strings(aliases[u.Name])
+	}
+	return aliases
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// writeTestSymlinks creates symlinks (whose paths are slash-separated
+// and relative to dir) to the given targets.
+func writeTestSymlinks(t *testing.T, dir string, links map[string]string) {
+	for name, target := range links {
+		if err := os.Symlink(filepath.FromSlash(target), filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setTestSymlinkTree creates a repository (returned) in a GOPATH (also
+// returned) in tmp, containing symlinks to dirs inside and outside of
+// it.
+func setTestSymlinkTree(t *testing.T, tmp string) (root, gopath string) {
+	gopath = filepath.Join(tmp, "gopath")
+	root = filepath.Join(gopath, "src", "example.com", "repo")
+	outside := filepath.Join(tmp, "outside")
+	writeTestFiles(t, root, map[string]string{"a/a.go": "package a\n"})
+	writeTestFiles(t, outside, map[string]string{
+		"e/e.go":       "package e\n",
+		"e/sub/sub.go": "package sub\n",
+		"o/o.go":       "package o\n",
+		"o/sub/sub.go": "package sub\n",
+		"x/x.go":       "package x\n",
+		"z/z.go":       "package z\n",
+	})
+	writeTestSymlinks(t, root, map[string]string{
+		// an in-tree alias, and a link to its own ancestor dir
+		"alias":  "a",
+		"a/self": "..",
+
+		// an out-of-tree link, and a later link to a plain dir
+		// inside of it
+		"ext":    filepath.Join(outside, "e"),
+		"extsub": filepath.Join(outside, "e", "sub"),
+
+		// a link to a plain dir inside of a later out-of-tree link
+		"b0": filepath.Join(outside, "o", "sub"),
+		"b1": filepath.Join(outside, "o"),
+
+		// an indirect cycle (c -> x, x/y -> z, z/w -> x)
+		"c": filepath.Join(outside, "x"),
+
+		// skipped, like the dirs with the same name
+		"_link": filepath.Join(outside, "o"),
+	})
+	writeTestSymlinks(t, outside, map[string]string{
+		// an out-of-tree link to its own ancestor dir
+		"e/back": ".",
+
+		// the rest of the indirect cycle
+		"x/y": filepath.Join("..", "z"),
+		"z/w": filepath.Join("..", "x"),
+	})
+	return root, gopath
+}
+
+func TestFindSymlinkedDirs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, gopath := setTestSymlinkTree(t, tmp)
+	defer setTestRepo(t, root, gopath, nil)()
+
+	s, err := findSymlinkedDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAliases := map[string]string{
+		"alias":  "a",
+		"extsub": "ext/sub",
+		"b1/sub": "b0",
+	}
+	if !reflect.DeepEqual(s.aliases, wantAliases) {
+		t.Errorf("got aliases %v, want %v", s.aliases, wantAliases)
+	}
+	wantExtraDirs := []string{"b0", "b1", "c", "c/y", "ext", "ext/sub"}
+	if !reflect.DeepEqual(s.extraDirs, wantExtraDirs) {
+		t.Errorf("got extra dirs %q, want %q", s.extraDirs, wantExtraDirs)
+	}
+
+	units := []*unit.SourceUnit{
+		{Name: "example.com/repo/a", Dir: "a"},
+		{Name: "example.com/repo/b0", Dir: "b0"},
+		{Name: "example.com/repo/ext/sub", Dir: "ext/sub"},
+		{Name: "example.com/repo/c", Dir: "c"},
+	}
+	wantUnitAliases := map[string][]string{
+		"example.com/repo/a":       {"example.com/repo/alias"},
+		"example.com/repo/b0":      {"example.com/repo/b1/sub"},
+		"example.com/repo/ext/sub": {"example.com/repo/extsub"},
+	}
+	if got := s.unitAliases(units); !reflect.DeepEqual(got, wantUnitAliases) {
+		t.Errorf("got unit aliases %v, want %v", got, wantUnitAliases)
+	}
+}
+
+// TestScanSymlinks tests that each package reachable through symlinks
+// is emitted once, and that symlinks are skipped with
+// --follow-symlinks=false.
+func TestScanSymlinks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, gopath := setTestSymlinkTree(t, tmp)
+
+	origWD, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(origWD)
+	origBuildContext, origBuildDefault, origConfig := buildContext, build.Default, config
+	defer func() {
+		buildContext, build.Default, config = origBuildContext, origBuildDefault, origConfig
+		loaderConfig.Build = &buildContext
+	}()
+	defer setTestRepo(t, root, gopath, nil)()
+
+	tests := []struct {
+		followSymlinks string
+		wantUnits      []string
+		wantAliases    map[string]string
+	}{
+		{
+			followSymlinks: "true",
+			wantUnits: []string{
+				"example.com/repo/a",
+				"example.com/repo/b0",
+				"example.com/repo/b1",
+				"example.com/repo/c",
+				"example.com/repo/c/y",
+				"example.com/repo/ext",
+				"example.com/repo/ext/sub",
+			},
+			wantAliases: map[string]string{
+				"example.com/repo/alias":  "example.com/repo/a",
+				"example.com/repo/b1/sub": "example.com/repo/b0",
+				"example.com/repo/extsub": "example.com/repo/ext/sub",
+			},
+		},
+		{
+			followSymlinks: "false",
+			wantUnits:      []string{"example.com/repo/a"},
+		},
+	}
+	for _, test := range tests {
+		config = &srcfileConfig{}
+		units, err := (&ScanCmd{FollowSymlinks: test.followSymlinks}).scanUnits()
+		if err != nil {
+			t.Errorf("--follow-symlinks=%s: %s", test.followSymlinks, err)
+			continue
+		}
+		var names []string
+		for _, u := range units {
+			names = append(names, u.Name)
+			var aliases map[string]string
+			if u.Config != nil {
+				aliases, _ = u.Config["SymlinkAliases"].(map[string]string)
+			}
+			if !reflect.DeepEqual(aliases, test.wantAliases) {
+				t.Errorf("--follow-symlinks=%s: %s: got aliases %v, want %v", test.followSymlinks, u.Name, aliases, test.wantAliases)
+			}
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, test.wantUnits) {
+			t.Errorf("--follow-symlinks=%s: got units %q, want %q", test.followSymlinks, names, test.wantUnits)
+		}
+	}
+}
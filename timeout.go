@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// timeoutGracePeriod is how long to wait after a timeout for an
+// operation to return partial results before giving up on it.
+const timeoutGracePeriod = 5 * time.Second
+
+// errTimedOut is returned by operations that were aborted because the
+// --timeout duration elapsed.
+var errTimedOut = errors.New("timed out")
+
+// timeoutContext returns a context whose deadline is the --timeout
+// duration from now (if a timeout was specified), for the operation
+// described by what (such as "graphing source unit foo"), and the func
+// that releases it. Each source unit gets its own, so that a unit that
+// times out doesn't shorten the time of the units after it.
+func timeoutContext(what string) (context.Context, context.CancelFunc) {
+	if globalOpt.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), globalOpt.Timeout)
+	go func() {
+		<-ctx.Done()
+		if ctx.Err() == context.DeadlineExceeded {
+			warnf("%s timed out after %s; aborting.", what, globalOpt.Timeout)
+		}
+	}()
+	return ctx, cancel
+}
+
+// isTimedOut reports whether ctx's deadline has passed (or ctx was
+// otherwise canceled).
+func isTimedOut(ctx context.Context) bool {
+	return ctx.Err() != nil
+}
+
+// killOnTimeout kills cmd's process (which must have been started) if
+// ctx is done before the returned func is called.
+func killOnTimeout(ctx context.Context, cmd *exec.Cmd) (done func()) {
+	c := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Kill()
+		case <-c:
+		}
+	}()
+	return func() { close(c) }
+}
+
+// runCmd runs cmd, killing it if ctx is done first.
+func runCmd(ctx context.Context, cmd *exec.Cmd) error {
+	if isTimedOut(ctx) {
+		return fmt.Errorf("%v: %s", cmd.Args, errTimedOut)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer killOnTimeout(ctx, cmd)()
+	if err := cmd.Wait(); err != nil {
+		if isTimedOut(ctx) {
+			return fmt.Errorf("%v: %s", cmd.Args, errTimedOut)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// splitXTestUnits moves the external test files (of package foo_test,
+// in the same dir as package foo) of each unit's package to a source
+// unit of their own, named after the package's import path with a
+// "_test" suffix, so that their defs don't collide with the package's.
+// The external test package's unit depends on the package (and
+// anything else that the external tests import), and its Data is the
+// same package (with goPackageData.XTest set).
+//
+// It must be called while the units' Files are still relative to
+// their dirs.
+func splitXTestUnits(units []*unit.SourceUnit) []*unit.SourceUnit {
+	var split []*unit.SourceUnit
+	for _, u := range units {
+		split = append(split, u)
+		pkg := u.Data.(*build.Package)
+		if len(pkg.XTestGoFiles) == 0 {
+			continue
+		}
+
+		isXTest := make(map[string]bool, len(pkg.XTestGoFiles))
+		for _, f := range pkg.XTestGoFiles {
+			isXTest[f] = true
+		}
+		var files, xtestFiles []string
+		for _, f := range u.Files {
+			if isXTest[f] {
+				xtestFiles = append(xtestFiles, f)
+			} else {
+				files = append(files, f)
+			}
+		}
+		u.Files = files
+		u.Dependencies = unitDependencies(pkg.Dir, pkg.Imports, pkg.TestImports)
+		if len(xtestFiles) == 0 {
+			// They're all ignored.
+			continue
+		}
+
+		var config map[string]interface{}
+		if u.Config != nil {
+			config = make(map[string]interface{}, len(u.Config))
+			for k, v := range u.Config {
+				config[k] = v
+			}
+		}
+		split = append(split, &unit.SourceUnit{
+			Name:         u.Name + "_test",
+			Type:         u.Type,
+			Repo:         u.Repo,
+			Dir:          u.Dir,
+			Files:        xtestFiles,
+			Data:         pkg,
+			Dependencies: unitDependencies(pkg.Dir, pkg.XTestImports),
+			Config:       config,
+			Ops:          u.Ops,
+		})
+	}
+	return split
+}
+
+// isXTestUnit returns whether u is the source unit of an external test
+// package (see splitXTestUnits).
+func isXTestUnit(u *unit.SourceUnit) (bool, error) {
+	data, err := json.Marshal(u.Data)
+	if err != nil {
+		return false, err
+	}
+	var d struct{ XTest bool }
+	if err := json.Unmarshal(data, &d); err != nil {
+		return false, err
+	}
+	return d.XTest, nil
+}
+
+// unitDependencies returns the imports (made absolute, if they're
+// local imports relative to dir) of a source unit, sorted and without
+// duplicates.
+func unitDependencies(dir string, imports ...[]string) []interface{} {
+	seen := map[string]bool{}
+	var sorted []string
+	for _, list := range imports {
+		for _, imp := range list {
+			absImp, err := absImportPath(imp, dir)
+			if err != nil {
+				warnf("%s.", err)
+				absImp = imp
+			}
+			if !seen[absImp] {
+				seen[absImp] = true
+				sorted = append(sorted, absImp)
+			}
+		}
+	}
+	sort.Strings(sorted)
+	deps := make([]interface{}, len(sorted))
+	for i, imp := range sorted {
+		deps[i] = imp
+	}
+	return deps
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestSplitXTestUnits(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-xtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tmp, "gopath")
+	defer setTestRepo(t, filepath.Join(gopath, "src", "example.com", "repo"), gopath, nil, "x/sub", "y")()
+
+	x := &build.Package{
+		Dir:          "x",
+		ImportPath:   "example.com/repo/x",
+		GoFiles:      []string{"x.go"},
+		TestGoFiles:  []string{"x_test.go"},
+		XTestGoFiles: []string{"x_ext_test.go", "x_ignored_test.go"},
+		Imports:      []string{"fmt", "./sub"},
+		TestImports:  []string{"testing", "fmt"},
+		XTestImports: []string{"testing", "example.com/repo/x", "../y"},
+	}
+	y := &build.Package{
+		Dir:         "y",
+		ImportPath:  "example.com/repo/y",
+		GoFiles:     []string{"y.go"},
+		TestGoFiles: []string{"y_test.go"},
+		Imports:     []string{"strings"},
+	}
+	// All of z's external test files are ignored (and so aren't in its
+	// unit's Files).
+	z := &build.Package{
+		Dir:          "z",
+		ImportPath:   "example.com/repo/z",
+		GoFiles:      []string{"z.go"},
+		XTestGoFiles: []string{"z_ext_test.go"},
+		Imports:      []string{"os"},
+		XTestImports: []string{"testing"},
+	}
+	ops := map[string]*toolchain.ToolRef{"graph": nil}
+	units := []*unit.SourceUnit{
+		{
+			Name:   x.ImportPath,
+			Type:   "GoPackage",
+			Dir:    x.Dir,
+			Files:  []string{"x.go", "x_test.go", "x_ext_test.go"},
+			Data:   x,
+			Config: map[string]interface{}{"GOOS": "linux"},
+			Ops:    ops,
+		},
+		{Name: y.ImportPath, Type: "GoPackage", Dir: y.Dir, Files: []string{"y.go", "y_test.go"}, Data: y},
+		{Name: z.ImportPath, Type: "GoPackage", Dir: z.Dir, Files: []string{"z.go"}, Data: z},
+	}
+	got := splitXTestUnits(units)
+
+	want := []*unit.SourceUnit{
+		{
+			Name:         x.ImportPath,
+			Type:         "GoPackage",
+			Dir:          x.Dir,
+			Files:        []string{"x.go", "x_test.go"},
+			Data:         x,
+			Dependencies: []interface{}{"example.com/repo/x/sub", "fmt", "testing"},
+			Config:       map[string]interface{}{"GOOS": "linux"},
+			Ops:          ops,
+		},
+		{
+			Name:         x.ImportPath + "_test",
+			Type:         "GoPackage",
+			Dir:          x.Dir,
+			Files:        []string{"x_ext_test.go"},
+			Data:         x,
+			Dependencies: []interface{}{"example.com/repo/x", "example.com/repo/y", "testing"},
+			Config:       map[string]interface{}{"GOOS": "linux"},
+			Ops:          ops,
+		},
+		// Units without external test files are left as they are.
+		{Name: y.ImportPath, Type: "GoPackage", Dir: y.Dir, Files: []string{"y.go", "y_test.go"}, Data: y},
+		{
+			Name:         z.ImportPath,
+			Type:         "GoPackage",
+			Dir:          z.Dir,
+			Files:        []string{"z.go"},
+			Data:         z,
+			Dependencies: []interface{}{"os"},
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d units, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("unit %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// The external test package's unit has a copy of the package's
+	// unit's Config.
+	got[1].Config["GOOS"] = "windows"
+	if goos := got[0].Config["GOOS"]; goos != "linux" {
+		t.Errorf("got package unit GOOS %v after changing the external test package unit's, want linux", goos)
+	}
+}
+
+func TestIsXTestUnit(t *testing.T) {
+	pkg := &build.Package{ImportPath: "example.com/x"}
+	tests := []struct {
+		data interface{}
+		want bool
+	}{
+		{data: pkg, want: false},
+		{data: &goPackageData{Package: pkg}, want: false},
+		{data: &goPackageData{Package: pkg, XTest: true}, want: true},
+
+		// After a round trip through JSON (as in the source units that
+		// depresolve and graph read).
+		{data: map[string]interface{}{"ImportPath": "example.com/x", "XTest": true}, want: true},
+		{data: map[string]interface{}{"ImportPath": "example.com/x"}, want: false},
+	}
+	for _, test := range tests {
+		got, err := isXTestUnit(&unit.SourceUnit{Name: pkg.ImportPath, Data: test.data})
+		if err != nil {
+			t.Errorf("%+v: %s", test.data, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%+v: got %v, want %v", test.data, got, test.want)
+		}
+	}
+}
+
+func TestUnitDependencies(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "srclib-go-xtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tmp, "gopath")
+	defer setTestRepo(t, filepath.Join(gopath, "src", "example.com", "repo"), gopath, nil, "a/b", "c")()
+
+	tests := []struct {
+		dir     string
+		imports [][]string
+		want    []interface{}
+	}{
+		{dir: "a", want: []interface{}{}},
+		{
+			// Sorted and without duplicates across the lists.
+			dir:     "a",
+			imports: [][]string{{"os", "fmt"}, {"fmt", "example.com/z"}},
+			want:    []interface{}{"example.com/z", "fmt", "os"},
+		},
+		{
+			// Local imports are made absolute, and then deduplicated.
+			dir:     "a",
+			imports: [][]string{{"./b", "../c"}, {"example.com/repo/c"}},
+			want:    []interface{}{"example.com/repo/a/b", "example.com/repo/c"},
+		},
+		{
+			// Unresolvable local imports are kept as they are.
+			dir:     "a",
+			imports: [][]string{{"../../../../../outside"}},
+			want:    []interface{}{"../../../../../outside"},
+		},
+	}
+	for _, test := range tests {
+		got := unitDependencies(test.dir, test.imports...)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q in %s: got %q, want %q", test.imports, test.dir, got, test.want)
+		}
+	}
+}